@@ -0,0 +1,21 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/runtime/executor"
+)
+
+// GetJumaImageJob returns the status of an asynchronous Juma image generation job,
+// including the images response once it has completed.
+func (h *Handler) GetJumaImageJob(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("id"))
+	job, ok := executor.LookupJumaImageJob(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
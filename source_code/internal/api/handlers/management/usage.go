@@ -18,3 +18,10 @@ func (h *Handler) GetUsageStatistics(c *gin.Context) {
 		"failed_requests": snapshot.FailureCount,
 	})
 }
+
+// GetExecutorMetrics returns provider executor counters in the Prometheus text format.
+func (h *Handler) GetExecutorMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	_ = usage.GetExecutorMetrics().WritePrometheus(c.Writer)
+}
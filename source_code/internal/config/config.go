@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"syscall"
 
@@ -77,6 +79,9 @@ type Config struct {
 	// JumaKey defines Juma.ai session token configurations for accessing Juma models.
 	JumaKey []JumaKey `yaml:"juma-api-key" json:"juma-api-key"`
 
+	// Juma defines behavior settings shared by all Juma credentials.
+	Juma JumaConfig `yaml:"juma" json:"juma"`
+
 	// ImageHosting defines the external image hosting service configuration.
 	// Used by Juma executor to upload base64 images and obtain accessible URLs.
 	ImageHosting ImageHosting `yaml:"image-hosting" json:"image-hosting"`
@@ -260,6 +265,10 @@ type JumaKey struct {
 	// SessionToken is the __Secure-next-auth.session-token cookie value from Juma.
 	SessionToken string `yaml:"session-token" json:"session-token"`
 
+	// SessionTokens lists further session tokens of the same Juma account. Requests rotate
+	// across all tokens, least recently used first, skipping tokens Juma rate limited.
+	SessionTokens []string `yaml:"session-tokens,omitempty" json:"session-tokens,omitempty"`
+
 	// WorkspaceID is the Juma workspace ID. If empty, it will be auto-detected.
 	WorkspaceID string `yaml:"workspace-id,omitempty" json:"workspace-id,omitempty"`
 
@@ -271,6 +280,310 @@ type JumaKey struct {
 	ProxyURL string `yaml:"proxy-url,omitempty" json:"proxy-url,omitempty"`
 }
 
+// JumaConfig groups executor behavior settings for the Juma provider.
+type JumaConfig struct {
+	// BaseURL overrides the Juma web API host (e.g. for enterprise tenants).
+	// If empty, https://app.juma.ai is used.
+	BaseURL string `yaml:"base-url,omitempty" json:"base-url,omitempty"`
+
+	// UserAgent overrides the User-Agent header sent with Juma and upload requests.
+	UserAgent string `yaml:"user-agent,omitempty" json:"user-agent,omitempty"`
+
+	// UserAgents optionally lists User-Agent values chosen round-robin per request.
+	// When non-empty it takes precedence over UserAgent.
+	UserAgents []string `yaml:"user-agents,omitempty" json:"user-agents,omitempty"`
+
+	// Locale is sent as Accept-Language on Juma chat and upload requests so localized
+	// responses use the desired language. Empty uses "en-US".
+	Locale string `yaml:"locale,omitempty" json:"locale,omitempty"`
+
+	// TRPC overrides how Juma's tRPC endpoints are called, so operators can follow Juma
+	// API changes without a release.
+	TRPC JumaTRPC `yaml:"trpc,omitempty" json:"trpc,omitempty"`
+
+	// RequestTimeout is the overall deadline in seconds for a Juma chat request, including
+	// reading the streamed response. Zero or negative values use the default of 300 seconds.
+	RequestTimeout int `yaml:"request-timeout,omitempty" json:"request-timeout,omitempty"`
+
+	// GzipRequestThreshold gzip-compresses chat request bodies larger than this many bytes
+	// and sends them with Content-Encoding: gzip. Zero disables compression; only enable it
+	// once the Juma deployment in use is confirmed to accept compressed bodies.
+	GzipRequestThreshold int `yaml:"gzip-request-threshold,omitempty" json:"gzip-request-threshold,omitempty"`
+
+	// IdleTimeout aborts a Juma response when no SSE line arrives within this many seconds.
+	// Zero or negative values use the default of 60 seconds.
+	IdleTimeout int `yaml:"idle-timeout,omitempty" json:"idle-timeout,omitempty"`
+
+	// KeepAliveInterval is how often, in seconds, a streaming response that has sent
+	// nothing emits an SSE keepalive comment so proxies do not drop the idle connection.
+	// Zero uses the default of 15 seconds; a negative value disables keepalives.
+	KeepAliveInterval int `yaml:"keepalive-interval,omitempty" json:"keepalive-interval,omitempty"`
+
+	// MaxConcurrentRequests limits in-flight Juma chat requests per auth, since Juma
+	// rate-limits per session. Zero or negative means no limit.
+	MaxConcurrentRequests int `yaml:"max-concurrent-requests,omitempty" json:"max-concurrent-requests,omitempty"`
+
+	// QueueTimeout is how many seconds a request waits for a free slot when
+	// MaxConcurrentRequests is reached before failing with 429. Zero uses the default of
+	// 30 seconds; a negative value fails excess requests immediately.
+	QueueTimeout int `yaml:"queue-timeout,omitempty" json:"queue-timeout,omitempty"`
+
+	// MaxChoices caps the OpenAI "n" parameter for Juma chat completions; each choice is
+	// a separate Juma request. Zero or negative values use the default of 4.
+	MaxChoices int `yaml:"max-choices,omitempty" json:"max-choices,omitempty"`
+
+	// RetryMaxAttempts is the total number of attempts for a Juma chat request that fails
+	// with 502, 503 or 504 before any response is streamed. Zero uses the default of 3;
+	// 1 disables retries.
+	RetryMaxAttempts int `yaml:"retry-max-attempts,omitempty" json:"retry-max-attempts,omitempty"`
+
+	// RetryBackoff is the delay in milliseconds before the first retry; it doubles on each
+	// further attempt, capped at 10 seconds. Zero or negative values use the default of 500.
+	RetryBackoff int `yaml:"retry-backoff,omitempty" json:"retry-backoff,omitempty"`
+
+	// UploadConcurrency limits how many images of a single message are uploaded in parallel,
+	// and how many generated images are rehosted in parallel on the image host.
+	// Zero or negative values use the default of 4.
+	UploadConcurrency int `yaml:"upload-concurrency,omitempty" json:"upload-concurrency,omitempty"`
+
+	// BatchPresign requests the presigned URLs for all images of a message in a single
+	// tRPC batch call. If the batch call fails, each image is presigned individually.
+	BatchPresign bool `yaml:"batch-presign,omitempty" json:"batch-presign,omitempty"`
+
+	// UploadImages uploads request images to Juma's file storage; nil means true. When
+	// false, images are passed inline as image parts and the presigned-URL/S3 flow is
+	// skipped. Documents are always uploaded.
+	UploadImages *bool `yaml:"upload-images,omitempty" json:"upload-images,omitempty"`
+
+	// UploadMetadataHeader echoes the ID, dimensions and byte size of images uploaded for a
+	// request as a JSON array in the X-Juma-Uploaded-Images response header.
+	UploadMetadataHeader bool `yaml:"upload-metadata-header,omitempty" json:"upload-metadata-header,omitempty"`
+
+	// UploadInitialDelay is how long, in milliseconds, to wait after an S3 upload before the
+	// file is referenced in chat, giving Juma time to create the knowledge item.
+	// Zero or negative values use the default of 2000.
+	UploadInitialDelay int `yaml:"upload-initial-delay,omitempty" json:"upload-initial-delay,omitempty"`
+
+	// UploadDelayJitter is the maximum random extra delay, in milliseconds, added to
+	// UploadInitialDelay to spread out concurrent uploads. Zero uses the default of 250;
+	// negative values disable jitter.
+	UploadDelayJitter int `yaml:"upload-delay-jitter,omitempty" json:"upload-delay-jitter,omitempty"`
+
+	// TranscodeImages re-encodes images in formats Juma rejects (e.g. HEIC, BMP) as PNG or
+	// JPEG before upload. Formats without a registered decoder are uploaded unchanged.
+	// Disabled by default because decoding and encoding cost CPU.
+	TranscodeImages bool `yaml:"transcode-images,omitempty" json:"transcode-images,omitempty"`
+
+	// StripImageMetadata re-encodes JPEG and PNG uploads to drop EXIF (including GPS
+	// position), XMP and text metadata. JPEGs are rotated upright first.
+	StripImageMetadata bool `yaml:"strip-image-metadata,omitempty" json:"strip-image-metadata,omitempty"`
+
+	// MaxSSELineBytes caps the size of a single SSE line read from Juma, which may carry
+	// base64-embedded images. Zero or negative values use the default of 20 MiB.
+	MaxSSELineBytes int `yaml:"max-sse-line-bytes,omitempty" json:"max-sse-line-bytes,omitempty"`
+
+	// UnredactedRequestLog disables masking of the session cookie and uploaded media URLs
+	// in recorded upstream request logs. Intended for debugging only.
+	UnredactedRequestLog bool `yaml:"unredacted-request-log,omitempty" json:"unredacted-request-log,omitempty"`
+
+	// ImageProgress streams a short "Generating image..." status line while Juma's image
+	// tools run, giving clients feedback and keeping the connection active.
+	ImageProgress bool `yaml:"image-progress,omitempty" json:"image-progress,omitempty"`
+
+	// InlineCitations appends sources cited by Juma's web-enabled models to the response
+	// text as a markdown "Sources" list. Citations are always reported as OpenAI
+	// url_citation annotations.
+	InlineCitations bool `yaml:"inline-citations,omitempty" json:"inline-citations,omitempty"`
+
+	// ResponseCache serves identical non-streaming chat requests from a short-lived cache.
+	ResponseCache JumaResponseCache `yaml:"response-cache,omitempty" json:"response-cache,omitempty"`
+
+	// StreamRecovery finishes streams that break off mid-response from the message Juma
+	// stored in the thread.
+	StreamRecovery JumaStreamRecovery `yaml:"stream-recovery,omitempty" json:"stream-recovery,omitempty"`
+
+	// ImageEditSystemPrompt replaces the built-in system prompt injected for image editing
+	// models (Nanobanana) and then discards client system messages. When empty, the
+	// built-in prompt is used and client system messages are kept after it.
+	ImageEditSystemPrompt string `yaml:"image-edit-system-prompt,omitempty" json:"image-edit-system-prompt,omitempty"`
+
+	// SystemPromptMode controls how OpenAI system messages reach Juma. "message" (default)
+	// sends them merged into one leading system message; "prepend" prepends them to the
+	// first user message instead.
+	SystemPromptMode string `yaml:"system-prompt-mode,omitempty" json:"system-prompt-mode,omitempty"`
+
+	// SystemPromptPrefix and SystemPromptSuffix are operator instructions placed before
+	// and after the client's system prompt on every Juma request, creating one when the
+	// client sends none. For image editing models they follow the image-edit prompt.
+	SystemPromptPrefix string `yaml:"system-prompt-prefix,omitempty" json:"system-prompt-prefix,omitempty"`
+	SystemPromptSuffix string `yaml:"system-prompt-suffix,omitempty" json:"system-prompt-suffix,omitempty"`
+
+	// KnowledgeItemSource is the "source" tag sent with each Juma knowledge item.
+	// Empty uses the default "AttachedNewContextSnippet".
+	KnowledgeItemSource string `yaml:"knowledge-item-source,omitempty" json:"knowledge-item-source,omitempty"`
+
+	// KnowledgeItemFallback decides what happens when an image upload still has no
+	// knowledge item ID after the KnowledgeItemRetries reruns: "uploaded-images-only"
+	// (default) attaches the image without a knowledge item, while "image-id" uses the
+	// image ID instead. "image-id" is opt-in because workspaces that reject it
+	// fail the request with a foreign key error.
+	KnowledgeItemFallback string `yaml:"knowledge-item-fallback,omitempty" json:"knowledge-item-fallback,omitempty"`
+
+	// KnowledgeItemRetries is how many times an image upload that returns no knowledge
+	// item ID is redone from the presign step. Zero uses the default of 1; negative
+	// disables the rerun.
+	KnowledgeItemRetries int `yaml:"knowledge-item-retries,omitempty" json:"knowledge-item-retries,omitempty"`
+
+	// OnImageUploadError decides what happens when request images cannot be uploaded:
+	// "drop" (default) sends the message without them, "note" prepends a visible note
+	// to the message text, and "fail" rejects the request.
+	OnImageUploadError string `yaml:"on-image-upload-error,omitempty" json:"on-image-upload-error,omitempty"`
+
+	// DryRun makes non-streaming Juma requests return the converted Juma request JSON
+	// instead of calling Juma. Attachments are not uploaded and carry placeholder IDs.
+	// Clients can also opt in per request with X-Juma-Dry-Run: true.
+	DryRun bool `yaml:"dry-run,omitempty" json:"dry-run,omitempty"`
+
+	// MaxContextMessages caps how many non-system messages are forwarded to Juma; older
+	// turns are dropped first. Zero or negative disables the limit.
+	MaxContextMessages int `yaml:"max-context-messages,omitempty" json:"max-context-messages,omitempty"`
+
+	// MaxContextTokens caps the estimated token count (about 4 characters per token, image
+	// payloads excluded) of the forwarded conversation. Zero or negative disables the limit.
+	MaxContextTokens int `yaml:"max-context-tokens,omitempty" json:"max-context-tokens,omitempty"`
+
+	// MaxOutputChars caps the characters of response text returned per request. Streams stop
+	// reading upstream and finish with finish_reason "length" at the cap; non-streaming
+	// responses are truncated the same way. Zero or negative disables the limit.
+	MaxOutputChars int `yaml:"max-output-chars,omitempty" json:"max-output-chars,omitempty"`
+
+	// Models curates which Juma models this deployment advertises in /v1/models and
+	// accepts. Filtered-out models are rejected when requested directly.
+	Models JumaModelFilter `yaml:"models,omitempty" json:"models,omitempty"`
+
+	// AliasMap maps incoming model names (e.g. "gpt-5.1") to Juma aliases
+	// (e.g. "juma-gpt-5.1"). Keys match case-insensitively and are advertised in
+	// /v1/models when their target is.
+	AliasMap map[string]string `yaml:"alias-map,omitempty" json:"alias-map,omitempty"`
+
+	// ImageJobs configures asynchronous image generation, requested with the
+	// X-Juma-Async header or an "async": true payload field.
+	ImageJobs JumaImageJobs `yaml:"image-jobs,omitempty" json:"image-jobs,omitempty"`
+
+	// ImageBackends names alternative backends for image-capable models, chosen per request
+	// with the X-Image-Backend header or an "image_backend" payload field. Unknown or
+	// missing backends use the requested model's defaults.
+	ImageBackends map[string]JumaImageBackend `yaml:"image-backends,omitempty" json:"image-backends,omitempty"`
+
+	// Moderation runs a content moderation check on the converted messages before they
+	// are sent to Juma; flagged requests are rejected with 403.
+	Moderation JumaModeration `yaml:"moderation,omitempty" json:"moderation,omitempty"`
+
+	// ReasoningPassthrough forwards Juma reasoning events to clients. Streaming responses
+	// carry them as reasoning_content deltas; non-streaming responses prepend a <thinking> block.
+	ReasoningPassthrough bool `yaml:"reasoning-passthrough,omitempty" json:"reasoning-passthrough,omitempty"`
+}
+
+// JumaModelFilter selects the advertised Juma models. An empty filter keeps every model.
+type JumaModelFilter struct {
+	// Providers keeps only models backed by these providers (e.g. "Anthropic", "OpenAI").
+	Providers []string `yaml:"providers,omitempty" json:"providers,omitempty"`
+
+	// Include keeps only aliases matching one of these glob patterns (e.g. "juma-claude-*").
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+
+	// Exclude drops aliases matching any of these glob patterns; it wins over Include.
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// JumaResponseCache configures caching of non-streaming Juma chat responses. Requests
+// naming a Juma thread or conversation and image model requests are never cached.
+type JumaResponseCache struct {
+	// Enable turns the cache on.
+	Enable bool `yaml:"enable" json:"enable"`
+
+	// TTL is how long a response is reused, in seconds. Zero uses the default of 60.
+	TTL int `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// JumaStreamRecovery configures recovery of interrupted Juma streams. When the
+// connection drops before the stream ends, the thread is fetched and the rest of the
+// stored assistant message is sent to the client.
+type JumaStreamRecovery struct {
+	// Enable turns recovery on. ThreadPath must be set as well.
+	Enable bool `yaml:"enable" json:"enable"`
+
+	// ThreadPath is the path, including query, of a Juma endpoint returning a thread with
+	// its messages; "{thread_id}" is replaced by the thread ID. Juma does not document
+	// such an endpoint, so there is no default.
+	ThreadPath string `yaml:"thread-path,omitempty" json:"thread-path,omitempty"`
+
+	// Attempts is how many times the thread is fetched while waiting for the message to
+	// be stored. Zero uses the default of 3.
+	Attempts int `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+
+	// Delay is the wait before each fetch, in seconds. Zero uses the default of 2.
+	Delay int `yaml:"delay,omitempty" json:"delay,omitempty"`
+}
+
+// JumaTRPC configures the headers and paths used for Juma's tRPC endpoints.
+type JumaTRPC struct {
+	// Headers are merged over the defaults (trpc-accept: application/jsonl,
+	// x-trpc-source: web); an empty value removes a default header.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+
+	// PresignedURLPath is the path, including query, of the presigned upload URL endpoint.
+	// Empty uses /api/trpc/fileStorage.createPresignedUrl?batch=1.
+	PresignedURLPath string `yaml:"presigned-url-path,omitempty" json:"presigned-url-path,omitempty"`
+}
+
+// JumaImageJobs configures asynchronous Juma image generation jobs.
+type JumaImageJobs struct {
+	// CallbackURL receives a POST with the final images response (or the error) when a
+	// job finishes. When empty, results are only available through the job status lookup.
+	CallbackURL string `yaml:"callback-url,omitempty" json:"callback-url,omitempty"`
+
+	// MaxJobs bounds the in-memory job table; the oldest finished jobs are evicted first.
+	// Zero uses the default of 100.
+	MaxJobs int `yaml:"max-jobs,omitempty" json:"max-jobs,omitempty"`
+}
+
+// JumaImageBackend is one entry of juma.image-backends.
+type JumaImageBackend struct {
+	// Model is the Juma alias to use instead of the requested image model (optional).
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+
+	// VendorConnectionID is the vendor connection to use (optional); it overrides the
+	// credential's vendor_connection_id and the model default.
+	VendorConnectionID string `yaml:"vendor-connection-id,omitempty" json:"vendor-connection-id,omitempty"`
+}
+
+// JumaModeration configures the pre-request moderation check. Without a moderator
+// installed on the executor, the OpenAI-compatible moderation endpoint is used.
+type JumaModeration struct {
+	// Enable turns the check on.
+	Enable bool `yaml:"enable" json:"enable"`
+
+	// Endpoint is the moderation API URL. Empty uses https://api.openai.com/v1/moderations.
+	Endpoint string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+
+	// APIKey is sent as a bearer token to Endpoint.
+	APIKey string `yaml:"api-key,omitempty" json:"-"`
+
+	// Model is the moderation model. Empty uses omni-moderation-latest.
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+
+	// Timeout bounds each moderation call, in seconds. Zero uses the default of 10.
+	Timeout int `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// IncludeImages also submits the uploaded image URLs for moderation.
+	IncludeImages bool `yaml:"include-images,omitempty" json:"include-images,omitempty"`
+
+	// FailClosed rejects requests with 503 when the moderation call itself fails.
+	// By default such requests are forwarded unchecked.
+	FailClosed bool `yaml:"fail-closed,omitempty" json:"fail-closed,omitempty"`
+}
+
 // ImageHosting represents the configuration for external image hosting service.
 // Used to upload base64 images and obtain public URLs for services that require them.
 type ImageHosting struct {
@@ -280,8 +593,58 @@ type ImageHosting struct {
 	// Endpoint is the API endpoint URL for uploading images.
 	Endpoint string `yaml:"endpoint" json:"endpoint"`
 
-	// APIKey is the authentication key for the image hosting service.
+	// APIKey is the authentication key for the image hosting service. Leave it empty for
+	// hosts that accept uploads without one.
 	APIKey string `yaml:"api-key" json:"api-key"`
+
+	// AccessLevel is sent as the access_level form field. Empty uses "public";
+	// "none" omits the field for hosts that do not support it.
+	AccessLevel string `yaml:"access-level,omitempty" json:"access-level,omitempty"`
+
+	// Optimize is sent as the optimize form field ("true" or "false"). Empty uses "true";
+	// "none" omits the field for hosts that do not support it.
+	Optimize string `yaml:"optimize,omitempty" json:"optimize,omitempty"`
+
+	// AllowedMimeTypes lists media types accepted for upload (e.g. "image/gif", "video/mp4").
+	// If empty, JPEG, PNG, GIF, WebP, BMP and MP4 are allowed. Base64 uploads are checked
+	// against the sniffed content rather than the declared type.
+	AllowedMimeTypes []string `yaml:"allowed-mime-types,omitempty" json:"allowed-mime-types,omitempty"`
+
+	// MaxSizeBytes optionally caps the decoded upload size per MIME type.
+	MaxSizeBytes map[string]int64 `yaml:"max-size-bytes,omitempty" json:"max-size-bytes,omitempty"`
+
+	// FailureThreshold is the number of consecutive upload failures that opens the circuit
+	// breaker, skipping uploads until the cooldown elapses. Zero uses the default of 5.
+	FailureThreshold int `yaml:"failure-threshold,omitempty" json:"failure-threshold,omitempty"`
+
+	// CooldownSeconds is how long the circuit breaker stays open before probing the
+	// endpoint again. Zero uses the default of 60 seconds.
+	CooldownSeconds int `yaml:"cooldown-seconds,omitempty" json:"cooldown-seconds,omitempty"`
+
+	// TLS customises certificate verification for self-hosted image hosts.
+	TLS ImageHostingTLS `yaml:"tls,omitempty" json:"tls,omitempty"`
+
+	// OnFailure decides what UploadBase64Image returns when a data URL cannot be hosted:
+	// "keep-data-url" (default) returns the original data URL alongside the error, and
+	// "fail" returns no URL so callers cannot forward the data URL by accident.
+	OnFailure string `yaml:"on-failure,omitempty" json:"on-failure,omitempty"`
+
+	// InlineMaxBytes limits "keep-data-url" to images of at most this many decoded bytes;
+	// larger images fail instead. Zero keeps data URLs of any size.
+	InlineMaxBytes int64 `yaml:"inline-max-bytes,omitempty" json:"inline-max-bytes,omitempty"`
+
+	// StripMetadata re-encodes JPEG and PNG images before upload to drop EXIF (including
+	// GPS position), XMP and text metadata. JPEGs are rotated upright first.
+	StripMetadata bool `yaml:"strip-metadata,omitempty" json:"strip-metadata,omitempty"`
+}
+
+// ImageHostingTLS configures the TLS client used for image hosting uploads.
+type ImageHostingTLS struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots (e.g. a private CA).
+	CAFile string `yaml:"ca-file,omitempty" json:"ca-file,omitempty"`
+
+	// InsecureSkipVerify disables certificate verification. Only for testing.
+	InsecureSkipVerify bool `yaml:"insecure-skip-verify,omitempty" json:"insecure-skip-verify,omitempty"`
 }
 
 // OpenAICompatibility represents the configuration for OpenAI API compatibility
@@ -418,6 +781,16 @@ func LoadConfigOptional(configFile string, optional bool) (*Config, error) {
 	// Sanitize OpenAI compatibility providers: drop entries without base-url
 	cfg.SanitizeOpenAICompatibility()
 
+	// Validate Juma settings such as the base URL override
+	if err = cfg.ValidateJuma(); err != nil {
+		return nil, fmt.Errorf("invalid juma config: %w", err)
+	}
+
+	// Validate the image hosting endpoint so misconfiguration fails at startup
+	if err = cfg.ValidateImageHosting(); err != nil {
+		return nil, fmt.Errorf("invalid image-hosting config: %w", err)
+	}
+
 	// Normalize OAuth provider model exclusion map.
 	cfg.OAuthExcludedModels = NormalizeOAuthExcludedModels(cfg.OAuthExcludedModels)
 
@@ -496,7 +869,14 @@ func (cfg *Config) SanitizeJumaKeys() {
 		entry.WorkspaceID = strings.TrimSpace(entry.WorkspaceID)
 		entry.VendorConnectionID = strings.TrimSpace(entry.VendorConnectionID)
 		entry.ProxyURL = strings.TrimSpace(entry.ProxyURL)
-		
+		var extraTokens []string
+		for _, token := range entry.SessionTokens {
+			if token = strings.TrimSpace(token); token != "" && token != entry.SessionToken {
+				extraTokens = append(extraTokens, token)
+			}
+		}
+		entry.SessionTokens = extraTokens
+
 		if _, exists := seen[entry.SessionToken]; exists {
 			continue
 		}
@@ -506,6 +886,167 @@ func (cfg *Config) SanitizeJumaKeys() {
 	cfg.JumaKey = out
 }
 
+// ValidateImageHosting trims the image hosting endpoint and API key and, when hosting is
+// enabled, requires an absolute http(s) endpoint URL. The API key is optional, for hosts
+// that accept anonymous uploads.
+func (cfg *Config) ValidateImageHosting() error {
+	if cfg == nil {
+		return nil
+	}
+	cfg.ImageHosting.Endpoint = strings.TrimSpace(cfg.ImageHosting.Endpoint)
+	cfg.ImageHosting.APIKey = strings.TrimSpace(cfg.ImageHosting.APIKey)
+	cfg.ImageHosting.OnFailure = strings.ToLower(strings.TrimSpace(cfg.ImageHosting.OnFailure))
+	switch cfg.ImageHosting.OnFailure {
+	case "", "keep-data-url", "fail":
+	default:
+		return fmt.Errorf("on-failure %q must be \"keep-data-url\" or \"fail\"", cfg.ImageHosting.OnFailure)
+	}
+	if !cfg.ImageHosting.Enable {
+		return nil
+	}
+
+	endpoint := cfg.ImageHosting.Endpoint
+	if endpoint == "" {
+		return fmt.Errorf("endpoint is required when image hosting is enabled")
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("endpoint %q: %w", endpoint, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("endpoint %q must be an absolute http(s) URL", endpoint)
+	}
+	return nil
+}
+
+// ValidateJuma normalizes Juma settings and rejects a malformed base URL override.
+func (cfg *Config) ValidateJuma() error {
+	if cfg == nil {
+		return nil
+	}
+	cfg.Juma.UserAgent = strings.TrimSpace(cfg.Juma.UserAgent)
+	userAgents := make([]string, 0, len(cfg.Juma.UserAgents))
+	for _, ua := range cfg.Juma.UserAgents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			userAgents = append(userAgents, ua)
+		}
+	}
+	cfg.Juma.UserAgents = userAgents
+	cfg.Juma.Locale = strings.TrimSpace(cfg.Juma.Locale)
+	cfg.Juma.SystemPromptMode = strings.ToLower(strings.TrimSpace(cfg.Juma.SystemPromptMode))
+	switch cfg.Juma.SystemPromptMode {
+	case "", "message", "prepend":
+	default:
+		return fmt.Errorf("system-prompt-mode %q must be \"message\" or \"prepend\"", cfg.Juma.SystemPromptMode)
+	}
+
+	cfg.Juma.KnowledgeItemFallback = strings.ToLower(strings.TrimSpace(cfg.Juma.KnowledgeItemFallback))
+	switch cfg.Juma.KnowledgeItemFallback {
+	case "", "image-id", "uploaded-images-only":
+	case "retry":
+		return fmt.Errorf("knowledge-item-fallback \"retry\" is no longer supported; uploads are always rerun per knowledge-item-retries, so use \"uploaded-images-only\"")
+	default:
+		return fmt.Errorf("knowledge-item-fallback %q must be \"uploaded-images-only\" or \"image-id\"", cfg.Juma.KnowledgeItemFallback)
+	}
+
+	cfg.Juma.OnImageUploadError = strings.ToLower(strings.TrimSpace(cfg.Juma.OnImageUploadError))
+	switch cfg.Juma.OnImageUploadError {
+	case "", "drop", "note", "fail":
+	default:
+		return fmt.Errorf("on-image-upload-error %q must be \"drop\", \"note\" or \"fail\"", cfg.Juma.OnImageUploadError)
+	}
+
+	if len(cfg.Juma.ImageBackends) > 0 {
+		backends := make(map[string]JumaImageBackend, len(cfg.Juma.ImageBackends))
+		for name, backend := range cfg.Juma.ImageBackends {
+			name = strings.ToLower(strings.TrimSpace(name))
+			backend.Model = strings.TrimSpace(backend.Model)
+			backend.VendorConnectionID = strings.TrimSpace(backend.VendorConnectionID)
+			if name == "" || (backend.Model == "" && backend.VendorConnectionID == "") {
+				return fmt.Errorf("image-backends entry %q must name a model or a vendor-connection-id", name)
+			}
+			backends[name] = backend
+		}
+		cfg.Juma.ImageBackends = backends
+	}
+
+	if len(cfg.Juma.AliasMap) > 0 {
+		aliases := make(map[string]string, len(cfg.Juma.AliasMap))
+		for name, target := range cfg.Juma.AliasMap {
+			name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+			if name == "" || target == "" {
+				return fmt.Errorf("alias-map entry %q: %q must name both a model and a Juma alias", name, target)
+			}
+			aliases[name] = target
+		}
+		cfg.Juma.AliasMap = aliases
+	}
+
+	for _, patterns := range [][]string{cfg.Juma.Models.Include, cfg.Juma.Models.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.ToLower(strings.TrimSpace(pattern)), ""); err != nil {
+				return fmt.Errorf("models pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	if cfg.Juma.KnowledgeItemSource != "" {
+		cfg.Juma.KnowledgeItemSource = strings.TrimSpace(cfg.Juma.KnowledgeItemSource)
+		if cfg.Juma.KnowledgeItemSource == "" {
+			return fmt.Errorf("knowledge-item-source must not be blank")
+		}
+	}
+
+	cfg.Juma.TRPC.PresignedURLPath = strings.TrimSpace(cfg.Juma.TRPC.PresignedURLPath)
+	if p := cfg.Juma.TRPC.PresignedURLPath; p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("trpc presigned-url-path %q must start with \"/\"", p)
+	}
+
+	cfg.Juma.StreamRecovery.ThreadPath = strings.TrimSpace(cfg.Juma.StreamRecovery.ThreadPath)
+	if cfg.Juma.StreamRecovery.Enable {
+		p := cfg.Juma.StreamRecovery.ThreadPath
+		if !strings.HasPrefix(p, "/") || !strings.Contains(p, "{thread_id}") {
+			return fmt.Errorf("stream-recovery thread-path %q must start with \"/\" and contain {thread_id}", p)
+		}
+	}
+
+	cfg.Juma.ImageJobs.CallbackURL = strings.TrimSpace(cfg.Juma.ImageJobs.CallbackURL)
+	if callback := cfg.Juma.ImageJobs.CallbackURL; callback != "" {
+		parsed, err := url.Parse(callback)
+		if err != nil {
+			return fmt.Errorf("image-jobs callback-url %q: %w", callback, err)
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("image-jobs callback-url %q must be an absolute http(s) URL", callback)
+		}
+	}
+
+	cfg.Juma.Moderation.Endpoint = strings.TrimSpace(cfg.Juma.Moderation.Endpoint)
+	if endpoint := cfg.Juma.Moderation.Endpoint; endpoint != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("moderation endpoint %q: %w", endpoint, err)
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("moderation endpoint %q must be an absolute http(s) URL", endpoint)
+		}
+	}
+
+	base := strings.TrimRight(strings.TrimSpace(cfg.Juma.BaseURL), "/")
+	cfg.Juma.BaseURL = base
+	if base == "" {
+		return nil
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("base-url %q: %w", base, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("base-url %q must be an absolute http(s) URL", base)
+	}
+	return nil
+}
+
 // SanitizeClaudeKeys normalizes headers for Claude credentials.
 func (cfg *Config) SanitizeClaudeKeys() {
 	if cfg == nil || len(cfg.ClaudeKey) == 0 {
@@ -0,0 +1,155 @@
+// Package jumaclient is a Juma.ai HTTP client independent of the proxy executor. It
+// covers the chat stream endpoint, presigned uploads to Juma's object storage and the
+// known model IDs, so CLI tools, tests and admin tasks can talk to Juma directly.
+package jumaclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the hosted Juma app.
+	DefaultBaseURL = "https://app.juma.ai"
+	// SessionCookieName is the cookie carrying the Juma session token.
+	SessionCookieName = "__Secure-next-auth.session-token"
+	// DefaultUserAgent is sent when no User-Agent is set on the client.
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"
+	// DefaultLocale is the Accept-Language sent when no locale is set on the client.
+	DefaultLocale = "en-US"
+	// DefaultPresignedURLPath is Juma's tRPC endpoint issuing presigned upload URLs.
+	DefaultPresignedURLPath = "/api/trpc/fileStorage.createPresignedUrl?batch=1"
+	// ChatStreamPath is Juma's streaming chat endpoint.
+	ChatStreamPath = "/api/chat/stream"
+)
+
+// DefaultTRPCHeaders are the headers Juma's web client sends on tRPC requests.
+var DefaultTRPCHeaders = map[string]string{
+	"trpc-accept":   "application/jsonl",
+	"x-trpc-source": "web",
+}
+
+// Doer is the part of *http.Client the Juma client uses.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client talks to Juma on behalf of one session. The exported fields may be changed
+// after New and before the client is used.
+type Client struct {
+	httpClient Doer
+
+	// SessionToken is the next-auth session cookie value.
+	SessionToken string
+	// WorkspaceID is sent as x-workspace-id on tRPC requests.
+	WorkspaceID string
+
+	BaseURL   string
+	UserAgent string
+	Locale    string
+	// TRPCHeaders are set on tRPC requests; an empty value is skipped.
+	TRPCHeaders map[string]string
+	// PresignedURLPath is the tRPC path requested by CreatePresignedURL.
+	PresignedURLPath string
+}
+
+// New returns a client for the hosted Juma app using httpClient, which is usually an
+// *http.Client. A nil httpClient uses http.DefaultClient.
+func New(httpClient Doer, sessionToken, workspaceID string) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		httpClient:       httpClient,
+		SessionToken:     sessionToken,
+		WorkspaceID:      workspaceID,
+		BaseURL:          DefaultBaseURL,
+		UserAgent:        DefaultUserAgent,
+		Locale:           DefaultLocale,
+		TRPCHeaders:      DefaultTRPCHeaders,
+		PresignedURLPath: DefaultPresignedURLPath,
+	}
+}
+
+// StatusError is returned when Juma answers with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("juma request failed with status %d: %s", e.StatusCode, strings.TrimSpace(string(e.Body)))
+}
+
+// baseURL returns BaseURL without a trailing slash, or DefaultBaseURL when unset.
+func (c *Client) baseURL() string {
+	if base := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/"); base != "" {
+		return base
+	}
+	return DefaultBaseURL
+}
+
+// newAppRequest builds a request to the Juma app carrying the browser headers and the
+// session cookie.
+func (c *Client) newAppRequest(ctx context.Context, method, urlPath string, body io.Reader) (*http.Request, error) {
+	baseURL := c.baseURL()
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+urlPath, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Origin", baseURL)
+	c.setClientHeaders(req)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.SessionToken})
+	return req, nil
+}
+
+// setClientHeaders sets the User-Agent and Accept-Language sent on every request.
+func (c *Client) setClientHeaders(req *http.Request) {
+	userAgent, locale := c.UserAgent, c.Locale
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept-Language", locale)
+}
+
+// NewChatStreamRequest builds the chat stream request for an encoded Juma chat body.
+// With gzipped set, body is already gzip-compressed and Content-Encoding is set.
+func (c *Client) NewChatStreamRequest(ctx context.Context, body []byte, gzipped bool) (*http.Request, error) {
+	req, err := c.newAppRequest(ctx, http.MethodPost, ChatStreamPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// ChatStream sends an encoded Juma chat body and returns the streaming response, whose
+// body the caller must close. A non-2xx answer is returned as a *StatusError.
+func (c *Client) ChatStream(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := c.NewChatStreamRequest(ctx, body, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: respBody}
+	}
+	return resp, nil
+}
@@ -0,0 +1,28 @@
+package jumaclient
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// stubDoer answers every request with status and body and keeps the last request.
+type stubDoer struct {
+	status int
+	body   string
+	req    *http.Request
+	sent   []byte
+}
+
+func (d *stubDoer) Do(req *http.Request) (*http.Response, error) {
+	d.req = req
+	if req.Body != nil {
+		d.sent, _ = io.ReadAll(req.Body)
+	}
+	return &http.Response{
+		StatusCode: d.status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewBufferString(d.body)),
+		Request:    req,
+	}, nil
+}
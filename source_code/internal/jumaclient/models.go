@@ -0,0 +1,13 @@
+package jumaclient
+
+// Juma model and vendor connection IDs. Juma has no public model listing endpoint;
+// these were obtained through API exploration. The executor's model table maps its
+// aliases onto them.
+const (
+	ModelIDGPT51              = "401637fa-151b-41f5-aa36-5416ad1314fb"
+	ModelIDClaudeOpus45       = "790cee03-6d71-4b6a-bf86-7781c9592028"
+	ModelIDGemini3Pro         = "c073a0c0-e3d0-4e0b-b36c-29584b674125"
+	VendorConnectionOpenAI    = "f5275937-68f8-4bfe-b195-c48f2155263b"
+	VendorConnectionAnthropic = "f958317e-9359-42cc-8c45-4ed306bf5f65"
+	VendorConnectionGoogle    = "2eb35c4f-3afe-4d12-b953-70b5c8bb643e"
+)
@@ -0,0 +1,263 @@
+package jumaclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// PresignRequest describes one file to request a presigned upload URL for.
+type PresignRequest struct {
+	Name     string
+	MimeType string
+	Size     int
+}
+
+// PresignedURL is a presigned upload issued by Juma.
+type PresignedURL struct {
+	ImageID         string
+	KnowledgeItemID string // This is the ID needed for knowledgeItems in chat request
+	ImageURL        string
+	PresignedURL    string
+	Fields          map[string]string
+	FieldOrder      []string // Field names in the order the presigned response listed them
+	Name            string   // Stored object name, used to match batch results to uploads
+}
+
+// CreatePresignedURL requests a presigned upload URL for one file.
+func (c *Client) CreatePresignedURL(ctx context.Context, file PresignRequest) (*PresignedURL, error) {
+	payload := map[string]any{"0": presignEntry(file)}
+	entries, err := c.postPresign(ctx, c.presignedURLPath(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("failed to parse presigned URL response")
+	}
+	return entries[0], nil
+}
+
+// CreatePresignedURLs requests presigned URLs for several files in one tRPC batch call.
+// Results are matched to files by the stored object's name; a file left without a URL
+// fails the whole batch so callers can fall back to CreatePresignedURL.
+func (c *Client) CreatePresignedURLs(ctx context.Context, files []PresignRequest) ([]*PresignedURL, error) {
+	payload := make(map[string]any, len(files))
+	for i, file := range files {
+		payload[strconv.Itoa(i)] = presignEntry(file)
+	}
+	entries, err := c.postPresign(ctx, batchPath(c.presignedURLPath(), len(files)), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]*PresignedURL, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name] = append(byName[entry.Name], entry)
+	}
+	results := make([]*PresignedURL, len(files))
+	for i, file := range files {
+		matches := byName[file.Name]
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("presigned URL batch response has no entry for %q", file.Name)
+		}
+		results[i], byName[file.Name] = matches[0], matches[1:]
+	}
+	return results, nil
+}
+
+// presignedURLPath returns PresignedURLPath, or DefaultPresignedURLPath when unset.
+func (c *Client) presignedURLPath() string {
+	if c.PresignedURLPath != "" {
+		return c.PresignedURLPath
+	}
+	return DefaultPresignedURLPath
+}
+
+// batchPath repeats the procedure in urlPath n times, the way tRPC batches several
+// calls of one procedure into a single request.
+func batchPath(urlPath string, n int) string {
+	route, query, hasQuery := strings.Cut(urlPath, "?")
+	prefix, procedure := "", route
+	if idx := strings.LastIndex(route, "/"); idx >= 0 {
+		prefix, procedure = route[:idx+1], route[idx+1:]
+	}
+	procedures := make([]string, n)
+	for i := range procedures {
+		procedures[i] = procedure
+	}
+	batched := prefix + strings.Join(procedures, ",")
+	if hasQuery {
+		batched += "?" + query
+	}
+	return batched
+}
+
+// presignEntry builds the tRPC input asking for one presigned upload URL.
+func presignEntry(file PresignRequest) map[string]any {
+	return map[string]any{
+		"json": map[string]any{
+			"type":      "Knowledge",
+			"threadId":  nil,
+			"name":      file.Name,
+			"mimeType":  file.MimeType,
+			"imageSize": file.Size,
+		},
+		"meta": map[string]any{
+			"values": map[string]any{
+				"threadId": []string{"undefined"},
+			},
+			"v": 1,
+		},
+	}
+}
+
+// postPresign sends a presigned URL request to urlPath and returns every presigned
+// entry found in the JSONL response, in response order.
+func (c *Client) postPresign(ctx context.Context, urlPath string, payload map[string]any) ([]*PresignedURL, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newAppRequest(ctx, http.MethodPost, urlPath, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-workspace-id", c.WorkspaceID)
+	for name, value := range c.TRPCHeaders {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("presigned URL request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse the JSONL response - collect every line with a presignedUrl
+	scanner := bufio.NewScanner(resp.Body)
+	var entries []*PresignedURL
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "presignedUrl") {
+			continue
+		}
+		// Log full response for debugging
+		log.Debugf("juma upload: presigned URL response line: %s", line)
+
+		// Navigate to the data: json[2][0][0] has the image and presignedUrl
+		imageData := gjson.Parse(line).Get("json.2.0.0")
+		if !imageData.Exists() {
+			continue
+		}
+		if entry := parsePresignedEntry(imageData); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read presigned URL response: %w", err)
+	}
+
+	return entries, nil
+}
+
+// parsePresignedEntry extracts one presigned upload from a response entry, or returns
+// nil when the entry lacks an object URL or presigned URL.
+func parsePresignedEntry(imageData gjson.Result) *PresignedURL {
+	imageID := imageData.Get("image.id").String()
+	imageURL := imageData.Get("image.imageUrl").String()
+	name := imageData.Get("image.name").String()
+	// Document uploads report the stored object under "file" instead of "image"
+	if imageID == "" {
+		imageID = imageData.Get("file.id").String()
+	}
+	if imageURL == "" {
+		imageURL = imageData.Get("file.fileUrl").String()
+	}
+	if imageURL == "" {
+		imageURL = imageData.Get("file.url").String()
+	}
+	if name == "" {
+		name = imageData.Get("file.name").String()
+	}
+	presignedURL := imageData.Get("presignedUrl").String()
+
+	// Extract knowledge item id - this is the ID we need for the chat API.
+	knowledgeItemID := extractKnowledgeItemID(imageData, imageID)
+	log.WithFields(log.Fields{"provider": "juma", "image_id": imageID, "knowledge_item_id": knowledgeItemID}).Debug("juma upload: extracted IDs")
+
+	if imageURL == "" || presignedURL == "" {
+		return nil
+	}
+
+	// Extract fields
+	fields := make(map[string]string)
+	var fieldOrder []string
+	imageData.Get("fields").ForEach(func(key, value gjson.Result) bool {
+		if _, seen := fields[key.String()]; !seen {
+			fieldOrder = append(fieldOrder, key.String())
+		}
+		fields[key.String()] = value.String()
+		return true
+	})
+
+	return &PresignedURL{
+		ImageID:         imageID,
+		KnowledgeItemID: knowledgeItemID,
+		ImageURL:        imageURL,
+		PresignedURL:    presignedURL,
+		Fields:          fields,
+		FieldOrder:      fieldOrder,
+		Name:            name,
+	}
+}
+
+func extractKnowledgeItemID(imageData gjson.Result, imageID string) string {
+	// When type="Knowledge", the image.id IS the knowledge item ID that can be used
+	// directly in knowledgeItems for the chat API. This was confirmed by analyzing
+	// Juma's web client behavior - the same ID returned in image.id is used in
+	// knowledgeItems[].id when sending chat messages with images.
+	imageType := imageData.Get("image.type").String()
+	if imageType == "Knowledge" && imageID != "" {
+		log.Debugf("juma upload: type=Knowledge, using image.id as knowledgeItemId: %s", imageID)
+		return imageID
+	}
+
+	// Fallback: try to find explicit knowledgeItemId fields (for future API changes)
+	candidates := []string{
+		imageData.Get("knowledgeItem.id").String(),
+		imageData.Get("knowledgeItemId").String(),
+		imageData.Get("knowledgeItemID").String(),
+		imageData.Get("knowledge.id").String(),
+		imageData.Get("image.knowledgeItemId").String(),
+		imageData.Get("image.knowledgeItem.id").String(),
+		imageData.Get("knowledgeItem.knowledgeItemId").String(),
+	}
+	for _, candidate := range candidates {
+		trimmed := strings.TrimSpace(candidate)
+		if trimmed != "" {
+			return trimmed
+		}
+	}
+
+	// No knowledge item yet; callers decide how to attach the upload.
+	return ""
+}
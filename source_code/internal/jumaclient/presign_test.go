@@ -0,0 +1,65 @@
+package jumaclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestBatchPath(t *testing.T) {
+	got := batchPath(DefaultPresignedURLPath, 3)
+	want := "/api/trpc/fileStorage.createPresignedUrl,fileStorage.createPresignedUrl,fileStorage.createPresignedUrl?batch=1"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCreatePresignedURLs(t *testing.T) {
+	// Entries come back out of request order; they are matched by name.
+	doer := &stubDoer{status: http.StatusOK, body: "{\"json\":{\"0\":[]}}\n" +
+		`{"json":[2,0,[[{"image":{"id":"img-b","imageUrl":"https://cdn.invalid/b.png","name":"b.png","type":"Knowledge"},"presignedUrl":"https://s3.invalid/","fields":{"key":"b","Policy":"p"}}]]]}` + "\n" +
+		`{"json":[2,0,[[{"image":{"id":"img-a","imageUrl":"https://cdn.invalid/a.png","name":"a.png"},"knowledgeItemId":"ki-a","presignedUrl":"https://s3.invalid/","fields":{"key":"a","Content-Type":"image/png"}}]]]}` + "\n"}
+	client := New(doer, "token", "workspace")
+
+	got, err := client.CreatePresignedURLs(context.Background(), []PresignRequest{
+		{Name: "a.png", MimeType: "image/png", Size: 1},
+		{Name: "b.png", MimeType: "image/png", Size: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0].ImageID != "img-a" || got[1].ImageID != "img-b" {
+		t.Fatalf("expected results in request order, got %+v", got)
+	}
+	if got[0].KnowledgeItemID != "ki-a" || got[1].KnowledgeItemID != "img-b" {
+		t.Errorf("unexpected knowledge item IDs %q and %q", got[0].KnowledgeItemID, got[1].KnowledgeItemID)
+	}
+	if order := got[0].FieldOrder; len(order) != 2 || order[0] != "key" || order[1] != "Content-Type" {
+		t.Errorf("expected the response field order, got %v", order)
+	}
+
+	if doer.req.URL.Path != "/api/trpc/fileStorage.createPresignedUrl,fileStorage.createPresignedUrl" {
+		t.Errorf("unexpected batch path %q", doer.req.URL.Path)
+	}
+	if doer.req.Header.Get("x-workspace-id") != "workspace" {
+		t.Errorf("expected the workspace header, got %q", doer.req.Header.Get("x-workspace-id"))
+	}
+	if cookie, errCookie := doer.req.Cookie(SessionCookieName); errCookie != nil || cookie.Value != "token" {
+		t.Errorf("expected the session cookie, got %v", cookie)
+	}
+	if name := gjson.GetBytes(doer.sent, "1.json.name").String(); name != "b.png" {
+		t.Errorf("expected the second batch entry to name b.png, got %q", name)
+	}
+}
+
+func TestCreatePresignedURLs_MissingEntryFailsBatch(t *testing.T) {
+	doer := &stubDoer{status: http.StatusOK, body: `{"json":[2,0,[[{"image":{"id":"img-a","imageUrl":"https://cdn.invalid/a.png","name":"a.png"},"presignedUrl":"https://s3.invalid/"}]]]}`}
+	client := New(doer, "token", "workspace")
+
+	_, err := client.CreatePresignedURLs(context.Background(), []PresignRequest{{Name: "a.png"}, {Name: "b.png"}})
+	if err == nil {
+		t.Fatal("expected an error when a file has no presigned URL")
+	}
+}
@@ -0,0 +1,146 @@
+package jumaclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UploadToStorage posts data to the object storage URL of a presigned upload. report,
+// when set, receives the bytes of the multipart body sent so far and its total size.
+func (c *Client) UploadToStorage(ctx context.Context, presigned *PresignedURL, data []byte, mimeType, filename string, report func(written, total int64)) error {
+	// Create multipart form data for S3 upload
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	// Log all fields for debugging
+	log.Debugf("juma S3 upload: presignedURL=%s", presigned.PresignedURL)
+	for k, v := range presigned.Fields {
+		log.Debugf("juma S3 upload: field %s = %s", k, MaskFieldValue(k, v))
+	}
+
+	// S3 presigned POST requires specific field order:
+	// 1. key (the file path in S3)
+	// 2. Content-Type
+	// 3. Other policy fields (bucket, X-Amz-Algorithm, etc.)
+	// 4. Policy
+	// 5. X-Amz-Signature
+	// 6. file (must be last)
+
+	// Order matters! Prefer the order the presigned response listed the fields in, which
+	// matches whatever object store issued them; fall back to the AWS S3 policy order.
+	fieldOrder := presigned.FieldOrder
+	if len(fieldOrder) == 0 {
+		fieldOrder = []string{"key", "Content-Type", "bucket", "X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "Policy", "X-Amz-Signature"}
+	}
+
+	for _, fieldName := range fieldOrder {
+		if value, exists := presigned.Fields[fieldName]; exists {
+			if err := writer.WriteField(fieldName, value); err != nil {
+				return fmt.Errorf("failed to write field %s: %w", fieldName, err)
+			}
+		}
+	}
+
+	// Add any remaining fields not in our predefined order
+	for key, value := range presigned.Fields {
+		found := false
+		for _, ordered := range fieldOrder {
+			if key == ordered {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := writer.WriteField(key, value); err != nil {
+				return fmt.Errorf("failed to write field %s: %w", key, err)
+			}
+		}
+	}
+
+	// Add the file part last - this is REQUIRED by S3 presigned POST
+	// CRITICAL: Use CreatePart with explicit MIMEHeader to set the correct Content-Type
+	// CreateFormFile uses "application/octet-stream" which doesn't match the S3 policy
+	h := make(textproto.MIMEHeader)
+	if filename == "" {
+		filename = "upload"
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	h.Set("Content-Type", mimeType) // Must match the Content-Type field in the S3 policy
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create file part: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	var bodyReader io.Reader = &body
+	if report != nil {
+		bodyReader = &progressReader{r: &body, total: int64(body.Len()), report: report}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, presigned.PresignedURL, bodyReader)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	c.setClientHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("S3 upload failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// MaskFieldValue masks a value for logging based on its field name. Credentials are
+// fully redacted; other long values (URLs, base64 payloads) are truncated.
+func MaskFieldValue(key, value string) string {
+	lower := strings.ToLower(strings.TrimSpace(key))
+	switch lower {
+	case "x-amz-credential", "x-amz-signature", "policy", strings.ToLower(SessionCookieName):
+		return "<redacted>"
+	default:
+		trimmed := strings.TrimSpace(value)
+		if len(trimmed) <= 80 {
+			return trimmed
+		}
+		return trimmed[:40] + "..." + trimmed[len(trimmed)-12:]
+	}
+}
+
+// progressReader reports the bytes read from r, as the HTTP client sends them.
+type progressReader struct {
+	r       io.Reader
+	total   int64
+	written int64
+	report  func(written, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.written += int64(n)
+		p.report(p.written, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,70 @@
+package jumaclient
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUploadToStorage(t *testing.T) {
+	doer := &stubDoer{status: http.StatusNoContent}
+	client := New(doer, "token", "workspace")
+	presigned := &PresignedURL{
+		PresignedURL: "https://s3.invalid/bucket",
+		Fields:       map[string]string{"Policy": "p", "key": "uploads/a.png", "x-extra": "1"},
+		FieldOrder:   []string{"key", "Policy"},
+	}
+	var written, total int64
+	err := client.UploadToStorage(context.Background(), presigned, []byte("png-bytes"), "image/png", "a.png", func(w, t int64) {
+		written, total = w, t
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total == 0 || written != total {
+		t.Errorf("expected progress to reach the body size, got %d of %d", written, total)
+	}
+	if doer.req.URL.String() != presigned.PresignedURL {
+		t.Errorf("expected a post to the presigned URL, got %s", doer.req.URL)
+	}
+
+	_, params, err := mime.ParseMediaType(doer.req.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("bad content type: %v", err)
+	}
+	reader := multipart.NewReader(strings.NewReader(string(doer.sent)), params["boundary"])
+	var names []string
+	for {
+		part, errPart := reader.NextPart()
+		if errPart == io.EOF {
+			break
+		}
+		if errPart != nil {
+			t.Fatalf("bad multipart body: %v", errPart)
+		}
+		names = append(names, part.FormName())
+		if part.FormName() == "file" {
+			data, _ := io.ReadAll(part)
+			if part.FileName() != "a.png" || part.Header.Get("Content-Type") != "image/png" || string(data) != "png-bytes" {
+				t.Errorf("unexpected file part %q (%s): %q", part.FileName(), part.Header.Get("Content-Type"), data)
+			}
+		}
+	}
+	if want := "key,Policy,x-extra,file"; strings.Join(names, ",") != want {
+		t.Errorf("expected parts %s, got %s", want, strings.Join(names, ","))
+	}
+}
+
+func TestUploadToStorage_RejectedUpload(t *testing.T) {
+	doer := &stubDoer{status: http.StatusForbidden, body: "policy expired"}
+	client := New(doer, "token", "workspace")
+
+	err := client.UploadToStorage(context.Background(), &PresignedURL{PresignedURL: "https://s3.invalid/bucket"}, []byte("x"), "image/png", "a.png", nil)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the storage status in the error, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package jumaclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// FetchThread requests urlPath, an endpoint returning a Juma thread with its messages,
+// and returns the response body. tRPC headers and x-workspace-id are sent as for other
+// tRPC calls. A non-2xx answer is returned as a *StatusError.
+func (c *Client) FetchThread(ctx context.Context, urlPath string) ([]byte, error) {
+	req, err := c.newAppRequest(ctx, http.MethodGet, urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-workspace-id", c.WorkspaceID)
+	for name, value := range c.TRPCHeaders {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: body}
+	}
+	return body, nil
+}
+
+// LastAssistantText returns the text of the last assistant message in a thread
+// response. Both plain JSON and tRPC JSONL bodies are accepted; messages are found
+// anywhere in the document as objects with role "assistant" carrying either a string
+// content or text parts.
+func LastAssistantText(body []byte) (string, bool) {
+	var documents []gjson.Result
+	if gjson.ValidBytes(body) {
+		documents = append(documents, gjson.ParseBytes(body))
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); gjson.Valid(line) {
+				documents = append(documents, gjson.Parse(line))
+			}
+		}
+	}
+
+	text, found := "", false
+	var walk func(value gjson.Result)
+	walk = func(value gjson.Result) {
+		if value.IsObject() && value.Get("role").String() == "assistant" {
+			if messageText, ok := assistantMessageText(value); ok {
+				text, found = messageText, true
+			}
+		}
+		if value.IsObject() || value.IsArray() {
+			value.ForEach(func(_, child gjson.Result) bool {
+				walk(child)
+				return true
+			})
+		}
+	}
+	for _, document := range documents {
+		walk(document)
+	}
+	return text, found
+}
+
+// assistantMessageText joins the text of a message given as a string content or as
+// parts of type "text".
+func assistantMessageText(message gjson.Result) (string, bool) {
+	if content := message.Get("content"); content.Type == gjson.String {
+		return content.String(), true
+	}
+	parts := message.Get("parts")
+	if !parts.IsArray() {
+		parts = message.Get("content")
+	}
+	if !parts.IsArray() {
+		return "", false
+	}
+	var text strings.Builder
+	found := false
+	parts.ForEach(func(_, part gjson.Result) bool {
+		if part.Get("type").String() == "text" {
+			text.WriteString(part.Get("text").String())
+			found = true
+		}
+		return true
+	})
+	return text.String(), found
+}
@@ -0,0 +1,37 @@
+package jumaclient
+
+import "testing"
+
+func TestLastAssistantText(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "string content",
+			body:   `{"messages":[{"role":"assistant","content":"first"},{"role":"user","content":"q"},{"role":"assistant","content":"second"}]}`,
+			want:   "second",
+			wantOK: true,
+		},
+		{
+			name:   "trpc jsonl with parts",
+			body:   "{\"json\":{\"0\":[]}}\n{\"json\":[2,0,[[{\"thread\":{\"messages\":[{\"role\":\"assistant\",\"parts\":[{\"type\":\"text\",\"text\":\"Hello, \"},{\"type\":\"tool\"},{\"type\":\"text\",\"text\":\"world\"}]}]}}]]]}",
+			want:   "Hello, world",
+			wantOK: true,
+		},
+		{
+			name: "no assistant message",
+			body: `{"messages":[{"role":"user","content":"q"}]}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := LastAssistantText([]byte(tc.body))
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("expected (%q, %v), got (%q, %v)", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package executor
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type codexCache struct {
 	ID     string
@@ -8,3 +11,174 @@ type codexCache struct {
 }
 
 var codexCacheMap = map[string]codexCache{}
+
+// hostedImageCacheEntry maps a remote source URL to its rehosted public URL.
+type hostedImageCacheEntry struct {
+	URL    string
+	Expire time.Time
+}
+
+const (
+	// hostedImageCacheTTL bounds how long a rehosted URL is reused.
+	hostedImageCacheTTL = time.Hour
+	// hostedImageCacheMaxEntries bounds the number of cached rehosted URLs.
+	hostedImageCacheMaxEntries = 1024
+)
+
+var (
+	hostedImageCacheMu  sync.Mutex
+	hostedImageCacheMap = map[string]hostedImageCacheEntry{}
+)
+
+// getHostedImage returns the cached public URL for sourceURL if it has not expired.
+func getHostedImage(sourceURL string) (string, bool) {
+	hostedImageCacheMu.Lock()
+	defer hostedImageCacheMu.Unlock()
+	entry, ok := hostedImageCacheMap[sourceURL]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.Expire) {
+		delete(hostedImageCacheMap, sourceURL)
+		return "", false
+	}
+	return entry.URL, true
+}
+
+// putHostedImage stores the public URL for sourceURL, evicting expired entries and,
+// if still full, the entry closest to expiry.
+func putHostedImage(sourceURL, publicURL string) {
+	now := time.Now()
+	hostedImageCacheMu.Lock()
+	defer hostedImageCacheMu.Unlock()
+	if _, exists := hostedImageCacheMap[sourceURL]; !exists && len(hostedImageCacheMap) >= hostedImageCacheMaxEntries {
+		oldestKey := ""
+		var oldestExpire time.Time
+		for key, entry := range hostedImageCacheMap {
+			if now.After(entry.Expire) {
+				delete(hostedImageCacheMap, key)
+				continue
+			}
+			if oldestKey == "" || entry.Expire.Before(oldestExpire) {
+				oldestKey, oldestExpire = key, entry.Expire
+			}
+		}
+		if len(hostedImageCacheMap) >= hostedImageCacheMaxEntries && oldestKey != "" {
+			delete(hostedImageCacheMap, oldestKey)
+		}
+	}
+	hostedImageCacheMap[sourceURL] = hostedImageCacheEntry{URL: publicURL, Expire: now.Add(hostedImageCacheTTL)}
+}
+
+// jumaResponseCacheEntry holds a cached non-streaming Juma chat response.
+type jumaResponseCacheEntry struct {
+	Payload []byte
+	Expire  time.Time
+}
+
+// jumaResponseCacheMaxEntries bounds the number of cached Juma responses.
+const jumaResponseCacheMaxEntries = 1024
+
+var (
+	jumaResponseCacheMu  sync.Mutex
+	jumaResponseCacheMap = map[string]jumaResponseCacheEntry{}
+)
+
+// getJumaResponse returns the cached response for key if it has not expired.
+func getJumaResponse(key string) ([]byte, bool) {
+	jumaResponseCacheMu.Lock()
+	defer jumaResponseCacheMu.Unlock()
+	entry, ok := jumaResponseCacheMap[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.Expire) {
+		delete(jumaResponseCacheMap, key)
+		return nil, false
+	}
+	return entry.Payload, true
+}
+
+// putJumaResponse stores payload for key for ttl, evicting expired entries and, if
+// still full, the entry closest to expiry.
+func putJumaResponse(key string, payload []byte, ttl time.Duration) {
+	now := time.Now()
+	jumaResponseCacheMu.Lock()
+	defer jumaResponseCacheMu.Unlock()
+	if _, exists := jumaResponseCacheMap[key]; !exists && len(jumaResponseCacheMap) >= jumaResponseCacheMaxEntries {
+		oldestKey := ""
+		var oldestExpire time.Time
+		for k, entry := range jumaResponseCacheMap {
+			if now.After(entry.Expire) {
+				delete(jumaResponseCacheMap, k)
+				continue
+			}
+			if oldestKey == "" || entry.Expire.Before(oldestExpire) {
+				oldestKey, oldestExpire = k, entry.Expire
+			}
+		}
+		if len(jumaResponseCacheMap) >= jumaResponseCacheMaxEntries && oldestKey != "" {
+			delete(jumaResponseCacheMap, oldestKey)
+		}
+	}
+	jumaResponseCacheMap[key] = jumaResponseCacheEntry{Payload: payload, Expire: now.Add(ttl)}
+}
+
+// jumaThreadCacheEntry remembers the Juma thread and message IDs used for a conversation.
+type jumaThreadCacheEntry struct {
+	ThreadID   string
+	MessageIDs []string
+	Expire     time.Time
+}
+
+const (
+	// jumaThreadCacheTTL bounds how long a conversation keeps its Juma thread.
+	jumaThreadCacheTTL = 24 * time.Hour
+	// jumaThreadCacheMaxEntries bounds the number of tracked conversations.
+	jumaThreadCacheMaxEntries = 4096
+)
+
+var (
+	jumaThreadCacheMu  sync.Mutex
+	jumaThreadCacheMap = map[string]jumaThreadCacheEntry{}
+)
+
+// getJumaThread returns the cached thread for key if it has not expired.
+func getJumaThread(key string) (jumaThreadCacheEntry, bool) {
+	jumaThreadCacheMu.Lock()
+	defer jumaThreadCacheMu.Unlock()
+	entry, ok := jumaThreadCacheMap[key]
+	if !ok {
+		return jumaThreadCacheEntry{}, false
+	}
+	if time.Now().After(entry.Expire) {
+		delete(jumaThreadCacheMap, key)
+		return jumaThreadCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// putJumaThread stores the thread for key, evicting expired entries and, if still
+// full, the entry closest to expiry.
+func putJumaThread(key, threadID string, messageIDs []string) {
+	now := time.Now()
+	jumaThreadCacheMu.Lock()
+	defer jumaThreadCacheMu.Unlock()
+	if _, exists := jumaThreadCacheMap[key]; !exists && len(jumaThreadCacheMap) >= jumaThreadCacheMaxEntries {
+		oldestKey := ""
+		var oldestExpire time.Time
+		for k, entry := range jumaThreadCacheMap {
+			if now.After(entry.Expire) {
+				delete(jumaThreadCacheMap, k)
+				continue
+			}
+			if oldestKey == "" || entry.Expire.Before(oldestExpire) {
+				oldestKey, oldestExpire = k, entry.Expire
+			}
+		}
+		if len(jumaThreadCacheMap) >= jumaThreadCacheMaxEntries && oldestKey != "" {
+			delete(jumaThreadCacheMap, oldestKey)
+		}
+	}
+	jumaThreadCacheMap[key] = jumaThreadCacheEntry{ThreadID: threadID, MessageIDs: messageIDs, Expire: now.Add(jumaThreadCacheTTL)}
+}
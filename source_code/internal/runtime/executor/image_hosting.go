@@ -3,13 +3,19 @@ package executor
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
@@ -31,7 +37,11 @@ type imageHostingResponse struct {
 }
 
 // UploadBase64Image uploads a base64-encoded image to the configured image hosting service
-// and returns the public URL. If image hosting is not enabled or fails, it returns the original URL.
+// and returns the public URL. If image hosting is not enabled or the URL is not a data URL,
+// it returns the original URL. The content type is sniffed from the decoded bytes and
+// must be allowed by image-hosting.allowed-mime-types (see defaultImageHostingMimeTypes);
+// other content keeps the original URL and is reported as an error. Upload failures are
+// governed by image-hosting.on-failure.
 //
 // Parameters:
 //   - cfg: The application configuration containing image hosting settings
@@ -39,7 +49,8 @@ type imageHostingResponse struct {
 //
 // Returns:
 //   - The public URL if upload succeeds, or the original URL if not applicable
-//   - An error if the upload fails
+//   - An error if the upload fails, with the original data URL only in "keep-data-url"
+//     mode and within image-hosting.inline-max-bytes
 func UploadBase64Image(cfg *config.Config, imageURL string) (string, error) {
 	// Check if image hosting is enabled
 	if cfg == nil || !cfg.ImageHosting.Enable || cfg.ImageHosting.Endpoint == "" {
@@ -52,21 +63,298 @@ func UploadBase64Image(cfg *config.Config, imageURL string) (string, error) {
 	}
 
 	// Parse the data URL: data:[<mediatype>][;base64],<data>
-	mimeType, base64Data, err := parseDataURL(imageURL)
+	mimeType, imageData, err := parseDataURL(imageURL)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to parse data URL: %w", err)
+		return imageHostingFallback(cfg, imageURL, 0, fmt.Errorf("failed to parse data URL: %w", err))
 	}
 
-	// Decode base64 data
-	imageData, err := base64.StdEncoding.DecodeString(base64Data)
+	// Trust the bytes rather than the declared type so the host cannot become a file drop
+	sniffed, _, _ := strings.Cut(http.DetectContentType(imageData), ";")
+	if !imageHostingAllowsMimeType(cfg, sniffed) {
+		log.Warnf("image hosting: refusing to upload %s content declared as %s, keeping original URL", sniffed, mimeType)
+		return imageURL, fmt.Errorf("media type %s is not allowed for image hosting", sniffed)
+	}
+	mimeType = sniffed
+
+	// Skip uploads while the image host is considered down
+	if !imageHostingBreaker.allow(cfg) {
+		return imageHostingFallback(cfg, imageURL, len(imageData), errImageHostingUnavailable)
+	}
+
+	publicURL, err := uploadImageBytes(cfg, imageData, mimeType)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to decode base64 image: %w", err)
+		return imageHostingFallback(cfg, imageURL, len(imageData), err)
+	}
+	return publicURL, nil
+}
+
+// imageHostingFallback applies image-hosting.on-failure to a data URL of size decoded
+// bytes that could not be hosted, always returning err (annotated when size is the cause).
+func imageHostingFallback(cfg *config.Config, dataURL string, size int, err error) (string, error) {
+	if cfg.ImageHosting.OnFailure == "fail" {
+		return "", err
+	}
+	if limit := cfg.ImageHosting.InlineMaxBytes; limit > 0 && int64(size) > limit {
+		return "", fmt.Errorf("%w (image of %d bytes exceeds inline-max-bytes %d)", err, size, limit)
+	}
+	log.Warnf("image hosting: upload failed, keeping data URL: %v", err)
+	return dataURL, err
+}
+
+// UploadImageBytes uploads already-decoded image bytes of the given MIME type to the
+// configured image hosting service and returns the public URL. Unlike UploadBase64Image
+// there is no original URL to fall back to, so an error is returned when hosting is
+// disabled or temporarily skipped by the circuit breaker.
+func UploadImageBytes(cfg *config.Config, data []byte, mime string) (string, error) {
+	if cfg == nil || !cfg.ImageHosting.Enable || cfg.ImageHosting.Endpoint == "" {
+		return "", fmt.Errorf("image hosting is not enabled")
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("image data is empty")
+	}
+	if !imageHostingBreaker.allow(cfg) {
+		return "", errImageHostingUnavailable
 	}
+	return uploadImageBytes(cfg, data, mime)
+}
 
+// uploadImageBytes names the file after its MIME type and uploads it. Callers have
+// already checked that hosting is enabled and the circuit breaker allows the upload.
+func uploadImageBytes(cfg *config.Config, data []byte, mime string) (string, error) {
 	// Determine file extension from mime type
-	ext := getExtensionFromMimeType(mimeType)
+	ext := getExtensionFromMimeType(mime)
 	filename := fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), ext)
 
+	publicURL, err := uploadToImageHost(cfg, data, filename)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("image hosting: uploaded image successfully, public URL: %s", publicURL)
+	return publicURL, nil
+}
+
+// errImageHostingUnavailable is reported when the circuit breaker skips an upload.
+var errImageHostingUnavailable = fmt.Errorf("image hosting is temporarily unavailable after repeated failures")
+
+// defaultImageHostingMimeTypes lists the media types accepted for upload when
+// ImageHosting.AllowedMimeTypes is not configured. Data URLs are checked against the
+// sniffed content, so every entry must be recognisable by http.DetectContentType.
+var defaultImageHostingMimeTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp",
+	"video/mp4",
+}
+
+// UploadBase64Media uploads a base64-encoded image or short video (e.g. animated GIFs or
+// MP4 previews) to the configured image hosting service and returns the public URL.
+// The sniffed MIME type must be in ImageHosting.AllowedMimeTypes (or the built-in default
+// list) and the decoded size must not exceed the matching ImageHosting.MaxSizeBytes entry.
+// Like UploadBase64Image, the original URL is returned when hosting is disabled, and
+// with an error when the circuit breaker skips the upload.
+func UploadBase64Media(cfg *config.Config, mediaURL string) (string, error) {
+	if cfg == nil || !cfg.ImageHosting.Enable || cfg.ImageHosting.Endpoint == "" {
+		return mediaURL, nil
+	}
+	if !strings.HasPrefix(mediaURL, "data:") {
+		return mediaURL, nil
+	}
+
+	declared, mediaData, err := parseDataURL(mediaURL)
+	if err != nil {
+		return mediaURL, fmt.Errorf("failed to parse data URL: %w", err)
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(mediaData), ";")
+	if !imageHostingAllowsMimeType(cfg, mimeType) {
+		log.Warnf("image hosting: refusing to upload %s content declared as %s, keeping original URL", mimeType, declared)
+		return mediaURL, fmt.Errorf("media type %s is not allowed for image hosting", mimeType)
+	}
+	if !imageHostingBreaker.allow(cfg) {
+		return mediaURL, errImageHostingUnavailable
+	}
+
+	if limit := cfg.ImageHosting.MaxSizeBytes[mimeType]; limit > 0 && int64(len(mediaData)) > limit {
+		return mediaURL, fmt.Errorf("media size %d exceeds limit %d for %s", len(mediaData), limit, mimeType)
+	}
+
+	filename := fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), getExtensionFromMimeType(mimeType))
+	publicURL, err := uploadToImageHost(cfg, mediaData, filename)
+	if err != nil {
+		return mediaURL, err
+	}
+	log.Infof("image hosting: uploaded %s successfully, public URL: %s", mimeType, publicURL)
+	return publicURL, nil
+}
+
+// UploadRemoteImage downloads a remote http(s) image and rehosts it on the configured
+// image hosting service, returning the public URL. Results are cached per source URL,
+// so repeated references skip both the download and the upload. The original URL is
+// returned when hosting is disabled or the URL is not http(s), and with an error when
+// the circuit breaker skips the upload. Like UploadBase64Image, the allowlist is checked
+// against the downloaded bytes rather than the server's Content-Type.
+func UploadRemoteImage(cfg *config.Config, remoteURL string) (string, error) {
+	if cfg == nil || !cfg.ImageHosting.Enable || cfg.ImageHosting.Endpoint == "" {
+		return remoteURL, nil
+	}
+	if !strings.HasPrefix(remoteURL, "http://") && !strings.HasPrefix(remoteURL, "https://") {
+		return remoteURL, nil
+	}
+	if cached, ok := getHostedImage(remoteURL); ok {
+		log.Debugf("image hosting: cache hit for %s", remoteURL)
+		return cached, nil
+	}
+	if !imageHostingBreaker.allow(cfg) {
+		return remoteURL, errImageHostingUnavailable
+	}
+
+	client := newJumaUploadClient(30 * time.Second)
+	imageData, contentType, err := fetchRemoteImage(context.Background(), client, remoteURL, jumaMaxRemoteImageBytes)
+	if err != nil {
+		return remoteURL, fmt.Errorf("failed to download remote image: %w", err)
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(imageData), ";")
+	if !imageHostingAllowsMimeType(cfg, mimeType) {
+		log.Warnf("image hosting: refusing to rehost %s content served as %s, keeping original URL", mimeType, contentType)
+		return remoteURL, fmt.Errorf("media type %s is not allowed for image hosting", mimeType)
+	}
+
+	filename := fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), getExtensionFromMimeType(mimeType))
+	publicURL, err := uploadToImageHost(cfg, imageData, filename)
+	if err != nil {
+		return remoteURL, err
+	}
+	putHostedImage(remoteURL, publicURL)
+	log.Infof("image hosting: rehosted %s as %s", remoteURL, publicURL)
+	return publicURL, nil
+}
+
+const (
+	// defaultImageHostingFailureThreshold opens the breaker after this many consecutive failures.
+	defaultImageHostingFailureThreshold = 5
+	// defaultImageHostingCooldown keeps the breaker open before probing the host again.
+	defaultImageHostingCooldown = 60 * time.Second
+)
+
+// imageHostingBreaker guards the image hosting endpoint during outages.
+var imageHostingBreaker = &imageHostingCircuitBreaker{}
+
+// imageHostingCircuitBreaker short-circuits uploads after repeated failures. It is
+// closed while the host is healthy, open during the cooldown (uploads are skipped and
+// callers keep the original URL), and half-open when a single probe upload is allowed.
+type imageHostingCircuitBreaker struct {
+	mu          sync.Mutex
+	failures    int
+	state       string // "closed", "open" or "half-open"; empty means closed
+	openedAt    time.Time
+	probeSentAt time.Time
+}
+
+// imageHostingBreakerSettings returns the configured threshold and cooldown.
+func imageHostingBreakerSettings(cfg *config.Config) (int, time.Duration) {
+	threshold, cooldown := defaultImageHostingFailureThreshold, defaultImageHostingCooldown
+	if cfg != nil {
+		if cfg.ImageHosting.FailureThreshold > 0 {
+			threshold = cfg.ImageHosting.FailureThreshold
+		}
+		if cfg.ImageHosting.CooldownSeconds > 0 {
+			cooldown = time.Duration(cfg.ImageHosting.CooldownSeconds) * time.Second
+		}
+	}
+	return threshold, cooldown
+}
+
+// allow reports whether an upload may be attempted now.
+func (b *imageHostingCircuitBreaker) allow(cfg *config.Config) bool {
+	_, cooldown := imageHostingBreakerSettings(cfg)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case "open":
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = "half-open"
+		b.probeSentAt = time.Now()
+		log.Info("image hosting: circuit half-open, probing endpoint")
+		return true
+	case "half-open":
+		// Only one probe at a time; allow another if the previous one never reported back.
+		if time.Since(b.probeSentAt) < cooldown {
+			return false
+		}
+		b.probeSentAt = time.Now()
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an upload request.
+func (b *imageHostingCircuitBreaker) record(cfg *config.Config, err error) {
+	threshold, cooldown := imageHostingBreakerSettings(cfg)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state == "open" || b.state == "half-open" {
+			log.Info("image hosting: circuit closed, endpoint recovered")
+		}
+		b.state = "closed"
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == "half-open" || (b.state != "open" && b.failures >= threshold) {
+		b.state = "open"
+		b.openedAt = time.Now()
+		log.Warnf("image hosting: circuit open after %d consecutive failures, skipping uploads for %s", b.failures, cooldown)
+	}
+}
+
+// imageHostingAllowsMimeType reports whether mimeType may be uploaded to the image host.
+func imageHostingAllowsMimeType(cfg *config.Config, mimeType string) bool {
+	allowed := defaultImageHostingMimeTypes
+	if cfg != nil && len(cfg.ImageHosting.AllowedMimeTypes) > 0 {
+		allowed = cfg.ImageHosting.AllowedMimeTypes
+	}
+	return mimeTypeListed(allowed, mimeType)
+}
+
+// mimeTypeListed reports whether mimeType appears in allowed, ignoring case.
+func mimeTypeListed(allowed []string, mimeType string) bool {
+	for _, candidate := range allowed {
+		if strings.EqualFold(strings.TrimSpace(candidate), mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadToImageHost posts the file to the PixelPunk endpoint and returns its public URL.
+// Failures are fed to the circuit breaker.
+func uploadToImageHost(cfg *config.Config, fileData []byte, filename string) (string, error) {
+	if cfg.ImageHosting.StripMetadata {
+		sniffed, _, _ := strings.Cut(http.DetectContentType(fileData), ";")
+		fileData = stripImageMetadata(fileData, sniffed)
+	}
+	publicURL, err := postToImageHost(cfg, fileData, filename)
+	imageHostingBreaker.record(cfg, err)
+	return publicURL, err
+}
+
+// imageHostingFormOption resolves an optional upload form field: empty values use def and
+// "none" returns "" so the field is omitted.
+func imageHostingFormOption(value, def string) string {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return def
+	case strings.EqualFold(value, "none"):
+		return ""
+	default:
+		return value
+	}
+}
+
+// postToImageHost performs the multipart upload request.
+func postToImageHost(cfg *config.Config, fileData []byte, filename string) (string, error) {
 	// Create multipart form data
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -74,74 +362,138 @@ func UploadBase64Image(cfg *config.Config, imageURL string) (string, error) {
 	// Add the file part
 	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to create form file: %w", err)
+		return "", fmt.Errorf("failed to create form file: %w", err)
 	}
-	if _, err = part.Write(imageData); err != nil {
-		return imageURL, fmt.Errorf("failed to write image data: %w", err)
+	if _, err = part.Write(fileData); err != nil {
+		return "", fmt.Errorf("failed to write image data: %w", err)
 	}
 
 	// Add optional parameters
-	_ = writer.WriteField("access_level", "public")
-	_ = writer.WriteField("optimize", "true")
+	if accessLevel := imageHostingFormOption(cfg.ImageHosting.AccessLevel, "public"); accessLevel != "" {
+		_ = writer.WriteField("access_level", accessLevel)
+	}
+	if optimize := imageHostingFormOption(cfg.ImageHosting.Optimize, "true"); optimize != "" {
+		_ = writer.WriteField("optimize", optimize)
+	}
 
 	if err = writer.Close(); err != nil {
-		return imageURL, fmt.Errorf("failed to close multipart writer: %w", err)
+		return "", fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
 	// Create HTTP request
 	req, err := http.NewRequest(http.MethodPost, cfg.ImageHosting.Endpoint, &body)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to create upload request: %w", err)
+		return "", fmt.Errorf("failed to create upload request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("x-pixelpunk-key", cfg.ImageHosting.APIKey)
+	if cfg.ImageHosting.APIKey != "" {
+		req.Header.Set("x-pixelpunk-key", cfg.ImageHosting.APIKey)
+	}
 
 	// Execute the request
-	client := &http.Client{Timeout: 30 * time.Second}
+	client, err := newImageHostingClient(cfg)
+	if err != nil {
+		return "", err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to upload image: %w", err)
+		return "", fmt.Errorf("failed to upload image: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	// Read response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return imageURL, fmt.Errorf("failed to read upload response: %w", err)
+		return "", fmt.Errorf("failed to read upload response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return imageURL, fmt.Errorf("image upload failed with status %d: %s", resp.StatusCode, string(respBody))
+		return "", fmt.Errorf("image upload failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
 
 	// Parse response
 	var result imageHostingResponse
 	if err = json.Unmarshal(respBody, &result); err != nil {
 		log.Warnf("image hosting: failed to parse JSON response, raw: %s", string(respBody))
-		return imageURL, fmt.Errorf("failed to parse upload response: %w", err)
+		return "", fmt.Errorf("failed to parse upload response: %w", err)
 	}
 
 	// Check for success (code 200)
 	if result.Code != 200 {
-		return imageURL, fmt.Errorf("image upload failed: %s", result.Message)
+		return "", fmt.Errorf("image upload failed: %s", result.Message)
 	}
 
 	// Get the uploaded URL
 	publicURL := result.Data.Uploaded.URL
 	if publicURL == "" {
-		return imageURL, fmt.Errorf("image upload response missing URL")
+		return "", fmt.Errorf("image upload response missing URL")
 	}
-
-	log.Infof("image hosting: uploaded image successfully, public URL: %s", publicURL)
 	return publicURL, nil
 }
 
-// parseDataURL parses a data URL and returns the MIME type and base64 data.
+// imageHostingClientKey identifies the TLS settings an image hosting client was built for.
+type imageHostingClientKey struct {
+	caFile             string
+	insecureSkipVerify bool
+}
+
+var (
+	imageHostingClientMu     sync.Mutex
+	imageHostingClient       *http.Client
+	imageHostingClientConfig imageHostingClientKey
+)
+
+// imageHostingHTTPClient returns the client used for image host uploads. It honours
+// image-hosting.tls (custom CA bundle, insecure-skip-verify) and is rebuilt only when
+// those settings change.
+func imageHostingHTTPClient(cfg *config.Config) (*http.Client, error) {
+	key := imageHostingClientKey{
+		caFile:             strings.TrimSpace(cfg.ImageHosting.TLS.CAFile),
+		insecureSkipVerify: cfg.ImageHosting.TLS.InsecureSkipVerify,
+	}
+	imageHostingClientMu.Lock()
+	defer imageHostingClientMu.Unlock()
+	if imageHostingClient != nil && imageHostingClientConfig == key {
+		return imageHostingClient, nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if key.caFile != "" || key.insecureSkipVerify {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if key.caFile != "" {
+			pem, err := os.ReadFile(key.caFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image hosting CA file: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("image hosting CA file %s contains no valid certificates", key.caFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if key.insecureSkipVerify {
+			log.Warn("image hosting: TLS certificate verification is DISABLED (insecure-skip-verify); uploads are vulnerable to interception")
+			tlsConfig.InsecureSkipVerify = true
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	imageHostingClient = client
+	imageHostingClientConfig = key
+	return client, nil
+}
+
+// parseDataURL parses a data URL and returns the MIME type and decoded payload.
 // Format: data:[<mediatype>][;base64],<data>
-func parseDataURL(dataURL string) (mimeType, data string, err error) {
+// Payloads without the ";base64" flag are URL-decoded (e.g. inline SVG).
+func parseDataURL(dataURL string) (mimeType string, data []byte, err error) {
 	if !strings.HasPrefix(dataURL, "data:") {
-		return "", "", fmt.Errorf("not a data URL")
+		return "", nil, fmt.Errorf("not a data URL")
 	}
 
 	// Remove "data:" prefix
@@ -150,11 +502,11 @@ func parseDataURL(dataURL string) (mimeType, data string, err error) {
 	// Find the comma separator
 	commaIdx := strings.Index(rest, ",")
 	if commaIdx == -1 {
-		return "", "", fmt.Errorf("invalid data URL format: no comma separator")
+		return "", nil, fmt.Errorf("invalid data URL format: no comma separator")
 	}
 
 	metadata := rest[:commaIdx]
-	data = rest[commaIdx+1:]
+	payload := rest[commaIdx+1:]
 
 	// Parse metadata (e.g., "image/png;base64")
 	parts := strings.Split(metadata, ";")
@@ -165,9 +517,64 @@ func parseDataURL(dataURL string) (mimeType, data string, err error) {
 		mimeType = "application/octet-stream"
 	}
 
+	data, err = decodeDataURLPayload(parts[1:], payload)
+	if err != nil {
+		return "", nil, err
+	}
 	return mimeType, data, nil
 }
 
+// decodeDataURLPayload decodes a data URL payload based on its metadata parameters:
+// base64 when ";base64" is present, percent-encoded text otherwise.
+func decodeDataURLPayload(params []string, payload string) ([]byte, error) {
+	for _, param := range params {
+		if strings.EqualFold(strings.TrimSpace(param), "base64") {
+			return decodeLenientBase64(payload)
+		}
+	}
+	decoded, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to URL-decode data: %w", err)
+	}
+	return []byte(decoded), nil
+}
+
+// decodeLenientBase64 decodes base64 as sent by browsers and other loose encoders: it
+// accepts the URL-safe alphabet, missing or truncated padding, embedded whitespace and
+// percent-encoded characters. An error is returned only when the data cannot be decoded
+// under any of those interpretations.
+func decodeLenientBase64(payload string) ([]byte, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(payload); err == nil {
+		return decoded, nil
+	}
+
+	cleaned := payload
+	if strings.Contains(cleaned, "%") {
+		if unescaped, err := url.PathUnescape(cleaned); err == nil {
+			cleaned = unescaped
+		}
+	}
+	cleaned = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, cleaned)
+	// Padding is redundant; decode without it so stripped or truncated padding is accepted.
+	cleaned = strings.TrimRight(cleaned, "=")
+
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(cleaned, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+	decoded, err := encoding.DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 data: %w", err)
+	}
+	return decoded, nil
+}
+
 // getExtensionFromMimeType returns a file extension based on the MIME type.
 func getExtensionFromMimeType(mimeType string) string {
 	switch mimeType {
@@ -183,6 +590,10 @@ func getExtensionFromMimeType(mimeType string) string {
 		return ".bmp"
 	case "image/svg+xml":
 		return ".svg"
+	case "video/mp4":
+		return ".mp4"
+	case "video/webm":
+		return ".webm"
 	default:
 		return ".png" // Default to PNG
 	}
@@ -0,0 +1,194 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jpegMetadataQuality is the JPEG quality used when re-encoding to drop metadata.
+const jpegMetadataQuality = 92
+
+// pngMetadataChunks are the PNG chunk types carrying text, EXIF or timestamp metadata.
+var pngMetadataChunks = map[string]struct{}{"tEXt": {}, "zTXt": {}, "iTXt": {}, "eXIf": {}, "tIME": {}}
+
+// stripImageMetadata drops EXIF, XMP, comments and other metadata from JPEG and PNG
+// images by decoding and re-encoding them. JPEGs are rotated upright first, since the
+// EXIF orientation that told viewers how to display them is dropped as well. Images
+// without metadata, other formats and images that fail to decode are returned as-is.
+func stripImageMetadata(data []byte, mimeType string) []byte {
+	var buf bytes.Buffer
+	switch mimeType {
+	case "image/jpeg", "image/jpg":
+		hasMetadata, orientation := jpegMetadata(data)
+		if !hasMetadata {
+			return data
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.WithError(err).Warn("image metadata: cannot decode JPEG, uploading with metadata")
+			return data
+		}
+		if err = jpeg.Encode(&buf, applyEXIFOrientation(img, orientation), &jpeg.Options{Quality: jpegMetadataQuality}); err != nil {
+			log.WithError(err).Warn("image metadata: cannot re-encode JPEG, uploading with metadata")
+			return data
+		}
+	case "image/png":
+		if !pngHasMetadata(data) {
+			return data
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.WithError(err).Warn("image metadata: cannot decode PNG, uploading with metadata")
+			return data
+		}
+		if err = png.Encode(&buf, img); err != nil {
+			log.WithError(err).Warn("image metadata: cannot re-encode PNG, uploading with metadata")
+			return data
+		}
+	default:
+		return data
+	}
+	log.WithFields(log.Fields{"mime_type": mimeType, "size_bytes": len(data), "stripped_bytes": buf.Len()}).Debug("image metadata: stripped image metadata")
+	return buf.Bytes()
+}
+
+// jpegMetadata reports whether a JPEG carries APP1-APP15 or comment segments and
+// returns its EXIF orientation (1 when absent).
+func jpegMetadata(data []byte) (hasMetadata bool, orientation int) {
+	orientation = 1
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false, orientation
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return hasMetadata, orientation
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan: only entropy-coded data follows
+			return hasMetadata, orientation
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return hasMetadata, orientation
+		}
+		segment := data[i+4 : i+2+length]
+		if (marker >= 0xE1 && marker <= 0xEF) || marker == 0xFE {
+			hasMetadata = true
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				if o := exifOrientation(segment[6:]); o != 0 {
+					orientation = o
+				}
+			}
+		}
+		i += 2 + length
+	}
+	return hasMetadata, orientation
+}
+
+// exifOrientation reads the Orientation tag from IFD0 of a TIFF-encoded EXIF block,
+// returning 0 when it is missing or invalid.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) != 0x0112 {
+			continue
+		}
+		if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 0
+	}
+	return 0
+}
+
+// applyEXIFOrientation returns img transformed so that it displays upright without the
+// EXIF orientation tag.
+func applyEXIFOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// pngHasMetadata reports whether a PNG contains text, EXIF or timestamp chunks.
+func pngHasMetadata(data []byte) bool {
+	const signatureLen = 8
+	if len(data) < signatureLen || string(data[1:4]) != "PNG" {
+		return false
+	}
+	for i := signatureLen; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if _, ok := pngMetadataChunks[chunkType]; ok {
+			return true
+		}
+		if chunkType == "IEND" || length < 0 {
+			return false
+		}
+		i += 12 + length
+	}
+	return false
+}
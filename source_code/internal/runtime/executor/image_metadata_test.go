@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifSegment builds a big-endian APP1 EXIF segment holding only an orientation tag.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	ifd := make([]byte, 2+12+4)
+	binary.BigEndian.PutUint16(ifd[0:], 1)
+	binary.BigEndian.PutUint16(ifd[2:], 0x0112)
+	binary.BigEndian.PutUint16(ifd[4:], 3) // SHORT
+	binary.BigEndian.PutUint32(ifd[6:], 1)
+	binary.BigEndian.PutUint16(ifd[10:], orientation)
+	payload := append([]byte("Exif\x00\x00"), append(tiff, ifd...)...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestStripImageMetadata_JPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			src.Set(x, y, color.White)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	if got := stripImageMetadata(plain, "image/jpeg"); !bytes.Equal(got, plain) {
+		t.Error("expected a JPEG without metadata to be left unchanged")
+	}
+
+	withEXIF := append(append([]byte{0xFF, 0xD8}, exifSegment(6)...), plain[2:]...)
+	if has, orientation := jpegMetadata(withEXIF); !has || orientation != 6 {
+		t.Fatalf("expected EXIF orientation 6, got has=%v orientation=%d", has, orientation)
+	}
+	stripped := stripImageMetadata(withEXIF, "image/jpeg")
+	if has, _ := jpegMetadata(stripped); has {
+		t.Error("expected metadata to be removed")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 2 || cfg.Height != 4 {
+		t.Errorf("expected the image rotated upright to 2x4, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
@@ -0,0 +1,195 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// jumaDefaultMaxChoices caps the OpenAI "n" parameter when juma.max-choices is not set.
+const jumaDefaultMaxChoices = 4
+
+// jumaChoiceKey marks the context of one choice of an n > 1 chat completion.
+type jumaChoiceKey struct{}
+
+// isJumaChoiceRequest reports whether ctx belongs to one choice of an n > 1 request.
+func isJumaChoiceRequest(ctx context.Context) bool {
+	choice, _ := ctx.Value(jumaChoiceKey{}).(bool)
+	return choice
+}
+
+// jumaChoiceCount returns the number of chat completion choices requested through "n",
+// rejecting values above juma.max-choices.
+func jumaChoiceCount(cfg *config.Config, payload []byte) (int, error) {
+	n := int(gjson.GetBytes(payload, "n").Int())
+	if n <= 1 {
+		return 1, nil
+	}
+	limit := jumaDefaultMaxChoices
+	if cfg != nil && cfg.Juma.MaxChoices > 0 {
+		limit = cfg.Juma.MaxChoices
+	}
+	if n > limit {
+		return 0, statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("n must be between 1 and %d", limit)}
+	}
+	return n, nil
+}
+
+// jumaChoiceRequest returns the single-choice request and context used for each of the
+// n parallel Juma requests. Each choice starts its own Juma thread.
+func jumaChoiceRequest(ctx context.Context, req cliproxyexecutor.Request) (context.Context, cliproxyexecutor.Request) {
+	choiceReq := req
+	choiceReq.Payload, _ = sjson.DeleteBytes(req.Payload, "n")
+	return context.WithValue(ctx, jumaChoiceKey{}, true), choiceReq
+}
+
+// executeChoices serves a non-streaming chat completion with n > 1 by issuing n Juma
+// requests concurrently and merging their single choices into one response, in order.
+func (e *JumaExecutor) executeChoices(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options, n int) (cliproxyexecutor.Response, error) {
+	choiceCtx, choiceReq := jumaChoiceRequest(ctx, req)
+	responses := make([]cliproxyexecutor.Response, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = e.Execute(choiceCtx, auth, choiceReq, opts)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return cliproxyexecutor.Response{}, err
+		}
+	}
+	// Dry runs and image responses have no choices to merge; return the first as-is.
+	if !gjson.GetBytes(responses[0].Payload, "choices").Exists() {
+		return responses[0], nil
+	}
+
+	out := responses[0].Payload
+	for i, resp := range responses {
+		choice := gjson.GetBytes(resp.Payload, "choices.0")
+		if !choice.Exists() {
+			continue
+		}
+		indexed, _ := sjson.SetBytes([]byte(choice.Raw), "index", i)
+		out, _ = sjson.SetRawBytes(out, fmt.Sprintf("choices.%d", i), indexed)
+	}
+	return cliproxyexecutor.Response{Payload: out}, nil
+}
+
+// executeChoicesStream serves a streaming chat completion with n > 1. The n Juma streams
+// run concurrently and their chunks are interleaved as they arrive, each rewritten to
+// carry its choice index. The first error ends the merged stream and cancels the rest.
+// With stream_options.include_usage, the per-choice usage chunks are combined into one.
+//
+// Only the first stream is opened before returning, so its error still fails the request
+// as a whole. The others are opened while the earlier ones are already being relayed:
+// with juma.max-concurrent-requests below n, a later choice waits for an earlier one to
+// finish and free its slot, which needs the caller to be reading the merged stream.
+func (e *JumaExecutor) executeChoicesStream(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options, n int) (<-chan cliproxyexecutor.StreamChunk, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	choiceCtx, choiceReq := jumaChoiceRequest(streamCtx, req)
+
+	first, err := e.ExecuteStream(choiceCtx, auth, choiceReq, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	out := make(chan cliproxyexecutor.StreamChunk)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		merged := make(chan cliproxyexecutor.StreamChunk)
+		var wg sync.WaitGroup
+		relay := func(i int, stream <-chan cliproxyexecutor.StreamChunk) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer drainJumaStream(stream)
+				for chunk := range stream {
+					if chunk.Err == nil && len(chunk.Payload) > 0 && gjson.GetBytes(chunk.Payload, "choices.0").Exists() {
+						chunk.Payload, _ = sjson.SetBytes(chunk.Payload, "choices.0.index", i)
+					}
+					select {
+					case merged <- chunk:
+					case <-streamCtx.Done():
+						return
+					}
+				}
+			}()
+		}
+		relay(0, first)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i < n; i++ {
+				stream, errStream := e.ExecuteStream(choiceCtx, auth, choiceReq, opts)
+				if errStream != nil {
+					select {
+					case merged <- cliproxyexecutor.StreamChunk{Err: errStream}:
+					case <-streamCtx.Done():
+					}
+					return
+				}
+				relay(i, stream)
+			}
+		}()
+		go func() {
+			wg.Wait()
+			close(merged)
+		}()
+
+		var promptTokens, completionTokens, reasoningTokens int64
+		usageSeen, failed := false, false
+		for chunk := range merged {
+			if chunk.Err == nil {
+				if usageResult := gjson.GetBytes(chunk.Payload, "usage"); usageResult.Exists() && len(gjson.GetBytes(chunk.Payload, "choices").Array()) == 0 {
+					usageSeen = true
+					promptTokens = usageResult.Get("prompt_tokens").Int()
+					reasoning := usageResult.Get("completion_tokens_details.reasoning_tokens").Int()
+					completionTokens += usageResult.Get("completion_tokens").Int() - reasoning
+					reasoningTokens += reasoning
+					continue
+				}
+			}
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				cancel()
+			}
+			if chunk.Err != nil {
+				failed = true
+				cancel()
+				break
+			}
+		}
+		for range merged {
+		}
+		if usageSeen && !failed && ctx.Err() == nil {
+			select {
+			case out <- cliproxyexecutor.StreamChunk{Payload: buildOpenAIStreamUsageChunk(req.Model, promptTokens, completionTokens, reasoningTokens)}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
+
+// drainJumaStream discards what is left of a stream so its producer can finish.
+func drainJumaStream(stream <-chan cliproxyexecutor.StreamChunk) {
+	for range stream {
+	}
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	"github.com/tidwall/gjson"
+)
+
+// jumaDoerFunc adapts a function to HTTPDoer; unlike jumaStubDoer it is safe for
+// concurrent use.
+type jumaDoerFunc func(req *http.Request) (*http.Response, error)
+
+func (f jumaDoerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestExecuteChoicesStream_MoreChoicesThanSlots(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.MaxConcurrentRequests = 1
+	cfg.Juma.QueueTimeout = 5
+	e := NewJumaExecutor(cfg)
+	e.SetHTTPClientFactory(func(context.Context, *config.Config, *cliproxyauth.Auth, time.Duration) HTTPDoer {
+		return jumaDoerFunc(func(req *http.Request) (*http.Response, error) {
+			resp := jumaStubResponse(http.StatusOK, "data: {\"type\":\"text-delta\",\"delta\":\"hello\"}\n\ndata: {\"type\":\"finish\",\"finishReason\":\"stop\"}\n\n")
+			resp.Header.Set("Content-Type", "text/event-stream")
+			resp.Request = req
+			return resp, nil
+		})
+	})
+	auth := &cliproxyauth.Auth{ID: "juma-choices-test", Attributes: map[string]string{
+		"session_token": "token",
+		"workspace_id":  "0b6f3c1e-8a2d-4f5b-9c7e-1d2a3b4c5d6e",
+	}}
+	req := cliproxyexecutor.Request{
+		Model:   "juma-gpt-5.1",
+		Payload: []byte(`{"model":"juma-gpt-5.1","n":2,"stream":true,"messages":[{"role":"user","content":"hi"}]}`),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	stream, err := e.ExecuteStream(ctx, auth, req, cliproxyexecutor.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := make(map[int64]string)
+	for chunk := range stream {
+		if chunk.Err != nil {
+			t.Fatalf("unexpected stream error: %v", chunk.Err)
+		}
+		if choice := gjson.GetBytes(chunk.Payload, "choices.0"); choice.Exists() {
+			content[choice.Get("index").Int()] += choice.Get("delta.content").String()
+		}
+	}
+	for i := int64(0); i < 2; i++ {
+		if content[i] != "hello" {
+			t.Errorf("choice %d: expected %q, got %q", i, "hello", content[i])
+		}
+	}
+}
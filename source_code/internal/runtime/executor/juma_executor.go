@@ -4,27 +4,81 @@ package executor
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/jumaclient"
+	internalusage "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 const (
-	// jumaBaseURL is the base URL for the Juma API.
-	jumaBaseURL = "https://app.juma.ai"
+	// jumaBaseURL is the default base URL for the Juma API.
+	jumaBaseURL = jumaclient.DefaultBaseURL
+	// jumaSessionCookieName is the cookie carrying the Juma session token.
+	jumaSessionCookieName = jumaclient.SessionCookieName
+	// jumaSessionExpiredMessage marks auths whose session token Juma no longer accepts.
+	jumaSessionExpiredMessage = "juma session expired: re-authentication required"
+	// jumaDefaultUserAgent is sent when no User-Agent is configured.
+	jumaDefaultUserAgent = jumaclient.DefaultUserAgent
+	// jumaDefaultRequestTimeout is the overall deadline for a Juma chat request,
+	// including reading the streamed response body.
+	jumaDefaultRequestTimeout = 5 * time.Minute
+	// jumaDefaultIdleTimeout aborts a Juma response when no SSE line arrives within this window.
+	jumaDefaultIdleTimeout = 60 * time.Second
+	// jumaDefaultUploadConcurrency bounds parallel image uploads when not configured.
+	jumaDefaultUploadConcurrency = 4
+	// jumaDefaultLocale is the Accept-Language sent when juma.locale is not configured.
+	jumaDefaultLocale = jumaclient.DefaultLocale
+	// jumaDefaultKeepAliveInterval is how often a quiet stream emits a keepalive comment.
+	jumaDefaultKeepAliveInterval = 15 * time.Second
+	// jumaDefaultResponseCacheTTL is how long cached responses live when juma.response-cache.ttl is unset.
+	jumaDefaultResponseCacheTTL = 60 * time.Second
+	// jumaDefaultPresignedURLPath is the presigned upload endpoint when juma.trpc does not override it.
+	jumaDefaultPresignedURLPath = jumaclient.DefaultPresignedURLPath
+	// jumaDefaultRetryMaxAttempts is the total attempts for a Juma chat request on 502/503/504.
+	jumaDefaultRetryMaxAttempts = 3
+	// jumaDefaultRetryBackoff is the delay before the first retry; it doubles per attempt.
+	jumaDefaultRetryBackoff = 500 * time.Millisecond
+	// jumaMaxRetryBackoff caps the delay between retries.
+	jumaMaxRetryBackoff = 10 * time.Second
+	// jumaDefaultQueueTimeout is how long a request waits for a per-auth concurrency slot.
+	jumaDefaultQueueTimeout = 30 * time.Second
+	// jumaMaxImageGenerations caps "n" for images-generation requests.
+	jumaMaxImageGenerations = 4
+	// jumaDefaultKnowledgeItemSource is the knowledge item "source" tag Juma's web client sends.
+	jumaDefaultKnowledgeItemSource = "AttachedNewContextSnippet"
+	// jumaDefaultMaxSSELineBytes caps a single SSE line when not configured.
+	jumaDefaultMaxSSELineBytes = 20 << 20 // 20 MiB
+	// jumaImageProgressInterval throttles image tool progress chunks in streams.
+	jumaImageProgressInterval = 5 * time.Second
+	// jumaImageProgressText is streamed as a status line while an image tool runs.
+	jumaImageProgressText = "_Generating image..._\n"
 	// jumaMaxRemoteImageBytes limits remote image fetch size when converting non-data URLs.
 	jumaMaxRemoteImageBytes = 10 << 20 // 10 MiB
 )
@@ -32,7 +86,10 @@ const (
 // JumaExecutor implements a stateless executor for Juma.ai.
 // It handles session token authentication and SSE streaming responses.
 type JumaExecutor struct {
-	cfg *config.Config
+	cfg           *config.Config
+	clientFactory JumaHTTPClientFactory
+	moderator     JumaModerator
+	workspaces    jumaWorkspaceCache
 }
 
 // NewJumaExecutor creates a new Juma executor instance.
@@ -70,17 +127,24 @@ type JumaMessagePart struct {
 
 // JumaRequest represents the request body for Juma chat API.
 type JumaRequest struct {
-	Messages           []JumaMessage `json:"messages"`
-	ModelID            string        `json:"modelId"`
-	ThreadID           string        `json:"threadId"`
-	WorkspaceID        string        `json:"workspaceId"`
-	PromptUsages       []any         `json:"promptUsages"`
-	CurrentFolderID    *string       `json:"currentFolderId"`
-	VendorConnectionID string        `json:"vendorConnectionId"`
-	IsNewThread        bool          `json:"isNewThread"`
-	ParentFolderID     *string       `json:"parentFolderId"`
-	KnowledgeItems     []any         `json:"knowledgeItems"`
-	Tools              []JumaTool    `json:"tools,omitempty"`
+	Messages           []JumaMessage      `json:"messages"`
+	ModelID            string             `json:"modelId"`
+	ThreadID           string             `json:"threadId"`
+	WorkspaceID        string             `json:"workspaceId"`
+	PromptUsages       []any              `json:"promptUsages"`
+	CurrentFolderID    *string            `json:"currentFolderId"`
+	VendorConnectionID string             `json:"vendorConnectionId"`
+	IsNewThread        bool               `json:"isNewThread"`
+	ParentFolderID     *string            `json:"parentFolderId"`
+	KnowledgeItems     []any              `json:"knowledgeItems"`
+	Tools              []JumaTool         `json:"tools,omitempty"`
+	Temperature        *float64           `json:"temperature,omitempty"`
+	TopP               *float64           `json:"topP,omitempty"`
+	MaxTokens          *int64             `json:"maxTokens,omitempty"`
+	Seed               *int64             `json:"seed,omitempty"`
+	LogitBias          map[string]float64 `json:"logitBias,omitempty"`
+	Trigger            string             `json:"trigger,omitempty"`   // "regenerate-message" when regenerating
+	MessageID          string             `json:"messageId,omitempty"` // Assistant message being regenerated
 }
 
 // JumaTool represents a tool definition for Juma.
@@ -98,28 +162,163 @@ type JumaToolFunction struct {
 
 // JumaModel represents a supported Juma model.
 type JumaModel struct {
-	ID                 string // Juma's internal UUID for the model
-	Name               string // Display name (e.g., "GPT-5.1")
-	Alias              string // User-facing alias (e.g., "juma-gpt-5.1")
-	Provider           string // Vendor type (e.g., "OpenAI", "Gemini")
-	VendorConnectionID string // Juma's vendor connection UUID
+	ID                 string     // Juma's internal UUID for the model
+	Name               string     // Display name (e.g., "GPT-5.1")
+	Alias              string     // User-facing alias (e.g., "juma-gpt-5.1")
+	Provider           string     // Vendor type (e.g., "OpenAI", "Gemini")
+	VendorConnectionID string     // Juma's vendor connection UUID
+	ImageCapable       bool       // Returns generated images as an OpenAI image response
+	ForcedSystemPrompt string     // Replaces user system prompts when set
+	Tools              []JumaTool // Tools attached to every request for this model
+	// KeepUserSystemPrompt keeps client system messages after ForcedSystemPrompt.
+	KeepUserSystemPrompt bool
 }
 
-// jumaModels contains the hardcoded list of supported Juma models.
-// These model IDs were obtained through API exploration.
+// jumaImageEditSystemPrompt forces image-capable models to call the ImageEdit tool.
+// juma.image-edit-system-prompt overrides it.
+const jumaImageEditSystemPrompt = "You are an expert image editing assistant. When the user provides an image, you MUST use the 'ImageEdit' tool to modify it according to their instructions. Do not just describe the edit. Always output the tool call."
+
+// jumaImageEditTool is the ImageEdit tool definition attached to image editing models.
+var jumaImageEditTool = JumaTool{
+	Type: "function",
+	Function: JumaToolFunction{
+		Name:        "ImageEdit",
+		Description: "Edit or generate images based on text prompts. Use this tool when the user asks to generate, edit, or modify images.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"prompt": map[string]any{
+					"type":        "string",
+					"description": "The prompt describing the image to generate or the edit to make",
+				},
+				"imageUrls": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "URLs of images to edit (optional for generation)",
+				},
+				"orientation": map[string]any{
+					"type":        "string",
+					"enum":        []string{"vertical", "horizontal", "square"},
+					"description": "The orientation of the output image",
+				},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+}
+
+// applyJumaImageEditPrompt returns a copy of model using juma.image-edit-system-prompt
+// as its forced system prompt. Without an override the built-in prompt is kept and
+// client system messages are preserved after it instead of being dropped.
+func applyJumaImageEditPrompt(cfg *config.Config, model *JumaModel) *JumaModel {
+	if model.ForcedSystemPrompt == "" {
+		return model
+	}
+	withPrompt := *model
+	if cfg != nil {
+		if override := strings.TrimSpace(cfg.Juma.ImageEditSystemPrompt); override != "" {
+			withPrompt.ForcedSystemPrompt = override
+			return &withPrompt
+		}
+	}
+	withPrompt.KeepUserSystemPrompt = true
+	return &withPrompt
+}
+
+// jumaImageOrientationHeader lets clients pick the ImageEdit output orientation.
+const jumaImageOrientationHeader = "X-Image-Orientation"
+
+// jumaImageOrientations are the values accepted by the ImageEdit tool's orientation enum.
+var jumaImageOrientations = map[string]struct{}{"vertical": {}, "horizontal": {}, "square": {}}
+
+// jumaImageOrientation resolves the requested output orientation from the
+// X-Image-Orientation header, or else from the payload's orientation, size ("1024x1792")
+// or aspect_ratio ("16:9") hints. An empty result means no preference.
+func jumaImageOrientation(ctx context.Context, payload []byte) (string, error) {
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+		if header := strings.ToLower(strings.TrimSpace(ginCtx.Request.Header.Get(jumaImageOrientationHeader))); header != "" {
+			if _, valid := jumaImageOrientations[header]; !valid {
+				return "", statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid %s %q: must be vertical, horizontal or square", jumaImageOrientationHeader, header)}
+			}
+			return header, nil
+		}
+	}
+	if v := strings.ToLower(strings.TrimSpace(gjson.GetBytes(payload, "orientation").String())); v != "" {
+		if _, valid := jumaImageOrientations[v]; valid {
+			return v, nil
+		}
+	}
+	if v := gjson.GetBytes(payload, "size").String(); v != "" {
+		if orientation := jumaOrientationFromDimensions(v, "x"); orientation != "" {
+			return orientation, nil
+		}
+	}
+	if v := gjson.GetBytes(payload, "aspect_ratio").String(); v != "" {
+		return jumaOrientationFromDimensions(v, ":"), nil
+	}
+	return "", nil
+}
+
+// jumaOrientationFromDimensions classifies "WxH" or "W:H" style values.
+func jumaOrientationFromDimensions(value, sep string) string {
+	w, h, found := strings.Cut(strings.ToLower(strings.TrimSpace(value)), sep)
+	if !found {
+		return ""
+	}
+	width, errW := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	height, errH := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return ""
+	}
+	switch {
+	case width > height:
+		return "horizontal"
+	case height > width:
+		return "vertical"
+	default:
+		return "square"
+	}
+}
+
+// applyJumaImageOrientation returns a copy of model whose forced system prompt pins the
+// ImageEdit orientation when the request expresses one. Models without the ImageEdit
+// tool are returned unchanged.
+func applyJumaImageOrientation(ctx context.Context, model *JumaModel, payload []byte) (*JumaModel, error) {
+	hasImageEdit := false
+	for _, tool := range model.Tools {
+		if tool.Function.Name == jumaImageEditTool.Function.Name {
+			hasImageEdit = true
+			break
+		}
+	}
+	if !hasImageEdit {
+		return model, nil
+	}
+	orientation, err := jumaImageOrientation(ctx, payload)
+	if err != nil || orientation == "" {
+		return model, err
+	}
+	withOrientation := *model
+	withOrientation.ForcedSystemPrompt = strings.TrimSpace(model.ForcedSystemPrompt + fmt.Sprintf(" When calling the 'ImageEdit' tool, always set orientation to %q.", orientation))
+	return &withOrientation, nil
+}
+
+// jumaModels contains the hardcoded list of supported Juma models, built on the IDs
+// in jumaclient's model catalog.
 var jumaModels = []JumaModel{
-	// OpenAI models (vendor: f5275937-68f8-4bfe-b195-c48f2155263b)
-	{ID: "401637fa-151b-41f5-aa36-5416ad1314fb", Name: "GPT-5.1", Alias: "juma-gpt-5.1", Provider: "OpenAI", VendorConnectionID: "f5275937-68f8-4bfe-b195-c48f2155263b"},
+	// OpenAI models
+	{ID: jumaclient.ModelIDGPT51, Name: "GPT-5.1", Alias: "juma-gpt-5.1", Provider: "OpenAI", VendorConnectionID: jumaclient.VendorConnectionOpenAI},
 
-	// Anthropic models via Bedrock (vendor: f958317e-9359-42cc-8c45-4ed306bf5f65)
-	{ID: "790cee03-6d71-4b6a-bf86-7781c9592028", Name: "Claude Opus 4.5", Alias: "juma-claude-opus-4.5", Provider: "Anthropic", VendorConnectionID: "f958317e-9359-42cc-8c45-4ed306bf5f65"},
+	// Anthropic models via Bedrock
+	{ID: jumaclient.ModelIDClaudeOpus45, Name: "Claude Opus 4.5", Alias: "juma-claude-opus-4.5", Provider: "Anthropic", VendorConnectionID: jumaclient.VendorConnectionAnthropic},
 
-	// Google AI models (vendor: 2eb35c4f-3afe-4d12-b953-70b5c8bb643e)
-	{ID: "c073a0c0-e3d0-4e0b-b36c-29584b674125", Name: "Gemini 3 Pro", Alias: "juma-gemini-3-pro", Provider: "Google", VendorConnectionID: "2eb35c4f-3afe-4d12-b953-70b5c8bb643e"},
+	// Google AI models
+	{ID: jumaclient.ModelIDGemini3Pro, Name: "Gemini 3 Pro", Alias: "juma-gemini-3-pro", Provider: "Google", VendorConnectionID: jumaclient.VendorConnectionGoogle},
 
 	// Nanobanana Pro - Image Editing (Actually Gemini 3 Pro with tool usage)
 	// We use the same IDs as Gemini 3 Pro but treat it as a distinct model with forced image editing behavior.
-	{ID: "c073a0c0-e3d0-4e0b-b36c-29584b674125", Name: "Nanobanana Pro", Alias: "juma-nanobanana-pro", Provider: "Google", VendorConnectionID: "2eb35c4f-3afe-4d12-b953-70b5c8bb643e"},
+	{ID: jumaclient.ModelIDGemini3Pro, Name: "Nanobanana Pro", Alias: "juma-nanobanana-pro", Provider: "Google", VendorConnectionID: jumaclient.VendorConnectionGoogle,
+		ImageCapable: true, ForcedSystemPrompt: jumaImageEditSystemPrompt, Tools: []JumaTool{jumaImageEditTool}},
 }
 
 // getJumaModelByAlias finds a Juma model by its alias.
@@ -132,22 +331,334 @@ func getJumaModelByAlias(alias string) *JumaModel {
 	return nil
 }
 
-// jumaCredentials extracts session token and IDs from auth.
+// ListJumaModels returns a copy of the supported Juma models, including their tools, so
+// callers cannot modify the package table.
+func ListJumaModels() []JumaModel {
+	models := make([]JumaModel, len(jumaModels))
+	for i, model := range jumaModels {
+		if model.Tools != nil {
+			tools := make([]JumaTool, len(model.Tools))
+			for j, tool := range model.Tools {
+				tool.Function.Parameters = cloneJumaToolValue(tool.Function.Parameters).(map[string]any)
+				tools[j] = tool
+			}
+			model.Tools = tools
+		}
+		models[i] = model
+	}
+	return models
+}
+
+// JumaModelAliases returns the aliases of the supported Juma models in table order.
+func JumaModelAliases() []string {
+	aliases := make([]string, len(jumaModels))
+	for i := range jumaModels {
+		aliases[i] = jumaModels[i].Alias
+	}
+	return aliases
+}
+
+// cloneJumaToolValue deep-copies the maps and slices of a tool parameter schema.
+func cloneJumaToolValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = cloneJumaToolValue(item)
+		}
+		return out
+	case []any:
+		if v == nil {
+			return v
+		}
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = cloneJumaToolValue(item)
+		}
+		return out
+	case []string:
+		return slices.Clone(v)
+	default:
+		return v
+	}
+}
+
+// ResolveJumaModelAlias maps an incoming model name through juma.alias-map, returning
+// the name unchanged when it has no mapping.
+func ResolveJumaModelAlias(cfg *config.Config, name string) string {
+	if cfg == nil || len(cfg.Juma.AliasMap) == 0 {
+		return name
+	}
+	if target, ok := cfg.Juma.AliasMap[name]; ok {
+		return target
+	}
+	for from, target := range cfg.Juma.AliasMap {
+		if strings.EqualFold(from, name) {
+			return target
+		}
+	}
+	return name
+}
+
+// JumaModelAdvertised reports whether juma.models lets this deployment offer the model
+// with the given alias. Unknown aliases are never advertised.
+func JumaModelAdvertised(cfg *config.Config, alias string) bool {
+	model := getJumaModelByAlias(alias)
+	if model == nil {
+		return false
+	}
+	if cfg == nil {
+		return true
+	}
+	filter := cfg.Juma.Models
+	alias = strings.ToLower(model.Alias)
+	if len(filter.Providers) > 0 && !slices.ContainsFunc(filter.Providers, func(provider string) bool {
+		return strings.EqualFold(strings.TrimSpace(provider), model.Provider)
+	}) {
+		return false
+	}
+	if len(filter.Include) > 0 && !jumaAliasMatchesAny(filter.Include, alias) {
+		return false
+	}
+	return !jumaAliasMatchesAny(filter.Exclude, alias)
+}
+
+// jumaAliasMatchesAny reports whether alias matches one of the glob patterns.
+func jumaAliasMatchesAny(patterns []string, alias string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(strings.TrimSpace(pattern)), alias); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// jumaBaseURLFor returns the configured Juma base URL, falling back to jumaBaseURL.
+func jumaBaseURLFor(cfg *config.Config) string {
+	if cfg != nil {
+		if base := strings.TrimRight(strings.TrimSpace(cfg.Juma.BaseURL), "/"); base != "" {
+			return base
+		}
+	}
+	return jumaBaseURL
+}
+
+// jumaLocale returns the Accept-Language value sent to Juma.
+func jumaLocale(cfg *config.Config) string {
+	if cfg != nil {
+		if locale := strings.TrimSpace(cfg.Juma.Locale); locale != "" {
+			return locale
+		}
+	}
+	return jumaDefaultLocale
+}
+
+// jumaTRPCHeaders returns jumaclient's default tRPC headers merged with
+// juma.trpc.headers. A configured empty value drops the header.
+func jumaTRPCHeaders(cfg *config.Config) map[string]string {
+	headers := make(map[string]string, len(jumaclient.DefaultTRPCHeaders))
+	for name, value := range jumaclient.DefaultTRPCHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	if cfg != nil {
+		for name, value := range cfg.Juma.TRPC.Headers {
+			name = http.CanonicalHeaderKey(name)
+			if value = strings.TrimSpace(value); value != "" {
+				headers[name] = value
+			} else {
+				delete(headers, name)
+			}
+		}
+	}
+	return headers
+}
+
+// setJumaTRPCHeaders applies jumaTRPCHeaders to req.
+func setJumaTRPCHeaders(cfg *config.Config, req *http.Request) {
+	for name, value := range jumaTRPCHeaders(cfg) {
+		req.Header.Set(name, value)
+	}
+}
+
+// jumaPresignedURLPath returns the path of Juma's presigned upload URL endpoint.
+func jumaPresignedURLPath(cfg *config.Config) string {
+	if cfg != nil && cfg.Juma.TRPC.PresignedURLPath != "" {
+		return cfg.Juma.TRPC.PresignedURLPath
+	}
+	return jumaDefaultPresignedURLPath
+}
+
+// jumaUserAgentCounter drives round-robin selection across configured User-Agents.
+var jumaUserAgentCounter atomic.Uint64
+
+// jumaUserAgent returns the User-Agent for the next Juma request. A configured list is
+// rotated round-robin; otherwise the single override or jumaDefaultUserAgent is used.
+func jumaUserAgent(cfg *config.Config) string {
+	if cfg != nil {
+		if n := uint64(len(cfg.Juma.UserAgents)); n > 0 {
+			return cfg.Juma.UserAgents[(jumaUserAgentCounter.Add(1)-1)%n]
+		}
+		if ua := strings.TrimSpace(cfg.Juma.UserAgent); ua != "" {
+			return ua
+		}
+	}
+	return jumaDefaultUserAgent
+}
+
+// jumaLogEntry returns a log entry tagged with the Juma provider and the given fields.
+func jumaLogEntry(fields log.Fields) *log.Entry {
+	entry := log.WithField("provider", "juma")
+	if len(fields) > 0 {
+		entry = entry.WithFields(fields)
+	}
+	return entry
+}
+
+// jumaCredentials extracts session token and IDs from auth. An auth holding several
+// session tokens yields the next one in rotation.
 func jumaCredentials(auth *cliproxyauth.Auth) (sessionToken, workspaceID, vendorConnectionID string) {
 	if auth == nil || auth.Attributes == nil {
 		return "", "", ""
 	}
-	sessionToken = strings.TrimSpace(auth.Attributes["session_token"])
-	workspaceID = strings.TrimSpace(auth.Attributes["workspace_id"])
+	sessionToken = pickJumaSessionToken(jumaSessionTokens(auth))
+	workspaceID = jumaWorkspaceID(auth)
 	vendorConnectionID = strings.TrimSpace(auth.Attributes["vendor_connection_id"])
 	return
 }
 
+// jumaVendorConnectionHeader lets clients pick the vendor connection for one request.
+const jumaVendorConnectionHeader = "X-Juma-Vendor-Connection"
+
+// jumaImageBackendHeader lets clients pick a juma.image-backends entry for one request.
+const jumaImageBackendHeader = "X-Image-Backend"
+
+// selectJumaImageBackend applies the juma.image-backends entry requested through the
+// X-Image-Backend header or the "image_backend" payload field to an image-capable model.
+// It returns the model to use and the backend's vendor connection ID, if any. Requests
+// without a hint, and hints naming no configured backend, keep the model's defaults.
+func selectJumaImageBackend(ctx context.Context, cfg *config.Config, model *JumaModel, payload []byte) (*JumaModel, string) {
+	if cfg == nil || len(cfg.Juma.ImageBackends) == 0 || model == nil || !model.ImageCapable {
+		return model, ""
+	}
+	name := ""
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+		name = ginCtx.Request.Header.Get(jumaImageBackendHeader)
+	}
+	if strings.TrimSpace(name) == "" {
+		name = gjson.GetBytes(payload, "image_backend").String()
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return model, ""
+	}
+	backend, ok := cfg.Juma.ImageBackends[name]
+	if !ok {
+		jumaLogEntry(log.Fields{"backend": name, "model": model.Alias}).Debug("juma executor: unknown image backend, using model defaults")
+		return model, ""
+	}
+	if backend.Model != "" {
+		if selected := getJumaModelByAlias(backend.Model); selected != nil {
+			model = selected
+		} else {
+			jumaLogEntry(log.Fields{"backend": name, "model": backend.Model}).Warn("juma executor: image backend names an unknown model, keeping the requested model")
+		}
+	}
+	jumaLogEntry(log.Fields{"backend": name, "model": model.Alias}).Debug("juma executor: selected image backend")
+	return model, backend.VendorConnectionID
+}
+
+// jumaVendorConnectionOverride returns a vendor connection ID that takes precedence over
+// the auth's vendor_connection_id and the model default, for workspaces with several
+// connections to the same provider. The X-Juma-Vendor-Connection header wins over the
+// auth's "vendor_connections" attribute, a comma-separated list of alias=uuid pairs.
+// The result is validated as a UUID by validateJumaIDs.
+func jumaVendorConnectionOverride(ctx context.Context, auth *cliproxyauth.Auth, alias string) string {
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+		if header := strings.TrimSpace(ginCtx.Request.Header.Get(jumaVendorConnectionHeader)); header != "" {
+			return header
+		}
+	}
+	if auth == nil || auth.Attributes == nil {
+		return ""
+	}
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	for _, entry := range strings.Split(auth.Attributes["vendor_connections"], ",") {
+		name, id, found := strings.Cut(entry, "=")
+		if found && strings.ToLower(strings.TrimSpace(name)) == alias {
+			return strings.TrimSpace(id)
+		}
+	}
+	return ""
+}
+
+// jumaModelAllowed applies the optional per-auth model policy. The "allowed_models" and
+// "denied_models" attributes hold comma-separated model aliases; "*" in allowed_models
+// permits everything. A denied entry wins over an allowed one, and an empty allowlist
+// permits every model that is not denied.
+func jumaModelAllowed(auth *cliproxyauth.Auth, alias string) bool {
+	if auth == nil || auth.Attributes == nil {
+		return true
+	}
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if jumaModelListContains(auth.Attributes["denied_models"], alias) {
+		return false
+	}
+	allowed := strings.TrimSpace(auth.Attributes["allowed_models"])
+	return allowed == "" || jumaModelListContains(allowed, "*") || jumaModelListContains(allowed, alias)
+}
+
+// jumaModelListContains reports whether the comma-separated list contains alias,
+// ignoring case and surrounding whitespace.
+func jumaModelListContains(list, alias string) bool {
+	for _, entry := range strings.Split(list, ",") {
+		if strings.ToLower(strings.TrimSpace(entry)) == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// validateJumaIDs ensures the configured workspace ID and the resolved vendor connection ID
+// look like UUIDs so copy-paste mistakes fail fast instead of producing opaque Juma errors.
+// An empty workspace ID is allowed because it is auto-discovered before the request is sent.
+func validateJumaIDs(workspaceID, vendorConnectionID string) error {
+	if workspaceID != "" && !isJumaUUID(workspaceID) {
+		return statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid Juma workspace_id %q: expected a UUID", workspaceID)}
+	}
+	if !isJumaUUID(vendorConnectionID) {
+		return statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid Juma vendor_connection_id %q: expected a UUID", vendorConnectionID)}
+	}
+	return nil
+}
+
+// isJumaUUID reports whether value consists solely of a UUID.
+func isJumaUUID(value string) bool {
+	return value != "" && jumaUUIDRegex.FindString(value) == value
+}
+
 // JumaUploadedImage represents an uploaded image in Juma's format.
 type JumaUploadedImage struct {
 	ID       string `json:"id"`
 	ImageURL string `json:"imageUrl"`
 	Name     string `json:"name"`
+	// KnowledgeItemID references the image in knowledgeItems; empty means the image is
+	// attached through uploadedImages only.
+	KnowledgeItemID string `json:"-"`
+	// Width, Height and Size describe the uploaded image; they are not sent to Juma.
+	Width  int   `json:"width,omitempty"`
+	Height int   `json:"height,omitempty"`
+	Size   int64 `json:"size,omitempty"`
+}
+
+// JumaUploadedFile represents an uploaded document in Juma's format.
+type JumaUploadedFile struct {
+	ID       string `json:"id"`
+	FileURL  string `json:"fileUrl"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
 }
 
 // JumaConversionResult contains the converted messages and collected image info.
@@ -155,31 +666,40 @@ type JumaConversionResult struct {
 	Messages       []JumaMessage
 	KnowledgeItems []map[string]string // Legacy: for knowledgeItemId if available
 	UploadedImages []JumaUploadedImage // New: for direct image attachment via uploadedImages
+	UploadedFiles  []JumaUploadedFile  // Documents (e.g. PDFs) attached as knowledge items
+	UploadMetrics  usage.UploadDetail  // Upload counts and step latencies for usage reporting
+	FailedImages   int                 // Request images that could not be uploaded
+	Err            error               // Set when the messages reference invalid Juma IDs
 }
 
 // convertToJumaMessages converts OpenAI-style messages to Juma format.
 // Supports both simple string content and array content with text/image_url parts.
 // When provided with Juma session credentials, it uploads base64 or remote images to
-// Juma storage and collects their knowledge item IDs into KnowledgeItems.
-func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken string, workspaceID string) JumaConversionResult {
-	log.Infof("juma executor: convertToJumaMessages called, cfgNil=%v, cfgJumaKeyLen=%d", cfg == nil, func() int {
-		if cfg != nil {
-			return len(cfg.JumaKey)
-		}
-		return 0
-	}())
-	msgs := gjson.GetBytes(payload, "messages").Array()
+// Juma storage and collects their knowledge item IDs into KnowledgeItems. Image parts
+// carrying an image_id reference an earlier upload and are attached without uploading.
+// A non-nil progress receives the progress of every upload. With dryRun nothing is
+// uploaded and attachments carry placeholder IDs instead.
+func convertToJumaMessages(cfg *config.Config, payload []byte, model *JumaModel, sessionToken string, workspaceID string, progress JumaUploadProgressFunc, dryRun bool) JumaConversionResult {
+	msgs := trimJumaContext(cfg, gjson.GetBytes(payload, "messages").Array())
+	jumaLogEntry(log.Fields{"message_count": len(msgs)}).Debug("juma executor: converting messages")
 	result := make([]JumaMessage, 0, len(msgs))
 	uploadedImages := make([]JumaUploadedImage, 0)
-
-	// Determine if we need to inject system prompt for Nanobanana
-	model := gjson.GetBytes(payload, "model").String()
-	if isNanobananaModel(model) {
+	uploadedFiles := make([]JumaUploadedFile, 0)
+	uploadMetrics := &jumaUploadMetrics{progress: progress, dryRun: dryRun}
+	failedImages := 0
+	var conversionErr error
+
+	// Inject the model's forced system prompt (e.g. ImageEdit instructions for Nanobanana)
+	forcedSystemPrompt := ""
+	if model != nil {
+		forcedSystemPrompt = model.ForcedSystemPrompt
+	}
+	if forcedSystemPrompt != "" {
 		systemPrompt := JumaMessage{
 			ID:              uuid.New().String(),
 			Role:            "system",
-			Content:         "You are an expert image editing assistant. When the user provides an image, you MUST use the 'ImageEdit' tool to modify it according to their instructions. Do not just describe the edit. Always output the tool call.",
-			Parts:           []JumaMessagePart{{Type: "text", Text: "You are an expert image editing assistant. When the user provides an image, you MUST use the 'ImageEdit' tool to modify it according to their instructions. Do not just describe the edit. Always output the tool call."}},
+			Content:         forcedSystemPrompt,
+			Parts:           []JumaMessagePart{{Type: "text", Text: forcedSystemPrompt}},
 			GeneratedImages: []any{},
 			UploadedImages:  []any{},
 			UploadedFiles:   []any{},
@@ -189,7 +709,7 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 
 	for _, msg := range msgs {
 		role := msg.Get("role").String()
-		if role == "system" && isNanobananaModel(model) {
+		if role == "system" && forcedSystemPrompt != "" && !model.KeepUserSystemPrompt {
 			continue // Skip user-provided system prompts if we injected our own
 		}
 
@@ -198,46 +718,73 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 		var textContent string
 		// Track images for THIS specific message only
 		var msgImages []JumaUploadedImage
+		var msgFiles []JumaUploadedFile
+		// Images on prior assistant turns are model output, not user uploads.
+		var generatedSources []string
+		// Images passed inline when uploads are disabled.
+		var inlineImages []string
+		// Images uploaded by an earlier request and referenced by their Juma ID.
+		var referencedImages []JumaUploadedImage
 
 		// Handle both string content and array content
 		if contentRaw.IsArray() {
-			// OpenAI vision-style content array
-			handleDataURLUpload := func(dataURL string) *JumaUploadedImage {
-				log.Infof("juma executor: attempting Juma upload, sessionToken=%v, workspaceID=%v", sessionToken != "", workspaceID != "")
-
+			// OpenAI vision-style content array.
+			// Image sources are collected first and uploaded concurrently once the
+			// whole message has been scanned, preserving their original order.
+			var imageSources []string
+
+			handleFileDataURLUpload := func(dataURL, filename string) {
+				if dryRun {
+					mimeType, _, _ := parseJumaDataURL(dataURL)
+					file := JumaUploadedFile{ID: jumaDryRunPlaceholderID, FileURL: jumaDryRunPlaceholderURL, Name: filename, MimeType: mimeType}
+					msgFiles = append(msgFiles, file)
+					uploadedFiles = append(uploadedFiles, file)
+					return
+				}
 				if sessionToken == "" || workspaceID == "" {
-					log.Warnf("juma executor: missing session token or workspace ID for image upload")
-					return nil
+					jumaLogEntry(nil).Warn("juma executor: missing session token or workspace ID for file upload")
+					return
 				}
 
-				uploadResult, err := UploadImageToJuma(sessionToken, workspaceID, dataURL)
+				uploadResult, err := uploadFileToJuma(cfg, sessionToken, workspaceID, dataURL, filename, uploadMetrics)
 				if err != nil {
-					log.Warnf("juma executor: failed to upload image to Juma: %v", err)
-					return nil
+					jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to upload file to Juma")
+					return
+				}
+				if uploadResult.ID == "" {
+					jumaLogEntry(nil).Warn("juma executor: no valid file ID returned")
+					return
 				}
 
-				log.Infof("juma executor: uploaded image to Juma, ID: %s, KnowledgeItemID: %s, URL: %s", uploadResult.ID, uploadResult.KnowledgeItemID, uploadResult.ImageURL)
-
-				if uploadResult.ID != "" && uploadResult.ImageURL != "" {
-					img := JumaUploadedImage{
-						ID:       uploadResult.ID,
-						ImageURL: uploadResult.ImageURL,
-						Name:     uploadResult.Name,
-					}
-					// Add to both message-specific and global lists
-					msgImages = append(msgImages, img)
-					uploadedImages = append(uploadedImages, img)
-					log.Infof("juma executor: added image to uploadedImages: ID=%s, URL=%s", uploadResult.ID, uploadResult.ImageURL)
-					return &img
+				file := JumaUploadedFile{
+					ID:       uploadResult.ID,
+					FileURL:  uploadResult.FileURL,
+					Name:     uploadResult.Name,
+					MimeType: uploadResult.MimeType,
 				}
-				log.Warnf("juma executor: no valid image ID or URL returned")
-				return nil
+				msgFiles = append(msgFiles, file)
+				uploadedFiles = append(uploadedFiles, file)
+				jumaLogEntry(log.Fields{"file_id": file.ID, "mime_type": file.MimeType}).Info("juma executor: uploaded file to Juma")
 			}
 
 			for _, part := range contentRaw.Array() {
 				partType := part.Get("type").String()
 				if partType == "text" {
 					textContent += part.Get("text").String()
+				} else if imageID := part.Get("image_id").String(); imageID != "" && (partType == "image" || partType == "input_image") {
+					// {"type":"image","image_id":"<juma-id>"} reuses an earlier upload without
+					// re-uploading it; an optional image_url or url fills in its imageUrl.
+					if !isJumaUUID(imageID) {
+						if conversionErr == nil {
+							conversionErr = statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("image_id %q is not a valid Juma image ID", imageID)}
+						}
+						continue
+					}
+					imageURL := part.Get("image_url").String()
+					if imageURL == "" {
+						imageURL = part.Get("url").String()
+					}
+					referencedImages = append(referencedImages, JumaUploadedImage{ID: imageID, ImageURL: imageURL, KnowledgeItemID: imageID})
 				} else if partType == "image_url" || partType == "input_image" || partType == "image" {
 					// Extract URL from various OpenAI-like vision formats.
 					url := part.Get("image_url.url").String()
@@ -250,33 +797,96 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 					if url == "" {
 						url = part.Get("url").String()
 					}
+					if url != "" && strings.EqualFold(part.Get("image_url.detail").String(), "low") {
+						url = lowDetailJumaImage(url)
+					}
 					if url != "" {
-						log.Infof("juma executor: processing image URL, isDataURL=%v, cfgNil=%v", strings.HasPrefix(url, "data:"), cfg == nil)
-						// Upload base64 images to Juma's native file storage
-						if strings.HasPrefix(url, "data:") {
-							handleDataURLUpload(url)
-						} else if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
-							dataURL, err := fetchImageDataURLFromHTTP(url, jumaMaxRemoteImageBytes)
-							if err != nil {
-								log.Warnf("juma executor: failed to fetch remote image for upload: %v", err)
-							} else {
-								handleDataURLUpload(dataURL)
-							}
+						jumaLogEntry(log.Fields{"data_url": strings.HasPrefix(url, "data:")}).Debug("juma executor: processing image URL")
+						// Upload base64 or remote images to Juma's native file storage
+						if strings.HasPrefix(url, "data:") || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+							imageSources = append(imageSources, url)
 						} else {
-							log.Warnf("juma executor: image URL not supported (must be data:, http, or https)")
+							jumaLogEntry(nil).Warn("juma executor: image URL not supported (must be data:, http, or https)")
+						}
+					}
+				} else if partType == "file" || partType == "document" || partType == "input_file" {
+					// OpenAI file parts carry the document as file.file_data (a data URL);
+					// Responses API input_file parts use file_data (possibly bare base64)
+					// or file_url.
+					fileData := part.Get("file.file_data").String()
+					if fileData == "" {
+						fileData = part.Get("file_data").String()
+					}
+					if fileData == "" {
+						fileData = part.Get("document.url").String()
+					}
+					if fileData == "" {
+						fileData = part.Get("url").String()
+					}
+					if fileData == "" {
+						fileData = part.Get("file_url").String()
+					}
+					filename := part.Get("file.filename").String()
+					if filename == "" {
+						filename = part.Get("filename").String()
+					}
+					switch {
+					case fileData == "":
+						jumaLogEntry(nil).Warn("juma executor: file part without file data or URL is not supported")
+						continue
+					case strings.HasPrefix(fileData, "http://") || strings.HasPrefix(fileData, "https://"):
+						fetched, errFetch := fetchJumaFileDataURL(fileData, jumaMaxRemoteFileBytes)
+						if errFetch != nil {
+							jumaLogEntry(nil).WithError(errFetch).Warn("juma executor: failed to fetch file URL")
+							continue
 						}
+						fileData = fetched
+					case !strings.HasPrefix(fileData, "data:"):
+						wrapped, errWrap := jumaRawFileDataURL(fileData, filename)
+						if errWrap != nil {
+							jumaLogEntry(nil).WithError(errWrap).Warn("juma executor: file part data is neither a data URL nor base64")
+							continue
+						}
+						fileData = wrapped
+					}
+					// Images delivered as file parts keep using the image path.
+					if mimeType, _, errParse := parseJumaDataURL(fileData); errParse == nil && strings.HasPrefix(mimeType, "image/") {
+						imageSources = append(imageSources, fileData)
+					} else {
+						handleFileDataURLUpload(fileData, filename)
+					}
+				}
+			}
+
+			if role == "assistant" {
+				generatedSources = imageSources
+			} else if !jumaUploadImagesEnabled(cfg) {
+				inlineImages = imageSources
+			} else {
+				msgImages = uploadJumaImages(cfg, sessionToken, workspaceID, imageSources, uploadMetrics)
+				uploadedImages = append(uploadedImages, msgImages...)
+				if failed := len(imageSources) - len(msgImages); failed > 0 {
+					failedImages += failed
+					if jumaImageUploadErrorMode(cfg) == "note" {
+						textContent = jumaImageUploadErrorNote(failed) + textContent
 					}
 				}
 			}
+			msgImages = append(msgImages, referencedImages...)
+			uploadedImages = append(uploadedImages, referencedImages...)
 		} else {
 			textContent = contentRaw.String()
 		}
 
 		// Build parts - only text parts, images are passed via uploadedImages
+		// unless uploads are disabled, in which case they are inlined as image parts.
 		parts := []JumaMessagePart{}
 		if textContent != "" {
 			parts = append(parts, JumaMessagePart{Type: "text", Text: textContent})
 		}
+		for _, source := range inlineImages {
+			parts = append(parts, JumaMessagePart{Type: "image", ImageURL: source})
+		}
 
 		// Build uploadedImages array for Juma's format
 		// Images are NOT added to parts - Juma uses uploadedImages field instead
@@ -287,7 +897,23 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 				"imageUrl": img.ImageURL,
 				"name":     img.Name,
 			})
-			log.Infof("juma executor: added image to uploadedImages: ID=%s, URL=%s", img.ID, img.ImageURL)
+			jumaLogEntry(log.Fields{"image_id": img.ID}).Debug("juma executor: added image to uploadedImages")
+		}
+
+		msgUploadedFiles := make([]any, 0, len(msgFiles))
+		for _, file := range msgFiles {
+			msgUploadedFiles = append(msgUploadedFiles, map[string]any{
+				"id":       file.ID,
+				"fileUrl":  file.FileURL,
+				"name":     file.Name,
+				"mimeType": file.MimeType,
+			})
+		}
+
+		msgGeneratedImages := []any{}
+		if role == "assistant" {
+			generatedSources = append(generatedSources, extractJumaMarkdownImageURLs(textContent)...)
+			msgGeneratedImages = buildJumaGeneratedImages(cfg, sessionToken, workspaceID, generatedSources, uploadMetrics)
 		}
 
 		jumaMsg := JumaMessage{
@@ -295,9 +921,9 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 			Role:            role,
 			Content:         textContent,
 			Parts:           parts,
-			GeneratedImages: []any{},
+			GeneratedImages: msgGeneratedImages,
 			UploadedImages:  msgUploadedImages,
-			UploadedFiles:   []any{},
+			UploadedFiles:   msgUploadedFiles,
 		}
 		result = append(result, jumaMsg)
 	}
@@ -305,513 +931,2459 @@ func convertToJumaMessages(cfg *config.Config, payload []byte, sessionToken stri
 	// Build knowledgeItems from uploaded images
 	// Juma uses knowledgeItems to reference images in chat - this is the only way that works
 	knowledgeItems := make([]map[string]string, 0, len(uploadedImages))
+	knowledgeItemSource := jumaKnowledgeItemSource(cfg)
 	for _, img := range uploadedImages {
-		if img.ID != "" {
+		if img.KnowledgeItemID != "" {
 			knowledgeItems = append(knowledgeItems, map[string]string{
-				"id":     img.ID,
-				"source": "AttachedNewContextSnippet",
+				"id":     img.KnowledgeItemID,
+				"source": knowledgeItemSource,
 			})
-			log.Infof("juma executor: added to knowledgeItems: ID=%s", img.ID)
+			jumaLogEntry(log.Fields{"knowledge_item_id": img.KnowledgeItemID}).Debug("juma executor: added image to knowledgeItems")
 		}
 	}
+	for _, file := range uploadedFiles {
+		knowledgeItems = append(knowledgeItems, map[string]string{
+			"id":     file.ID,
+			"source": knowledgeItemSource,
+		})
+		jumaLogEntry(log.Fields{"knowledge_item_id": file.ID}).Debug("juma executor: added file to knowledgeItems")
+	}
 
 	return JumaConversionResult{
-		Messages:       result,
+		Messages:       normalizeJumaSystemMessages(cfg, result, forcedSystemPrompt),
 		KnowledgeItems: knowledgeItems,
 		UploadedImages: uploadedImages,
+		UploadedFiles:  uploadedFiles,
+		UploadMetrics:  uploadMetrics.snapshot(),
+		FailedImages:   failedImages,
+		Err:            conversionErr,
 	}
 }
 
-// fetchImageDataURLFromHTTP downloads a remote image and converts it to a data URL string.
-// A size limit is enforced to avoid excessive memory usage.
-func fetchImageDataURLFromHTTP(url string, maxBytes int64) (string, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+// jumaImageUploadErrorMode returns juma.on-image-upload-error, defaulting to "drop".
+func jumaImageUploadErrorMode(cfg *config.Config) string {
+	if cfg != nil && cfg.Juma.OnImageUploadError != "" {
+		return cfg.Juma.OnImageUploadError
 	}
+	return "drop"
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("fetch image: %w", err)
+// jumaImageUploadErrorNote is the text prepended to a message whose images were dropped.
+func jumaImageUploadErrorNote(failed int) string {
+	if failed == 1 {
+		return "[1 image could not be attached]\n\n"
 	}
-	defer resp.Body.Close()
+	return fmt.Sprintf("[%d images could not be attached]\n\n", failed)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+// trimJumaContext drops the oldest non-system messages so the conversation fits within
+// juma.max-context-messages and juma.max-context-tokens. System messages are always kept,
+// as is everything from the latest user message onward (including the images it
+// references), even when that alone exceeds the limits. Trimming happens before
+// conversion so images on dropped turns are never uploaded.
+func trimJumaContext(cfg *config.Config, msgs []gjson.Result) []gjson.Result {
+	if cfg == nil || (cfg.Juma.MaxContextMessages <= 0 && cfg.Juma.MaxContextTokens <= 0) {
+		return msgs
 	}
 
-	limited := io.LimitReader(resp.Body, maxBytes+1)
-	data, err := io.ReadAll(limited)
-	if err != nil {
-		return "", fmt.Errorf("read image: %w", err)
-	}
-	if int64(len(data)) > maxBytes {
-		return "", fmt.Errorf("image size exceeds limit (%d bytes)", maxBytes)
+	latestUser := -1
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Get("role").String() == "user" {
+			latestUser = i
+			break
+		}
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = http.DetectContentType(data)
+	keep := make([]bool, len(msgs))
+	kept, tokens, dropped := 0, 0, 0
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Get("role").String() == "system" {
+			keep[i] = true
+			tokens += estimateJumaMessageTokens(msgs[i])
+		}
 	}
-	if !strings.HasPrefix(contentType, "image/") {
-		return "", fmt.Errorf("content-type is not image: %s", contentType)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		cost := estimateJumaMessageTokens(msgs[i])
+		mandatory := latestUser >= 0 && i >= latestUser
+		overCount := cfg.Juma.MaxContextMessages > 0 && kept >= cfg.Juma.MaxContextMessages
+		overTokens := cfg.Juma.MaxContextTokens > 0 && tokens+cost > cfg.Juma.MaxContextTokens
+		if !mandatory && (overCount || overTokens) {
+			// Stop at the first turn that does not fit so the kept history stays contiguous.
+			break
+		}
+		keep[i] = true
+		kept++
+		tokens += cost
+	}
+	for _, k := range keep {
+		if !k {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return msgs
 	}
 
-	encoded := base64.StdEncoding.EncodeToString(data)
-	return fmt.Sprintf("data:%s;base64,%s", contentType, encoded), nil
-}
-
-func (e *JumaExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (resp cliproxyexecutor.Response, err error) {
-	reporter := newUsageReporter(ctx, e.Identifier(), req.Model, auth)
-	defer reporter.trackFailure(ctx, &err)
-
-	sessionToken, workspaceID, vendorConnectionID := jumaCredentials(auth)
-	if sessionToken == "" {
-		err = statusErr{code: http.StatusUnauthorized, msg: "missing Juma session token"}
-		return
+	trimmed := make([]gjson.Result, 0, len(msgs))
+	for i, msg := range msgs {
+		if keep[i] {
+			trimmed = append(trimmed, msg)
+		}
 	}
+	jumaLogEntry(log.Fields{
+		"original_messages": len(msgs),
+		"dropped_messages":  dropped,
+		"estimated_tokens":  tokens,
+	}).Info("juma executor: trimmed conversation context")
+	return trimmed
+}
 
-	// Find model by alias
-	model := getJumaModelByAlias(req.Model)
-	if model == nil {
-		err = statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("unknown Juma model: %s", req.Model)}
-		return
+// estimateJumaMessageTokens roughly estimates a message's token count at four characters
+// per token. Only text is counted; image and file payloads are ignored.
+func estimateJumaMessageTokens(msg gjson.Result) int {
+	chars := 0
+	content := msg.Get("content")
+	if content.IsArray() {
+		for _, part := range content.Array() {
+			if part.Get("type").String() == "text" {
+				chars += len(part.Get("text").String())
+			}
+		}
+	} else {
+		chars = len(content.String())
 	}
+	// A few tokens of per-message overhead for role and framing.
+	return chars/4 + 4
+}
 
-	// Use model's vendor connection ID if not specified in config
-	if vendorConnectionID == "" {
-		vendorConnectionID = model.VendorConnectionID
+// estimateJumaPromptTokens estimates the prompt size of converted Juma messages with the
+// same heuristic as estimateJumaMessageTokens. Juma reports no token usage, so usage
+// records are built from these estimates and flagged as such.
+func estimateJumaPromptTokens(msgs []JumaMessage) int64 {
+	var tokens int64
+	for _, msg := range msgs {
+		chars := 0
+		for _, part := range msg.Parts {
+			chars += len(part.Text)
+		}
+		if chars == 0 {
+			chars = len(msg.Content)
+		}
+		tokens += int64(chars/4 + 4)
 	}
+	return tokens
+}
 
-	// Build Juma request
-	conversionResult := convertToJumaMessages(e.cfg, req.Payload, sessionToken, workspaceID)
+// estimateJumaTextTokens estimates the token count of generated text at four characters
+// per token.
+func estimateJumaTextTokens(text string) int64 {
+	return int64((len(text) + 3) / 4)
+}
 
-	// Convert knowledge items to []any for JSON serialization
-	knowledgeItems := make([]any, len(conversionResult.KnowledgeItems))
-	for i, item := range conversionResult.KnowledgeItems {
-		knowledgeItems[i] = item
+// normalizeJumaSystemMessages makes system prompts take effect on Juma. Juma's chat
+// backend only applies a single leading system message, so scattered or repeated
+// OpenAI system messages are merged into one at the front. With
+// juma.system-prompt-mode "prepend" the merged text is instead prepended to the first
+// user message, for workspaces that ignore the system role entirely.
+//
+// juma.system-prompt-prefix and juma.system-prompt-suffix wrap the merged text, creating
+// a system prompt when the request has none. A model's forced prompt (the image-edit
+// instructions for Nanobanana) stays ahead of the prefix so it keeps opening the prompt.
+func normalizeJumaSystemMessages(cfg *config.Config, messages []JumaMessage, forcedSystemPrompt string) []JumaMessage {
+	var systemTexts []string
+	rest := make([]JumaMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role != "system" {
+			rest = append(rest, msg)
+			continue
+		}
+		if text := strings.TrimSpace(msg.Content); text != "" {
+			systemTexts = append(systemTexts, text)
+		}
 	}
-
-	jumaReq := JumaRequest{
-		Messages:           conversionResult.Messages,
-		ModelID:            model.ID,
-		ThreadID:           uuid.New().String(),
-		WorkspaceID:        workspaceID,
-		VendorConnectionID: vendorConnectionID,
-		IsNewThread:        true,
-		PromptUsages:       []any{},
-		KnowledgeItems:     knowledgeItems,
+	if cfg != nil {
+		var head []string
+		if forced := strings.TrimSpace(forcedSystemPrompt); forced != "" && len(systemTexts) > 0 && systemTexts[0] == forced {
+			head, systemTexts = []string{forced}, systemTexts[1:]
+		}
+		if prefix := strings.TrimSpace(cfg.Juma.SystemPromptPrefix); prefix != "" {
+			systemTexts = append([]string{prefix}, systemTexts...)
+		}
+		if suffix := strings.TrimSpace(cfg.Juma.SystemPromptSuffix); suffix != "" {
+			systemTexts = append(systemTexts, suffix)
+		}
+		systemTexts = append(head, systemTexts...)
 	}
+	if len(systemTexts) == 0 {
+		return rest
+	}
+	systemText := strings.Join(systemTexts, "\n\n")
 
-	// Add ImageEdit tool for Nanobanana model
-	if isNanobananaModel(req.Model) {
-		jumaReq.Tools = []JumaTool{
-			{
-				Type: "function",
-				Function: JumaToolFunction{
-					Name:        "ImageEdit",
-					Description: "Edit or generate images based on text prompts. Use this tool when the user asks to generate, edit, or modify images.",
-					Parameters: map[string]any{
-						"type": "object",
-						"properties": map[string]any{
-							"prompt": map[string]any{
-								"type":        "string",
-								"description": "The prompt describing the image to generate or the edit to make",
-							},
-							"imageUrls": map[string]any{
-								"type":        "array",
-								"items":       map[string]any{"type": "string"},
-								"description": "URLs of images to edit (optional for generation)",
-							},
-							"orientation": map[string]any{
-								"type":        "string",
-								"enum":        []string{"vertical", "horizontal", "square"},
-								"description": "The orientation of the output image",
-							},
-						},
-						"required": []string{"prompt"},
-					},
-				},
-			},
+	if cfg != nil && strings.EqualFold(strings.TrimSpace(cfg.Juma.SystemPromptMode), "prepend") {
+		for i := range rest {
+			if rest[i].Role != "user" {
+				continue
+			}
+			rest[i].Content = systemText + "\n\n" + rest[i].Content
+			if len(rest[i].Parts) > 0 && rest[i].Parts[0].Type == "text" {
+				rest[i].Parts[0].Text = systemText + "\n\n" + rest[i].Parts[0].Text
+			} else {
+				rest[i].Parts = append([]JumaMessagePart{{Type: "text", Text: systemText}}, rest[i].Parts...)
+			}
+			return rest
 		}
 	}
 
-	reqBody, err := json.Marshal(jumaReq)
-	if err != nil {
-		return resp, err
+	system := JumaMessage{
+		ID:              uuid.New().String(),
+		Role:            "system",
+		Content:         systemText,
+		Parts:           []JumaMessagePart{{Type: "text", Text: systemText}},
+		GeneratedImages: []any{},
+		UploadedImages:  []any{},
+		UploadedFiles:   []any{},
 	}
+	return append([]JumaMessage{system}, rest...)
+}
 
-	// Debug: log the request body to see what we're sending to Juma
-	log.Infof("juma executor: sending request to Juma, knowledgeItems=%d, messages=%d", len(knowledgeItems), len(conversionResult.Messages))
-	if len(conversionResult.Messages) > 0 {
-		lastMsg := conversionResult.Messages[len(conversionResult.Messages)-1]
-		log.Infof("juma executor: last message parts=%d, uploadedImages=%d", len(lastMsg.Parts), len(lastMsg.UploadedImages))
-		for i, part := range lastMsg.Parts {
-			log.Infof("juma executor: part[%d] type=%s, imageUrl=%s, imageId=%s", i, part.Type, part.ImageURL, part.ImageID)
+// jumaMarkdownImagePattern matches markdown images such as ![Generated Image](url).
+var jumaMarkdownImagePattern = regexp.MustCompile(`!\[[^\]]*\]\(([^)\s]+)\)`)
+
+// extractJumaMarkdownImageURLs returns the image URLs referenced by markdown (or raw
+// <generated-image> tags) in text, which is how generated images are rendered in
+// earlier assistant turns.
+func extractJumaMarkdownImageURLs(text string) []string {
+	var urls []string
+	for _, match := range jumaMarkdownImagePattern.FindAllStringSubmatch(transformGeneratedImageTags(text), -1) {
+		if u := match[1]; strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "data:") {
+			urls = append(urls, u)
 		}
 	}
+	return urls
+}
 
-	url := jumaBaseURL + "/api/chat/stream"
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
-	if err != nil {
-		return resp, err
+// buildJumaGeneratedImages maps image references from a prior assistant turn into
+// Juma's generatedImages entries. Remote URLs are referenced directly; inline data URLs
+// are uploaded first so Juma can resolve them.
+func buildJumaGeneratedImages(cfg *config.Config, sessionToken, workspaceID string, sources []string, metrics *jumaUploadMetrics) []any {
+	images := make([]any, 0, len(sources))
+	seen := make(map[string]struct{}, len(sources))
+	var dataURLs []string
+	for _, source := range sources {
+		if _, dup := seen[source]; dup {
+			continue
+		}
+		seen[source] = struct{}{}
+		if strings.HasPrefix(source, "data:") {
+			if !jumaUploadImagesEnabled(cfg) {
+				jumaLogEntry(nil).Warn("juma executor: image uploads disabled, omitting inline generated image")
+				continue
+			}
+			dataURLs = append(dataURLs, source)
+			continue
+		}
+		images = append(images, map[string]any{"imageUrl": source})
+	}
+	for _, img := range uploadJumaImages(cfg, sessionToken, workspaceID, dataURLs, metrics) {
+		images = append(images, map[string]any{
+			"id":       img.ID,
+			"imageUrl": img.ImageURL,
+			"name":     img.Name,
+		})
 	}
+	if len(images) > 0 {
+		jumaLogEntry(log.Fields{"generated_images": len(images)}).Debug("juma executor: restored generated images from assistant turn")
+	}
+	return images
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "*/*")
-	httpReq.Header.Set("Origin", jumaBaseURL)
-	httpReq.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	httpReq.AddCookie(&http.Cookie{
-		Name:  "__Secure-next-auth.session-token",
-		Value: sessionToken,
-	})
+// lowDetailJumaImage honors OpenAI's image_url detail "low" by downscaling the image
+// before upload. Remote images are fetched first so they can be resized; on any failure
+// the original source is kept.
+func lowDetailJumaImage(source string) string {
+	dataURL := source
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		fetched, err := fetchImageDataURLFromHTTP(source, jumaMaxRemoteImageBytes)
+		if err != nil {
+			jumaLogEntry(nil).WithError(err).Debug("juma executor: cannot fetch low-detail image for downscaling")
+			return source
+		}
+		dataURL = fetched
+	}
+	if !strings.HasPrefix(dataURL, "data:") {
+		return source
+	}
+	scaled := downscaleJumaImageDataURL(dataURL, jumaLowDetailMaxSide)
+	if scaled == dataURL {
+		// Nothing to shrink; keep the original source.
+		return source
+	}
+	return scaled
+}
 
-	var authID, authLabel, authType, authValue string
-	if auth != nil {
-		authID = auth.ID
-		authLabel = auth.Label
-		authType, authValue = auth.AccountInfo()
+// rehostJumaImages rehosts generated image URLs on the configured image host using a
+// pool bounded by juma.upload-concurrency. Order is preserved, and any image that
+// fails to rehost keeps its original Juma URL.
+func rehostJumaImages(cfg *config.Config, imageURLs []string) []string {
+	if len(imageURLs) == 0 || cfg == nil || !cfg.ImageHosting.Enable || cfg.ImageHosting.Endpoint == "" {
+		return imageURLs
 	}
-	recordAPIRequest(ctx, e.cfg, upstreamRequestLog{
-		URL:       url,
-		Method:    http.MethodPost,
-		Headers:   httpReq.Header.Clone(),
-		Body:      reqBody,
-		Provider:  e.Identifier(),
-		AuthID:    authID,
-		AuthLabel: authLabel,
-		AuthType:  authType,
-		AuthValue: authValue,
-	})
+	rehosted := make([]string, len(imageURLs))
+	sem := make(chan struct{}, jumaUploadConcurrency(cfg))
+	var wg sync.WaitGroup
+	for i, imageURL := range imageURLs {
+		wg.Add(1)
+		go func(i int, imageURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			publicURL, err := UploadRemoteImage(cfg, imageURL)
+			if err != nil || publicURL == "" {
+				jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to rehost generated image, keeping original URL")
+				publicURL = imageURL
+			}
+			rehosted[i] = publicURL
+		}(i, imageURL)
+	}
+	wg.Wait()
+	return rehosted
+}
 
-	httpClient := newProxyAwareHTTPClient(ctx, e.cfg, auth, 0)
-	httpResp, err := httpClient.Do(httpReq)
-	if err != nil {
-		recordAPIResponseError(ctx, e.cfg, err)
-		return resp, err
+// uploadJumaImages uploads the given image sources (data URLs or http(s) URLs) to Juma
+// using a bounded worker pool. Successful uploads are returned in source order; failures
+// are logged and skipped so a single bad image does not drop the whole message.
+func uploadJumaImages(cfg *config.Config, sessionToken, workspaceID string, sources []string, metrics *jumaUploadMetrics) []JumaUploadedImage {
+	if len(sources) == 0 {
+		return nil
 	}
-	defer func() {
-		if errClose := httpResp.Body.Close(); errClose != nil {
-			log.Errorf("juma executor: close response body error: %v", errClose)
+	if metrics != nil && metrics.dryRun {
+		images := make([]JumaUploadedImage, len(sources))
+		for i := range images {
+			images[i] = JumaUploadedImage{ID: jumaDryRunPlaceholderID, ImageURL: jumaDryRunPlaceholderURL, KnowledgeItemID: jumaDryRunPlaceholderID}
 		}
-	}()
-	recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
-
-	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
-		b, _ := io.ReadAll(httpResp.Body)
-		appendAPIResponseChunk(ctx, e.cfg, b)
-		log.Errorf("juma executor: request error, status: %d, body: %s", httpResp.StatusCode, string(b))
-		err = statusErr{code: httpResp.StatusCode, msg: string(b)}
-		return resp, err
+		return images
+	}
+	if sessionToken == "" || workspaceID == "" {
+		jumaLogEntry(nil).Warn("juma executor: missing session token or workspace ID for image upload")
+		return nil
 	}
 
-	// For non-streaming, read all SSE data and extract the final content
-	var fullContent strings.Builder
-	var generatedImageURL string
-	scanner := bufio.NewScanner(httpResp.Body)
-	scanner.Buffer(nil, 20_971_520)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		appendAPIResponseChunk(ctx, e.cfg, []byte(line))
+	if cfg != nil && cfg.Juma.BatchPresign && len(sources) > 1 {
+		images, dataURLs, ok := uploadJumaImagesBatch(cfg, sessionToken, workspaceID, sources, metrics)
+		if ok {
+			return images
+		}
+		// Reuse the downloads; sources that already failed are left empty and skipped.
+		sources = dataURLs
+	}
 
-		if !strings.HasPrefix(line, "data: ") {
+	results := make([]*JumaUploadedImage, len(sources))
+	sem := make(chan struct{}, jumaUploadConcurrency(cfg))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		if source == "" {
 			continue
 		}
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			dataURL := source
+			if !strings.HasPrefix(source, "data:") {
+				fetched, err := fetchImageDataURLFromHTTP(source, jumaMaxRemoteImageBytes)
+				if err != nil {
+					jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to fetch remote image for upload")
+					metrics.record(jumaUploadTiming{}, err)
+					return
+				}
+				dataURL = fetched
+			}
 
-		// Parse events
-		eventType := gjson.Get(data, "type").String()
-		if eventType == "text-delta" {
-			delta := gjson.Get(data, "delta").String()
-			fullContent.WriteString(delta)
-		} else if eventType == "tool-output-available" {
-			// Extract generated image URL from tool output
-			// Juma uses "ImageGeneration" or "ImageEdit" tools with output.imageUrl
-			imageURL := gjson.Get(data, "output.imageUrl").String()
-			if imageURL != "" {
-				generatedImageURL = imageURL
+			uploadResult, err := uploadDataURLToJuma(cfg, sessionToken, workspaceID, dataURL, "", metrics)
+			if err != nil {
+				jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to upload image to Juma")
+				return
 			}
-		}
+			results[i] = finishJumaImageUpload(cfg, sessionToken, workspaceID, dataURL, uploadResult, metrics)
+		}(i, source)
 	}
+	wg.Wait()
 
-	// If we have an image but no text, or just to append the image
-	if generatedImageURL != "" {
-		// Append image markdown to content so it appears in Chat Completion
-		if fullContent.Len() > 0 {
-			fullContent.WriteString("\n\n")
+	return collectJumaUploadedImages(results)
+}
+
+// uploadJumaImagesBatch downloads and decodes every source, presigns them with one batch
+// call and then stores them in parallel. It reports ok=false, before anything is stored,
+// when fewer than two images remain or the batch call fails; dataURLs then holds the
+// downloaded sources, empty where the download or decode already failed.
+func uploadJumaImagesBatch(cfg *config.Config, sessionToken, workspaceID string, sources []string, metrics *jumaUploadMetrics) (images []JumaUploadedImage, dataURLs []string, ok bool) {
+	dataURLs = make([]string, len(sources))
+	pending := make([]*jumaPendingUpload, len(sources))
+	sem := make(chan struct{}, jumaUploadConcurrency(cfg))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			dataURL := source
+			if !strings.HasPrefix(source, "data:") {
+				fetched, err := fetchImageDataURLFromHTTP(source, jumaMaxRemoteImageBytes)
+				if err != nil {
+					jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to fetch remote image for upload")
+					metrics.record(jumaUploadTiming{}, err)
+					return
+				}
+				dataURL = fetched
+			}
+			upload, err := prepareJumaUpload(cfg, dataURL, "")
+			if err != nil {
+				jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to upload image to Juma")
+				metrics.record(jumaUploadTiming{}, err)
+				return
+			}
+			dataURLs[i], pending[i] = dataURL, upload
+		}(i, source)
+	}
+	wg.Wait()
+
+	var indexes []int
+	var batch []*jumaPendingUpload
+	for i, upload := range pending {
+		if upload != nil {
+			indexes = append(indexes, i)
+			batch = append(batch, upload)
 		}
-		fullContent.WriteString(fmt.Sprintf("![Generated Image](%s)", generatedImageURL))
 	}
-
-	if errScan := scanner.Err(); errScan != nil {
-		recordAPIResponseError(ctx, e.cfg, errScan)
-		return resp, errScan
+	if len(batch) < 2 {
+		return nil, dataURLs, false
 	}
 
-	reporter.ensurePublished(ctx)
+	for _, upload := range batch {
+		metrics.reportProgress(JumaUploadProgress{Filename: upload.filename, Phase: JumaUploadPhasePresign})
+	}
+	presignStart := time.Now()
+	presigned, err := getJumaPresignedURLs(cfg, sessionToken, workspaceID, batch)
+	presignLatency := time.Since(presignStart)
+	if err != nil {
+		jumaLogEntry(log.Fields{"images": len(batch)}).WithError(err).Warn("juma executor: batch presign failed, presigning images individually")
+		return nil, dataURLs, false
+	}
 
-	// Check if this is an image model and we have generated image URL
-	if isNanobananaModel(req.Model) && generatedImageURL != "" {
-		openAIResp := buildOpenAIImageResponse(generatedImageURL)
-		resp = cliproxyexecutor.Response{Payload: openAIResp}
-		return resp, nil
+	results := make([]*JumaUploadedImage, len(sources))
+	for j, i := range indexes {
+		wg.Add(1)
+		go func(i int, upload *jumaPendingUpload, presignedData *jumaPresignedData) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			timing := jumaUploadTiming{presign: presignLatency}
+			startedAt := time.Now()
+			uploadResult, err := completeJumaUpload(cfg, upload, presignedData, &timing, metrics)
+			timing.total = presignLatency + time.Since(startedAt)
+			metrics.record(timing, err)
+			if err != nil {
+				jumaLogEntry(nil).WithError(err).Warn("juma executor: failed to upload image to Juma")
+				return
+			}
+			results[i] = finishJumaImageUpload(cfg, sessionToken, workspaceID, dataURLs[i], uploadResult, metrics)
+		}(i, batch[j], presigned[j])
 	}
+	wg.Wait()
 
-	// Build OpenAI-style response
-	openAIResp := buildOpenAIChatResponse(req.Model, fullContent.String())
-	resp = cliproxyexecutor.Response{Payload: openAIResp}
-	return resp, nil
+	return collectJumaUploadedImages(results), dataURLs, true
 }
 
-func (e *JumaExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (stream <-chan cliproxyexecutor.StreamChunk, err error) {
-	reporter := newUsageReporter(ctx, e.Identifier(), req.Model, auth)
-	defer reporter.trackFailure(ctx, &err)
-
-	sessionToken, workspaceID, vendorConnectionID := jumaCredentials(auth)
-	if sessionToken == "" {
-		err = statusErr{code: http.StatusUnauthorized, msg: "missing Juma session token"}
-		return nil, err
+// finishJumaImageUpload validates an upload result and resolves its knowledge item,
+// returning nil when Juma did not report a usable image. An upload without a knowledge
+// item is rerun per juma.knowledge-item-retries before the fallback strategy applies.
+func finishJumaImageUpload(cfg *config.Config, sessionToken, workspaceID, dataURL string, uploadResult *JumaImageUploadResult, metrics *jumaUploadMetrics) *JumaUploadedImage {
+	jumaLogEntry(log.Fields{"image_id": uploadResult.ID, "knowledge_item_id": uploadResult.KnowledgeItemID}).Info("juma executor: uploaded image to Juma")
+	if uploadResult.ID == "" || uploadResult.ImageURL == "" {
+		jumaLogEntry(nil).Warn("juma executor: no valid image ID or URL returned")
+		return nil
+	}
+	if uploadResult.KnowledgeItemID == "" {
+		if retried, _ := retryJumaKnowledgeItemUpload(cfg, sessionToken, workspaceID, dataURL, uploadResult, metrics); retried != nil {
+			uploadResult = retried
+		} else {
+			uploadResult = applyJumaKnowledgeItemFallback(cfg, uploadResult)
+		}
 	}
+	return &JumaUploadedImage{
+		ID:              uploadResult.ID,
+		ImageURL:        uploadResult.ImageURL,
+		Name:            uploadResult.Name,
+		KnowledgeItemID: uploadResult.KnowledgeItemID,
+		Width:           uploadResult.Width,
+		Height:          uploadResult.Height,
+		Size:            uploadResult.Size,
+	}
+}
 
-	// Find model by alias
-	model := getJumaModelByAlias(req.Model)
-	if model == nil {
-		err = statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("unknown Juma model: %s", req.Model)}
-		return nil, err
+// collectJumaUploadedImages drops failed uploads while keeping source order.
+func collectJumaUploadedImages(results []*JumaUploadedImage) []JumaUploadedImage {
+	images := make([]JumaUploadedImage, 0, len(results))
+	for _, img := range results {
+		if img != nil {
+			images = append(images, *img)
+		}
 	}
+	return images
+}
 
-	// Use model's vendor connection ID if not specified in config
-	if vendorConnectionID == "" {
-		vendorConnectionID = model.VendorConnectionID
+// jumaRequestTimeout returns the configured overall timeout for Juma chat requests.
+func jumaRequestTimeout(cfg *config.Config) time.Duration {
+	if cfg != nil && cfg.Juma.RequestTimeout > 0 {
+		return time.Duration(cfg.Juma.RequestTimeout) * time.Second
 	}
+	return jumaDefaultRequestTimeout
+}
 
-	// Build Juma request
-	conversionResult := convertToJumaMessages(e.cfg, req.Payload, sessionToken, workspaceID)
+// jumaRetryMaxAttempts returns the configured total attempts for retryable Juma failures.
+func jumaRetryMaxAttempts(cfg *config.Config) int {
+	if cfg != nil && cfg.Juma.RetryMaxAttempts > 0 {
+		return cfg.Juma.RetryMaxAttempts
+	}
+	return jumaDefaultRetryMaxAttempts
+}
 
-	// Convert knowledge items to []any for JSON serialization
-	knowledgeItems := make([]any, len(conversionResult.KnowledgeItems))
-	for i, item := range conversionResult.KnowledgeItems {
-		knowledgeItems[i] = item
+// jumaRetryBackoff returns the delay before retry number attempt (starting at 1).
+func jumaRetryBackoff(cfg *config.Config, attempt int) time.Duration {
+	backoff := jumaDefaultRetryBackoff
+	if cfg != nil && cfg.Juma.RetryBackoff > 0 {
+		backoff = time.Duration(cfg.Juma.RetryBackoff) * time.Millisecond
+	}
+	for i := 1; i < attempt && backoff < jumaMaxRetryBackoff; i++ {
+		backoff *= 2
 	}
+	if backoff > jumaMaxRetryBackoff {
+		backoff = jumaMaxRetryBackoff
+	}
+	return backoff
+}
 
-	jumaReq := JumaRequest{
-		Messages:           conversionResult.Messages,
-		ModelID:            model.ID,
-		ThreadID:           uuid.New().String(),
-		WorkspaceID:        workspaceID,
-		VendorConnectionID: vendorConnectionID,
-		IsNewThread:        true,
-		PromptUsages:       []any{},
-		KnowledgeItems:     knowledgeItems,
+// jumaSlotState counts one auth's in-flight Juma requests. freed is closed and replaced
+// whenever a slot is released, waking queued requests to re-check the limit.
+type jumaSlotState struct {
+	inUse int
+	freed chan struct{}
+}
+
+// jumaAuthSlots holds the per-auth slot counters enforcing juma.max-concurrent-requests.
+var jumaAuthSlots = struct {
+	sync.Mutex
+	byAuth map[string]*jumaSlotState
+}{byAuth: make(map[string]*jumaSlotState)}
+
+// tryAcquireJumaSlot takes a slot for authID when fewer than limit are in use. Otherwise
+// it returns the channel that is closed on the next release. The limit is read on every
+// attempt, so a changed juma.max-concurrent-requests also counts in-flight requests.
+func tryAcquireJumaSlot(authID string, limit int) (func(), <-chan struct{}) {
+	jumaAuthSlots.Lock()
+	defer jumaAuthSlots.Unlock()
+	state, ok := jumaAuthSlots.byAuth[authID]
+	if !ok {
+		state = &jumaSlotState{freed: make(chan struct{})}
+		jumaAuthSlots.byAuth[authID] = state
+	}
+	if state.inUse >= limit {
+		return nil, state.freed
 	}
+	state.inUse++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			jumaAuthSlots.Lock()
+			state.inUse--
+			close(state.freed)
+			state.freed = make(chan struct{})
+			jumaAuthSlots.Unlock()
+		})
+	}, nil
+}
 
-	// Add ImageEdit tool for Nanobanana model
-	if isNanobananaModel(req.Model) {
-		jumaReq.Tools = []JumaTool{
-			{
-				Type: "function",
-				Function: JumaToolFunction{
-					Name:        "ImageEdit",
-					Description: "Edit or generate images based on text prompts. Use this tool when the user asks to generate, edit, or modify images.",
-					Parameters: map[string]any{
-						"type": "object",
-						"properties": map[string]any{
-							"prompt": map[string]any{
-								"type":        "string",
-								"description": "The prompt describing the image to generate or the edit to make",
-							},
-							"imageUrls": map[string]any{
-								"type":        "array",
-								"items":       map[string]any{"type": "string"},
-								"description": "URLs of images to edit (optional for generation)",
-							},
-							"orientation": map[string]any{
-								"type":        "string",
-								"enum":        []string{"vertical", "horizontal", "square"},
-								"description": "The orientation of the output image",
-							},
-						},
-						"required": []string{"prompt"},
-					},
-				},
-			},
-		}
+// acquireJumaSlot reserves one of the auth's juma.max-concurrent-requests slots, waiting
+// up to juma.queue-timeout for one to free up. The returned release func must be called
+// once the Juma response has been fully consumed. Without a limit it never blocks.
+func acquireJumaSlot(ctx context.Context, cfg *config.Config, auth *cliproxyauth.Auth) (func(), error) {
+	if cfg == nil || cfg.Juma.MaxConcurrentRequests <= 0 || auth == nil {
+		return func() {}, nil
 	}
+	limit := cfg.Juma.MaxConcurrentRequests
 
-	reqBody, err := json.Marshal(jumaReq)
-	if err != nil {
-		return nil, err
+	release, freed := tryAcquireJumaSlot(auth.ID, limit)
+	if release != nil {
+		return release, nil
 	}
 
-	// Debug: log the request body to see what we're sending to Juma
-	log.Infof("juma executor stream: sending request to Juma, knowledgeItems=%d, messages=%d", len(knowledgeItems), len(conversionResult.Messages))
-	if len(conversionResult.Messages) > 0 {
-		lastMsg := conversionResult.Messages[len(conversionResult.Messages)-1]
-		log.Infof("juma executor stream: last message parts=%d, uploadedImages=%d", len(lastMsg.Parts), len(lastMsg.UploadedImages))
-		for i, part := range lastMsg.Parts {
-			log.Infof("juma executor stream: part[%d] type=%s, imageUrl=%s, imageId=%s", i, part.Type, part.ImageURL, part.ImageID)
+	retryAfter := time.Second
+	busy := statusErr{code: http.StatusTooManyRequests, msg: fmt.Sprintf("too many concurrent Juma requests for this credential (limit %d)", limit), retryAfter: &retryAfter}
+	wait := jumaDefaultQueueTimeout
+	if cfg.Juma.QueueTimeout < 0 {
+		return nil, busy
+	} else if cfg.Juma.QueueTimeout > 0 {
+		wait = time.Duration(cfg.Juma.QueueTimeout) * time.Second
+	}
+	jumaLogEntry(log.Fields{"auth_id": auth.ID, "limit": limit}).Debug("juma executor: waiting for a concurrency slot")
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-freed:
+		case <-timer.C:
+			return nil, busy
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if release, freed = tryAcquireJumaSlot(auth.ID, limit); release != nil {
+			return release, nil
 		}
 	}
+}
 
-	url := jumaBaseURL + "/api/chat/stream"
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, err
+// ForgetAuth drops the per-auth state the executor keeps for authID, such as its
+// concurrency slots and discovered workspaces. Call it when the auth is removed.
+// Requests still in flight release into the state they acquired from.
+func (e *JumaExecutor) ForgetAuth(authID string) {
+	jumaAuthSlots.Lock()
+	delete(jumaAuthSlots.byAuth, authID)
+	jumaAuthSlots.Unlock()
+	if e != nil {
+		e.workspaces.forget(authID)
 	}
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "*/*")
-	httpReq.Header.Set("Origin", jumaBaseURL)
-	httpReq.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	httpReq.AddCookie(&http.Cookie{
-		Name:  "__Secure-next-auth.session-token",
-		Value: sessionToken,
-	})
-
-	var authID, authLabel, authType, authValue string
-	if auth != nil {
-		authID = auth.ID
-		authLabel = auth.Label
-		authType, authValue = auth.AccountInfo()
-	}
-	recordAPIRequest(ctx, e.cfg, upstreamRequestLog{
-		URL:       url,
-		Method:    http.MethodPost,
-		Headers:   httpReq.Header.Clone(),
-		Body:      reqBody,
-		Provider:  e.Identifier(),
-		AuthID:    authID,
-		AuthLabel: authLabel,
-		AuthType:  authType,
-		AuthValue: authValue,
-	})
+// isJumaRetryableStatus reports whether a Juma response status is a transient gateway failure.
+func isJumaRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
 
-	httpClient := newProxyAwareHTTPClient(ctx, e.cfg, auth, 0)
-	httpResp, err := httpClient.Do(httpReq)
-	if err != nil {
-		recordAPIResponseError(ctx, e.cfg, err)
-		return nil, err
-	}
-	recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
+// doJumaRequest sends httpReq and re-issues it with reqBody while Juma answers 502, 503
+// or 504, backing off exponentially between attempts. Retries only happen before any
+// response body is consumed by the caller, so streamed output is never duplicated. The
+// last response is returned as-is, whatever its status.
+func doJumaRequest(ctx context.Context, cfg *config.Config, client HTTPDoer, httpReq *http.Request, reqBody []byte, model string, reqLog *log.Entry) (*http.Response, error) {
+	maxAttempts := jumaRetryMaxAttempts(cfg)
+	for attempt := 1; ; attempt++ {
+		attemptReq := httpReq
+		if attempt > 1 {
+			attemptReq = httpReq.Clone(ctx)
+			if httpReq.GetBody != nil {
+				// The request may carry a compressed body; replay exactly what was sent.
+				body, err := httpReq.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			} else {
+				attemptReq.Body = io.NopCloser(bytes.NewReader(reqBody))
+				attemptReq.ContentLength = int64(len(reqBody))
+			}
+		}
+		httpResp, err := client.Do(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		internalusage.IncExecutorCounter(internalusage.MetricExecutorUpstreamStatus, "juma", model, "code", strconv.Itoa(httpResp.StatusCode))
+		if !isJumaRetryableStatus(httpResp.StatusCode) || attempt >= maxAttempts {
+			return httpResp, nil
+		}
 
-	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
-		b, _ := io.ReadAll(httpResp.Body)
-		appendAPIResponseChunk(ctx, e.cfg, b)
-		log.Errorf("juma executor stream: request error, status: %d, body: %s", httpResp.StatusCode, string(b))
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<16))
 		if errClose := httpResp.Body.Close(); errClose != nil {
 			log.Errorf("juma executor: close response body error: %v", errClose)
 		}
-		err = statusErr{code: httpResp.StatusCode, msg: string(b)}
-		return nil, err
+		backoff := jumaRetryBackoff(cfg, attempt)
+		reqLog.WithFields(log.Fields{
+			"status":  httpResp.StatusCode,
+			"attempt": attempt,
+			"backoff": backoff.String(),
+		}).Warnf("juma executor: transient upstream error, retrying: %s", strings.TrimSpace(string(b)))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
 	}
+}
 
-	out := make(chan cliproxyexecutor.StreamChunk)
-	stream = out
-
-	go func() {
-		defer close(out)
-		defer func() {
-			if errClose := httpResp.Body.Close(); errClose != nil {
-				log.Errorf("juma executor: close response body error: %v", errClose)
-			}
-		}()
-
-		scanner := bufio.NewScanner(httpResp.Body)
-		scanner.Buffer(nil, 20_971_520)
-		chunkIndex := 0
+// compressJumaRequestBody gzips body when it exceeds juma.gzip-request-threshold and
+// reports whether it did.
+func compressJumaRequestBody(cfg *config.Config, body []byte) ([]byte, bool, error) {
+	if cfg == nil || cfg.Juma.GzipRequestThreshold <= 0 || len(body) <= cfg.Juma.GzipRequestThreshold {
+		return body, false, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, false, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
 
-		for scanner.Scan() {
-			line := scanner.Text()
-			appendAPIResponseChunk(ctx, e.cfg, []byte(line))
+// jumaIdleTimeout returns the configured idle window for Juma SSE responses.
+func jumaIdleTimeout(cfg *config.Config) time.Duration {
+	if cfg != nil && cfg.Juma.IdleTimeout > 0 {
+		return time.Duration(cfg.Juma.IdleTimeout) * time.Second
+	}
+	return jumaDefaultIdleTimeout
+}
 
-			if !strings.HasPrefix(line, "data: ") {
-				continue
-			}
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				// Stream complete, just break - handler will send [DONE] when channel closes
-				break
-			}
+// jumaKeepAliveInterval returns how often a quiet Juma stream emits a keepalive comment;
+// zero disables keepalives.
+func jumaKeepAliveInterval(cfg *config.Config) time.Duration {
+	if cfg != nil && cfg.Juma.KeepAliveInterval != 0 {
+		if cfg.Juma.KeepAliveInterval < 0 {
+			return 0
+		}
+		return time.Duration(cfg.Juma.KeepAliveInterval) * time.Second
+	}
+	return jumaDefaultKeepAliveInterval
+}
 
-			// Parse Juma events and convert to OpenAI SSE format
-			eventType := gjson.Get(data, "type").String()
-			if eventType == "text-delta" {
-				delta := gjson.Get(data, "delta").String()
-				// Transform Juma's custom image tags to Markdown format
-				transformedDelta := transformGeneratedImageTags(delta)
-				chunk := buildOpenAIStreamChunk(req.Model, transformedDelta, chunkIndex)
-				out <- cliproxyexecutor.StreamChunk{Payload: chunk}
-				chunkIndex++
-			} else if eventType == "tool-output-available" {
-				// Juma uses "ImageGeneration" or "ImageEdit" tools with output.imageUrl
-				imageURL := gjson.Get(data, "output.imageUrl").String()
-				if imageURL != "" {
-					chunk := buildOpenAIStreamChunk(req.Model, fmt.Sprintf("\n\n![Generated Image](%s)", imageURL), chunkIndex)
-					out <- cliproxyexecutor.StreamChunk{Payload: chunk}
-					chunkIndex++
+// startJumaKeepAlive sends cliproxyexecutor.StreamKeepAlive on out whenever nothing has
+// been sent for interval, so load balancers keep a slow generation's connection open.
+// The returned stop function waits for the loop to exit and must run before out is closed.
+func startJumaKeepAlive(ctx context.Context, out chan<- cliproxyexecutor.StreamChunk, interval time.Duration, lastSent *atomic.Int64) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastSent.Load())) < interval {
+					continue
+				}
+				select {
+				case out <- cliproxyexecutor.StreamChunk{Payload: cliproxyexecutor.StreamKeepAlive}:
+					lastSent.Store(time.Now().UnixNano())
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
 				}
 			}
 		}
-
-		if errScan := scanner.Err(); errScan != nil {
-			recordAPIResponseError(ctx, e.cfg, errScan)
-			reporter.publishFailure(ctx)
-			out <- cliproxyexecutor.StreamChunk{Err: errScan}
-		}
-		reporter.ensurePublished(ctx)
 	}()
-
-	return stream, nil
+	return func() {
+		close(done)
+		<-exited
+	}
 }
 
-func (e *JumaExecutor) CountTokens(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
-	// Juma doesn't provide a token counting API, so we estimate
-	return cliproxyexecutor.Response{}, fmt.Errorf("juma executor: token counting not supported")
+// jumaIdleWatchdog closes an upstream body when no line has been received within the
+// idle window, unblocking a scanner stuck on a half-dead connection.
+type jumaIdleWatchdog struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
 }
 
-// Refresh is a no-op for session token based authentication.
-func (e *JumaExecutor) Refresh(ctx context.Context, auth *cliproxyauth.Auth) (*cliproxyauth.Auth, error) {
-	log.Debugf("juma executor: refresh called (no-op)")
-	return auth, nil
+// newJumaIdleWatchdog starts a watchdog that closes body after timeout of inactivity.
+func newJumaIdleWatchdog(body io.Closer, timeout time.Duration) *jumaIdleWatchdog {
+	w := &jumaIdleWatchdog{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.fired.Store(true)
+		_ = body.Close()
+	})
+	return w
 }
 
-// buildOpenAIChatResponse builds an OpenAI-compatible chat completion response.
-func buildOpenAIChatResponse(model, content string) []byte {
-	// Transform Juma's custom image tags to Markdown format
-	transformedContent := transformGeneratedImageTags(content)
+// Reset restarts the idle window after a line has been received.
+func (w *jumaIdleWatchdog) Reset() { w.timer.Reset(w.timeout) }
 
-	resp := map[string]any{
+// Stop disarms the watchdog.
+func (w *jumaIdleWatchdog) Stop() { w.timer.Stop() }
+
+// Err replaces the scanner error with a timeout error when the watchdog fired.
+func (w *jumaIdleWatchdog) Err(scanErr error) error {
+	if w.fired.Load() {
+		return statusErr{code: http.StatusGatewayTimeout, msg: fmt.Sprintf("juma upstream sent no data for %s", w.timeout)}
+	}
+	return scanErr
+}
+
+// jumaUploadImagesEnabled reports whether request images go through Juma's file storage.
+func jumaUploadImagesEnabled(cfg *config.Config) bool {
+	return cfg == nil || cfg.Juma.UploadImages == nil || *cfg.Juma.UploadImages
+}
+
+// jumaKnowledgeItemSource returns the configured knowledge item source tag.
+func jumaKnowledgeItemSource(cfg *config.Config) string {
+	if cfg != nil {
+		if source := strings.TrimSpace(cfg.Juma.KnowledgeItemSource); source != "" {
+			return source
+		}
+	}
+	return jumaDefaultKnowledgeItemSource
+}
+
+// jumaMaxSSELineBytes returns the configured maximum size of a single SSE line.
+func jumaMaxSSELineBytes(cfg *config.Config) int {
+	if cfg != nil && cfg.Juma.MaxSSELineBytes > 0 {
+		return cfg.Juma.MaxSSELineBytes
+	}
+	return jumaDefaultMaxSSELineBytes
+}
+
+// jumaLineReader reads newline-delimited SSE lines with a buffer that grows on demand,
+// so large base64 payloads only fail once they exceed maxBytes. Its Scan/Text/Err
+// methods mirror bufio.Scanner.
+type jumaLineReader struct {
+	reader   *bufio.Reader
+	maxBytes int
+	line     []byte
+	err      error
+}
+
+// newJumaLineReader wraps r for line-by-line SSE reading.
+func newJumaLineReader(r io.Reader, maxBytes int) *jumaLineReader {
+	return &jumaLineReader{reader: bufio.NewReaderSize(r, 64*1024), maxBytes: maxBytes}
+}
+
+// Scan advances to the next line, returning false at EOF or on error.
+func (l *jumaLineReader) Scan() bool {
+	if l.err != nil {
+		return false
+	}
+	l.line = l.line[:0]
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		l.line = append(l.line, chunk...)
+		if len(l.line) > l.maxBytes {
+			l.err = fmt.Errorf("juma SSE line exceeds %d bytes (juma.max-sse-line-bytes)", l.maxBytes)
+			return false
+		}
+		switch {
+		case err == nil:
+			l.line = bytes.TrimRight(l.line, "\r\n")
+			return true
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF):
+			l.err = io.EOF
+			if len(l.line) == 0 {
+				return false
+			}
+			l.line = bytes.TrimRight(l.line, "\r\n")
+			return true
+		default:
+			l.err = err
+			return false
+		}
+	}
+}
+
+// Text returns the most recent line without its line terminator.
+func (l *jumaLineReader) Text() string { return string(l.line) }
+
+// Err returns the first non-EOF error encountered.
+func (l *jumaLineReader) Err() error {
+	if errors.Is(l.err, io.EOF) {
+		return nil
+	}
+	return l.err
+}
+
+// jumaUploadConcurrency returns the maximum number of parallel image uploads per message.
+func jumaUploadConcurrency(cfg *config.Config) int {
+	if cfg != nil && cfg.Juma.UploadConcurrency > 0 {
+		return cfg.Juma.UploadConcurrency
+	}
+	return jumaDefaultUploadConcurrency
+}
+
+// fetchImageDataURLFromHTTP downloads a remote image and converts it to a data URL string.
+// A size limit is enforced to avoid excessive memory usage.
+func fetchImageDataURLFromHTTP(url string, maxBytes int64) (string, error) {
+	data, contentType, err := fetchRemoteImage(context.Background(), newJumaUploadClient(0), url, maxBytes)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return fmt.Sprintf("data:%s;base64,%s", contentType, encoded), nil
+}
+
+// fetchRemoteImage downloads an image with the given client, enforcing maxBytes and
+// an image/* content type. It returns the raw bytes and the detected content type.
+func fetchRemoteImage(ctx context.Context, client HTTPDoer, url string, maxBytes int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	limited := io.LimitReader(resp.Body, maxBytes+1)
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, "", fmt.Errorf("read image: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("image size exceeds limit (%d bytes)", maxBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("content-type is not image: %s", contentType)
+	}
+	return data, contentType, nil
+}
+
+// buildJumaHTTPRequest performs the request preparation shared by Execute and
+// ExecuteStream: credential and model resolution, message conversion (including
+// uploads), request marshaling, header setup and upstream request logging.
+// For non-streaming dry runs it returns a nil request and the redacted request body.
+func (e *JumaExecutor) buildJumaHTTPRequest(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, stream bool, reporter *usageReporter) (*http.Request, []byte, *JumaModel, *log.Entry, error) {
+	logPrefix := "juma executor"
+	if stream {
+		logPrefix = "juma executor stream"
+	}
+
+	mode := "non_stream"
+	if stream {
+		mode = "stream"
+	}
+	internalusage.IncExecutorCounter(internalusage.MetricExecutorRequests, "juma", req.Model, "mode", mode)
+
+	sessionToken, workspaceID, vendorConnectionID := jumaCredentials(auth)
+	if sessionToken == "" {
+		return nil, nil, nil, nil, statusErr{code: http.StatusUnauthorized, msg: "missing Juma session token"}
+	}
+
+	// Find model by alias
+	model := getJumaModelByAlias(req.Model)
+	if model == nil {
+		return nil, nil, nil, nil, statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("unknown Juma model: %s", req.Model)}
+	}
+	if !JumaModelAdvertised(e.cfg, req.Model) {
+		return nil, nil, nil, nil, statusErr{code: http.StatusNotFound, msg: fmt.Sprintf("Juma model %s is not offered by this deployment", req.Model)}
+	}
+	if !jumaModelAllowed(auth, req.Model) {
+		return nil, nil, nil, nil, statusErr{code: http.StatusForbidden, msg: fmt.Sprintf("Juma model %s is not allowed for this credential", req.Model)}
+	}
+
+	model, backendConnectionID := selectJumaImageBackend(ctx, e.cfg, model, req.Payload)
+
+	// Use model's vendor connection ID if not specified in config
+	if vendorConnectionID == "" {
+		vendorConnectionID = model.VendorConnectionID
+	}
+	if backendConnectionID != "" {
+		vendorConnectionID = backendConnectionID
+	}
+	if override := jumaVendorConnectionOverride(ctx, auth, req.Model); override != "" {
+		vendorConnectionID = override
+	}
+	if err := validateJumaIDs(workspaceID, vendorConnectionID); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	// A dry run stops short of anything that calls Juma or uses quota: no workspace
+	// discovery, no uploads, no moderation and no thread cache update.
+	dryRun := !stream && jumaDryRunRequested(ctx, e.cfg)
+	var err error
+	if !dryRun {
+		workspaceID, err = e.resolveWorkspaceID(ctx, auth, sessionToken, workspaceID)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	model = applyJumaImageEditPrompt(e.cfg, model)
+	model, err = applyJumaImageOrientation(ctx, model, req.Payload)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// Build Juma request
+	conversionResult := convertToJumaMessages(e.cfg, req.Payload, model, sessionToken, workspaceID, jumaUploadProgressFrom(ctx), dryRun)
+	reporter.setUploads(conversionResult.UploadMetrics)
+	setJumaUploadedImagesHeader(ctx, e.cfg, conversionResult.UploadedImages)
+	reporter.setEstimatedInput(estimateJumaPromptTokens(conversionResult.Messages))
+	internalusage.GetExecutorMetrics().Add(internalusage.MetricExecutorUploadFailures, conversionResult.UploadMetrics.Failed, "juma", req.Model)
+	if conversionResult.Err != nil {
+		return nil, nil, nil, nil, conversionResult.Err
+	}
+	if conversionResult.FailedImages > 0 && jumaImageUploadErrorMode(e.cfg) == "fail" {
+		return nil, nil, nil, nil, statusErr{code: http.StatusBadGateway, msg: fmt.Sprintf("%d image(s) could not be uploaded to Juma", conversionResult.FailedImages)}
+	}
+	if !dryRun {
+		if err = e.moderateJumaRequest(ctx, JumaModerationInput{Model: req.Model, Messages: conversionResult.Messages, UploadedImages: conversionResult.UploadedImages}); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	// Convert knowledge items to []any for JSON serialization
+	knowledgeItems := make([]any, len(conversionResult.KnowledgeItems))
+	for i, item := range conversionResult.KnowledgeItems {
+		knowledgeItems[i] = item
+	}
+
+	jumaReq := JumaRequest{
+		Messages:           conversionResult.Messages,
+		ModelID:            model.ID,
+		ThreadID:           uuid.New().String(),
+		WorkspaceID:        workspaceID,
+		VendorConnectionID: vendorConnectionID,
+		IsNewThread:        true,
+		PromptUsages:       []any{},
+		KnowledgeItems:     knowledgeItems,
+	}
+
+	// Attach model-specific tools (e.g. ImageEdit for image models)
+	if len(model.Tools) > 0 {
+		jumaReq.Tools = model.Tools
+	}
+	applyJumaGenerationParams(&jumaReq, model, req.Payload)
+	if err = applyJumaThreadContinuation(ctx, auth, &jumaReq, req.Payload); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	setJumaThreadResponseHeader(ctx, jumaReq.ThreadID)
+
+	reqBody, err := json.Marshal(jumaReq)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if dryRun {
+		jumaLogEntry(log.Fields{"model": req.Model}).Info("juma executor: dry run, returning converted request")
+		return nil, redactJumaDryRunPayload(reqBody), model, nil, nil
+	}
+
+	reqLog := jumaLogEntry(log.Fields{
+		"model":           req.Model,
+		"stream":          stream,
+		"message_count":   len(conversionResult.Messages),
+		"knowledge_items": len(knowledgeItems),
+		"uploaded_images": len(conversionResult.UploadedImages),
+	})
+	reqLog.Info(logPrefix + ": sending request to Juma")
+	if len(conversionResult.Messages) > 0 {
+		lastMsg := conversionResult.Messages[len(conversionResult.Messages)-1]
+		for i, part := range lastMsg.Parts {
+			reqLog.WithFields(log.Fields{"part_index": i, "part_type": part.Type}).Debug(logPrefix + ": last message part")
+		}
+	}
+
+	wireBody, compressed, err := compressJumaRequestBody(e.cfg, reqBody)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if compressed {
+		reqLog.WithFields(log.Fields{"body_bytes": len(reqBody), "gzip_bytes": len(wireBody)}).Debug(logPrefix + ": compressed request body")
+	}
+	httpReq, err := newJumaClient(e.cfg, nil, sessionToken, workspaceID).NewChatStreamRequest(ctx, wireBody, compressed)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	url := httpReq.URL.String()
+
+	var authID, authLabel, authType, authValue string
+	if auth != nil {
+		authID = auth.ID
+		authLabel = auth.Label
+		authType, authValue = auth.AccountInfo()
+	}
+	logHeaders, logBody := redactJumaRequestLog(e.cfg, httpReq.Header, reqBody)
+	recordAPIRequest(ctx, e.cfg, upstreamRequestLog{
+		URL:       url,
+		Method:    http.MethodPost,
+		Headers:   logHeaders,
+		Body:      logBody,
+		Provider:  e.Identifier(),
+		AuthID:    authID,
+		AuthLabel: authLabel,
+		AuthType:  authType,
+		AuthValue: authValue,
+	})
+
+	return httpReq, reqBody, model, reqLog, nil
+}
+
+func (e *JumaExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (resp cliproxyexecutor.Response, err error) {
+	req.Model = ResolveJumaModelAlias(e.cfg, req.Model)
+	if isJumaImageGenerationRequest(req.Payload) {
+		if isJumaAsyncImageRequest(ctx, req.Payload) {
+			return e.submitImageGenerationJob(ctx, auth, req, opts)
+		}
+		return e.executeImageGeneration(ctx, auth, req, opts)
+	}
+
+	if n, errN := jumaChoiceCount(e.cfg, req.Payload); errN != nil {
+		return resp, errN
+	} else if n > 1 {
+		return e.executeChoices(ctx, auth, req, opts, n)
+	}
+
+	reporter := newUsageReporter(ctx, e.Identifier(), req.Model, auth)
+	defer reporter.trackFailure(ctx, &err)
+
+	// The cache is consulted before the request is converted, so a hit skips uploads,
+	// workspace discovery and moderation. Entries are keyed on whether moderation is on,
+	// so enabling it never serves answers that were cached unchecked.
+	cacheKey := ""
+	if e.cfg != nil && e.cfg.Juma.ResponseCache.Enable && !isJumaChoiceRequest(ctx) && !jumaDryRunRequested(ctx, e.cfg) {
+		if model := getJumaModelByAlias(req.Model); model != nil && !model.ImageCapable {
+			cacheKey = jumaResponseCacheKey(ctx, e.cfg, auth, req)
+		}
+		if cached, ok := getJumaResponse(cacheKey); cacheKey != "" && ok {
+			jumaLogEntry(log.Fields{"model": req.Model}).Debug("juma executor: serving cached response")
+			reporter.ensurePublished(ctx)
+			resp = cliproxyexecutor.Response{Payload: cached}
+			return resp, nil
+		}
+	}
+
+	httpReq, reqBody, model, reqLog, err := e.buildJumaHTTPRequest(ctx, auth, req, false, reporter)
+	if err != nil {
+		return resp, err
+	}
+	if httpReq == nil {
+		// Dry run: reqBody is the redacted converted request
+		resp = cliproxyexecutor.Response{Payload: reqBody}
+		return resp, nil
+	}
+
+	release, err := acquireJumaSlot(ctx, e.cfg, auth)
+	if err != nil {
+		return resp, err
+	}
+	defer release()
+
+	httpClient := e.httpClient(ctx, auth, jumaRequestTimeout(e.cfg))
+	httpResp, err := doJumaRequest(ctx, e.cfg, httpClient, httpReq, reqBody, req.Model, reqLog)
+	if err != nil {
+		recordAPIResponseError(ctx, e.cfg, err)
+		return resp, err
+	}
+	defer func() {
+		if errClose := httpResp.Body.Close(); errClose != nil {
+			log.Errorf("juma executor: close response body error: %v", errClose)
+		}
+	}()
+	recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		b, _ := io.ReadAll(httpResp.Body)
+		appendAPIResponseChunk(ctx, e.cfg, b)
+		reqLog.WithField("status", httpResp.StatusCode).Errorf("juma executor: request error, body: %s", string(b))
+		se := newJumaStatusErr(httpResp.StatusCode, httpResp.Header, b, gjson.GetBytes(reqBody, "workspaceId").String())
+		if se.code == http.StatusTooManyRequests {
+			markJumaSessionThrottled(jumaRequestSessionToken(httpReq), se.retryAfter)
+		}
+		err = se
+		return resp, err
+	}
+	if err = jumaHTMLResponseErr(httpResp); err != nil {
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<16))
+		appendAPIResponseChunk(ctx, e.cfg, b)
+		reqLog.Warn("juma executor: upstream answered with an HTML page instead of an event stream")
+		return resp, err
+	}
+
+	// For non-streaming, read all SSE data and extract the final content
+	var fullContent strings.Builder
+	stopMatcher := newJumaStopMatcher(parseJumaStopSequences(req.Payload))
+	var reasoningContent strings.Builder
+	var generatedImageURLs []string
+	var citations []jumaCitation
+	upstreamFinishReason := ""
+	assistantMessageID := ""
+	reasoningPassthrough := e.cfg != nil && e.cfg.Juma.ReasoningPassthrough
+	outputLimit, outputRunes, truncated := jumaMaxOutputChars(e.cfg), 0, false
+	decodedBody, err := decodeResponseBody(httpResp.Body, httpResp.Header.Get("Content-Encoding"))
+	if err != nil {
+		recordAPIResponseError(ctx, e.cfg, err)
+		return resp, err
+	}
+	defer func() {
+		if errClose := decodedBody.Close(); errClose != nil {
+			log.Errorf("juma executor: close decoded body error: %v", errClose)
+		}
+	}()
+	idle := newJumaIdleWatchdog(httpResp.Body, jumaIdleTimeout(e.cfg))
+	defer idle.Stop()
+	scanner := newJumaLineReader(decodedBody, jumaMaxSSELineBytes(e.cfg))
+	assembler := &jumaEventAssembler{limit: jumaMaxSSELineBytes(e.cfg)}
+
+	for scanner.Scan() {
+		idle.Reset()
+		line := scanner.Text()
+		appendAPIResponseChunk(ctx, e.cfg, []byte(line))
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+		data, complete := assembler.push(data)
+		if !complete {
+			continue
+		}
+
+		// Parse events
+		eventType := gjson.Get(data, "type").String()
+		if eventType == "text-delta" {
+			delta := gjson.Get(data, "delta").String()
+			emitted, hit := stopMatcher.Push(delta)
+			emitted, truncated = capJumaOutput(emitted, outputRunes, outputLimit)
+			outputRunes += utf8.RuneCountInString(emitted)
+			fullContent.WriteString(emitted)
+			if hit || truncated {
+				break
+			}
+		} else if isJumaReasoningEvent(eventType) {
+			if reasoningPassthrough {
+				reasoningContent.WriteString(jumaReasoningDelta(data))
+			}
+		} else if citation, ok := parseJumaSourceEvent(eventType, data); ok {
+			citations = addJumaCitation(citations, citation)
+		} else if isJumaFinishEvent(eventType) {
+			if reason := jumaFinishReason(data); reason != "" {
+				upstreamFinishReason = reason
+			}
+		} else if eventType == "tool-output-available" {
+			// Extract generated image URLs from tool output
+			// Juma's "ImageGeneration" and "ImageEdit" tools usually report output.imageUrl
+			for _, imageURL := range extractJumaToolOutputImageURLs(data) {
+				if !slices.Contains(generatedImageURLs, imageURL) {
+					generatedImageURLs = append(generatedImageURLs, imageURL)
+				}
+			}
+		} else if eventType == "start" {
+			assistantMessageID = gjson.Get(data, "messageId").String()
+		} else if eventType == "error" {
+			errEvent := parseJumaErrorEvent(data)
+			reqLog.WithField("status", errEvent.code).Errorf("juma executor: upstream error event: %s", errEvent.msg)
+			recordAPIResponseError(ctx, e.cfg, errEvent)
+			err = errEvent
+			return resp, err
+		}
+	}
+
+	if !stopMatcher.Stopped() && !truncated {
+		var pending string
+		pending, truncated = capJumaOutput(stopMatcher.Flush(), outputRunes, outputLimit)
+		fullContent.WriteString(pending)
+	}
+
+	if errScan := idle.Err(scanner.Err()); errScan != nil {
+		recordAPIResponseError(ctx, e.cfg, errScan)
+		return resp, errScan
+	}
+
+	reporter.publishEstimated(ctx, estimateJumaTextTokens(fullContent.String()), estimateJumaTextTokens(reasoningContent.String()))
+	reporter.ensurePublished(ctx)
+	rememberJumaThread(ctx, auth, req.Payload, reqBody, assistantMessageID)
+
+	// Clients that cannot consume URLs request generated images inline as base64
+	if model.ImageCapable && len(generatedImageURLs) > 0 && gjson.GetBytes(req.Payload, "response_format").String() == "b64_json" {
+		encoded := make([]string, 0, len(generatedImageURLs))
+		for _, imageURL := range generatedImageURLs {
+			imageData, _, errFetch := fetchRemoteImage(ctx, httpClient, imageURL, jumaMaxRemoteImageBytes)
+			if errFetch != nil {
+				err = statusErr{code: http.StatusBadGateway, msg: fmt.Sprintf("failed to download generated image: %v", errFetch)}
+				return resp, err
+			}
+			encoded = append(encoded, base64.StdEncoding.EncodeToString(imageData))
+		}
+		resp = cliproxyexecutor.Response{Payload: buildOpenAIImageB64Response(encoded...)}
+		return resp, nil
+	}
+
+	generatedImageURLs = rehostJumaImages(e.cfg, generatedImageURLs)
+
+	// Check if this is an image model and we have generated image URLs
+	if model.ImageCapable && len(generatedImageURLs) > 0 {
+		openAIResp := buildOpenAIImageResponse(generatedImageURLs...)
+		resp = cliproxyexecutor.Response{Payload: openAIResp}
+		return resp, nil
+	}
+	// An image model that finishes without an image declined the request (usually with a
+	// text refusal); answer with that text instead of an empty image response.
+	declinedImage := model.ImageCapable && !stopMatcher.Stopped()
+	if declinedImage {
+		reqLog.WithField("model", req.Model).Info("juma executor: image model declined to generate an image")
+	}
+
+	// Append image markdown to content so it appears in Chat Completion
+	for _, imageURL := range generatedImageURLs {
+		if fullContent.Len() > 0 {
+			fullContent.WriteString("\n\n")
+		}
+		fullContent.WriteString(fmt.Sprintf("![Generated Image](%s)", imageURL))
+	}
+
+	if len(citations) > 0 && e.cfg != nil && e.cfg.Juma.InlineCitations && !truncated {
+		fullContent.WriteString(formatJumaCitations(citations))
+	}
+
+	content := fullContent.String()
+	if reasoningContent.Len() > 0 {
+		content = "<thinking>\n" + reasoningContent.String() + "\n</thinking>\n\n" + content
+	}
+
+	// Build OpenAI-style response
+	openAIResp := buildOpenAIChatResponse(req.Model, content)
+	if truncated {
+		openAIResp, _ = sjson.SetBytes(openAIResp, "choices.0.finish_reason", "length")
+	} else if upstreamFinishReason != "" && upstreamFinishReason != "stop" && !stopMatcher.Stopped() {
+		openAIResp, _ = sjson.SetBytes(openAIResp, "choices.0.finish_reason", upstreamFinishReason)
+	} else if declinedImage {
+		openAIResp, _ = sjson.SetBytes(openAIResp, "choices.0.finish_reason", "content_filter")
+	}
+	if len(citations) > 0 {
+		contentLen := len([]rune(gjson.GetBytes(openAIResp, "choices.0.message.content").String()))
+		openAIResp, _ = sjson.SetBytes(openAIResp, "choices.0.message.annotations", buildJumaCitationAnnotations(citations, contentLen))
+	}
+	if cacheKey != "" {
+		putJumaResponse(cacheKey, openAIResp, jumaResponseCacheTTL(e.cfg))
+	}
+	resp = cliproxyexecutor.Response{Payload: openAIResp}
+	return resp, nil
+}
+
+// jumaResponseCacheTTL returns how long a cached Juma response is reused.
+func jumaResponseCacheTTL(cfg *config.Config) time.Duration {
+	if cfg != nil && cfg.Juma.ResponseCache.TTL > 0 {
+		return time.Duration(cfg.Juma.ResponseCache.TTL) * time.Second
+	}
+	return jumaDefaultResponseCacheTTL
+}
+
+// jumaResponseCacheKey hashes the OpenAI request payload together with the auth, the
+// requested model, the vendor connection override and whether juma.moderation is on.
+// It returns "" for requests naming a Juma thread or conversation, whose answers depend
+// on history held by Juma.
+func jumaResponseCacheKey(ctx context.Context, cfg *config.Config, auth *cliproxyauth.Auth, req cliproxyexecutor.Request) string {
+	if jumaThreadOption(ctx, req.Payload, jumaThreadHeader, "juma_thread_id") != "" ||
+		jumaThreadOption(ctx, req.Payload, jumaConversationHeader, "conversation_id") != "" {
+		return ""
+	}
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, req.Payload); err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	if auth != nil {
+		h.Write([]byte(auth.ID))
+	}
+	for _, part := range []string{req.Model, jumaVendorConnectionOverride(ctx, auth, req.Model), strconv.FormatBool(cfg != nil && cfg.Juma.Moderation.Enable)} {
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+	h.Write([]byte{0})
+	h.Write(payload.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ExecuteStream sends the request to Juma and relays its events as OpenAI chunks.
+// When the upstream fails after content has been emitted, the stream is ended in a
+// fixed order: any text held back by the stop matcher, then a finish chunk with
+// finish_reason "stop", then the error chunk. Failures before any content produce
+// only the error chunk. Images-generation payloads are streamed as OpenAI image events
+// instead; see executeImageGenerationStream.
+func (e *JumaExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (stream <-chan cliproxyexecutor.StreamChunk, err error) {
+	req.Model = ResolveJumaModelAlias(e.cfg, req.Model)
+	if isJumaImageGenerationRequest(req.Payload) {
+		return e.executeImageGenerationStream(ctx, auth, req, opts)
+	}
+
+	if n, errN := jumaChoiceCount(e.cfg, req.Payload); errN != nil {
+		return nil, errN
+	} else if n > 1 {
+		return e.executeChoicesStream(ctx, auth, req, opts, n)
+	}
+
+	reporter := newUsageReporter(ctx, e.Identifier(), req.Model, auth)
+	defer reporter.trackFailure(ctx, &err)
+
+	httpReq, reqBody, _, reqLog, err := e.buildJumaHTTPRequest(ctx, auth, req, true, reporter)
+	if err != nil {
+		return nil, err
+	}
+
+	// The slot is held until the stream goroutine has finished reading the response.
+	release, err := acquireJumaSlot(ctx, e.cfg, auth)
+	if err != nil {
+		return nil, err
+	}
+	releaseOnReturn := true
+	defer func() {
+		if releaseOnReturn {
+			release()
+		}
+	}()
+
+	// Retries happen here, before the stream goroutine reads any of the response.
+	httpClient := e.httpClient(ctx, auth, jumaRequestTimeout(e.cfg))
+	httpResp, err := doJumaRequest(ctx, e.cfg, httpClient, httpReq, reqBody, req.Model, reqLog)
+	if err != nil {
+		recordAPIResponseError(ctx, e.cfg, err)
+		return nil, err
+	}
+	recordAPIResponseMetadata(ctx, e.cfg, httpResp.StatusCode, httpResp.Header.Clone())
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		b, _ := io.ReadAll(httpResp.Body)
+		appendAPIResponseChunk(ctx, e.cfg, b)
+		reqLog.WithField("status", httpResp.StatusCode).Errorf("juma executor stream: request error, body: %s", string(b))
+		if errClose := httpResp.Body.Close(); errClose != nil {
+			log.Errorf("juma executor: close response body error: %v", errClose)
+		}
+		se := newJumaStatusErr(httpResp.StatusCode, httpResp.Header, b, gjson.GetBytes(reqBody, "workspaceId").String())
+		if se.code == http.StatusTooManyRequests {
+			markJumaSessionThrottled(jumaRequestSessionToken(httpReq), se.retryAfter)
+		}
+		err = se
+		return nil, err
+	}
+	if err = jumaHTMLResponseErr(httpResp); err != nil {
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<16))
+		appendAPIResponseChunk(ctx, e.cfg, b)
+		reqLog.Warn("juma executor stream: upstream answered with an HTML page instead of an event stream")
+		if errClose := httpResp.Body.Close(); errClose != nil {
+			log.Errorf("juma executor: close response body error: %v", errClose)
+		}
+		return nil, err
+	}
+
+	// Some CDNs compress the SSE stream; decode it before scanning lines.
+	decodedBody, err := decodeResponseBody(httpResp.Body, httpResp.Header.Get("Content-Encoding"))
+	if err != nil {
+		recordAPIResponseError(ctx, e.cfg, err)
+		return nil, err
+	}
+
+	out := make(chan cliproxyexecutor.StreamChunk)
+	stream = out
+
+	releaseOnReturn = false
+	// lastSent is the unix-nano time of the last chunk handed to the client; the
+	// keepalive loop only fires once the stream has been quiet for a full interval.
+	var lastSent atomic.Int64
+	lastSent.Store(time.Now().UnixNano())
+	go func() {
+		defer close(out)
+		defer startJumaKeepAlive(ctx, out, jumaKeepAliveInterval(e.cfg), &lastSent)()
+		defer release()
+		defer func() {
+			if errClose := decodedBody.Close(); errClose != nil {
+				log.Errorf("juma executor: close response body error: %v", errClose)
+			}
+		}()
+
+		idle := newJumaIdleWatchdog(httpResp.Body, jumaIdleTimeout(e.cfg))
+		defer idle.Stop()
+		scanner := newJumaLineReader(decodedBody, jumaMaxSSELineBytes(e.cfg))
+		assembler := &jumaEventAssembler{limit: jumaMaxSSELineBytes(e.cfg)}
+		// chunksSent counts the content chunks emitted; every chunk carries choice index 0.
+		chunksSent := 0
+		toolInvoked := false
+		reasoningPassthrough := e.cfg != nil && e.cfg.Juma.ReasoningPassthrough
+		imageProgress := e.cfg != nil && e.cfg.Juma.ImageProgress
+		var lastProgressAt time.Time
+		stopMatcher := newJumaStopMatcher(parseJumaStopSequences(req.Payload))
+		includeUsage := gjson.GetBytes(req.Payload, "stream_options.include_usage").Bool()
+		outputLimit, truncated := jumaMaxOutputChars(e.cfg), false
+		var citations []jumaCitation
+		streamedRunes := 0
+		upstreamFinishReason := ""
+		assistantMessageID := ""
+		// Text and reasoning sent to the client, kept for the estimated usage record.
+		var completionText, reasoningText strings.Builder
+
+		// send delivers a chunk unless the request context is done, e.g. because the client
+		// disconnected and nothing drains out any more. After a cancellation every further
+		// send is dropped and the loop below stops reading.
+		cancelled := false
+		send := func(chunk cliproxyexecutor.StreamChunk) {
+			if cancelled {
+				return
+			}
+			select {
+			case out <- chunk:
+				lastSent.Store(time.Now().UnixNano())
+			case <-ctx.Done():
+				cancelled = true
+			}
+		}
+
+		// emit sends a payload chunk, preceded once by the OpenAI role-only chunk that
+		// strict clients expect before any content.
+		roleSent := false
+		emit := func(payload []byte) {
+			if !roleSent {
+				roleSent = true
+				send(cliproxyexecutor.StreamChunk{Payload: buildOpenAIStreamRoleChunk(req.Model)})
+			}
+			send(cliproxyexecutor.StreamChunk{Payload: payload})
+		}
+
+		// abandon records a stream cut short by cancellation as a failed request.
+		abandon := func() {
+			recordAPIResponseError(ctx, e.cfg, ctx.Err())
+			reporter.publishFailure(ctx)
+			reqLog.Debug("juma executor stream: client went away, stopping stream")
+		}
+
+		// failStream terminates a partially delivered stream before surfacing errStream.
+		failStream := func(errStream error) {
+			recordAPIResponseError(ctx, e.cfg, errStream)
+			reporter.publishFailure(ctx)
+			if chunksSent > 0 {
+				if pending := stopMatcher.Flush(); pending != "" {
+					chunk := buildOpenAIStreamChunk(req.Model, transformGeneratedImageTags(pending), 0)
+					emit(chunk)
+					chunksSent++
+				}
+				emit(buildOpenAIStreamFinishChunk(req.Model, "stop", 0))
+			}
+			send(cliproxyexecutor.StreamChunk{Err: errStream})
+		}
+
+		// pushText streams a text delta from Juma and reports whether a stop sequence or
+		// the output cap ended the response.
+		var upstreamText strings.Builder
+		pushText := func(text string) bool {
+			upstreamText.WriteString(text)
+			delta, hit := stopMatcher.Push(text)
+			if delta != "" {
+				// Transform Juma's custom image tags to Markdown format
+				var transformedDelta string
+				transformedDelta, truncated = capJumaOutput(transformGeneratedImageTags(delta), streamedRunes, outputLimit)
+				if transformedDelta != "" {
+					streamedRunes += len([]rune(transformedDelta))
+					completionText.WriteString(transformedDelta)
+					chunk := buildOpenAIStreamChunk(req.Model, transformedDelta, 0)
+					emit(chunk)
+					chunksSent++
+				}
+			}
+			return hit || truncated
+		}
+
+		for !cancelled && scanner.Scan() {
+			idle.Reset()
+			line := scanner.Text()
+			appendAPIResponseChunk(ctx, e.cfg, []byte(line))
+
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			data := strings.TrimPrefix(line, "data: ")
+			if data == "[DONE]" {
+				// Stream complete, just break - handler will send [DONE] when channel closes
+				break
+			}
+			data, complete := assembler.push(data)
+			if !complete {
+				continue
+			}
+
+			// Parse Juma events and convert to OpenAI SSE format
+			eventType := gjson.Get(data, "type").String()
+			if eventType == "text-delta" {
+				if pushText(gjson.Get(data, "delta").String()) {
+					// Stop sequence or output cap reached: stop reading upstream
+					break
+				}
+			} else if isJumaReasoningEvent(eventType) {
+				if !reasoningPassthrough {
+					continue
+				}
+				if delta := jumaReasoningDelta(data); delta != "" {
+					reasoningText.WriteString(delta)
+					chunk := buildOpenAIStreamReasoningChunk(req.Model, delta, 0)
+					emit(chunk)
+					chunksSent++
+				}
+			} else if citation, ok := parseJumaSourceEvent(eventType, data); ok {
+				citations = addJumaCitation(citations, citation)
+			} else if isJumaFinishEvent(eventType) {
+				if reason := jumaFinishReason(data); reason != "" {
+					upstreamFinishReason = reason
+				}
+			} else if isJumaToolProgressEvent(eventType) {
+				// Give clients feedback while the image tool runs, at most once per interval
+				if !imageProgress || time.Since(lastProgressAt) < jumaImageProgressInterval {
+					continue
+				}
+				lastProgressAt = time.Now()
+				chunk := buildOpenAIStreamChunk(req.Model, jumaImageProgressText, 0)
+				emit(chunk)
+				chunksSent++
+			} else if eventType == "tool-output-available" {
+				toolInvoked = true
+				// Juma's "ImageGeneration" and "ImageEdit" tools usually report output.imageUrl
+				for _, imageURL := range extractJumaToolOutputImageURLs(data) {
+					chunk := buildOpenAIStreamChunk(req.Model, fmt.Sprintf("\n\n![Generated Image](%s)", imageURL), 0)
+					emit(chunk)
+					chunksSent++
+				}
+			} else if eventType == "start" {
+				assistantMessageID = gjson.Get(data, "messageId").String()
+			} else if eventType == "error" {
+				errEvent := parseJumaErrorEvent(data)
+				reqLog.WithField("status", errEvent.code).Errorf("juma executor stream: upstream error event: %s", errEvent.msg)
+				failStream(errEvent)
+				return
+			}
+		}
+
+		if cancelled || ctx.Err() != nil {
+			abandon()
+			return
+		}
+		if errScan := idle.Err(scanner.Err()); errScan != nil {
+			remaining, recovered := e.recoverJumaStream(ctx, auth, jumaRequestSessionToken(httpReq), reqBody, upstreamText.String())
+			if !recovered {
+				failStream(errScan)
+				return
+			}
+			reqLog.WithError(errScan).Info("juma executor stream: stream interrupted, finished from the stored thread message")
+			if remaining != "" {
+				pushText(remaining)
+			}
+			if cancelled {
+				abandon()
+				return
+			}
+		}
+
+		if pending := stopMatcher.Flush(); pending != "" && !truncated {
+			var transformed string
+			transformed, truncated = capJumaOutput(transformGeneratedImageTags(pending), streamedRunes, outputLimit)
+			if transformed != "" {
+				streamedRunes += len([]rune(transformed))
+				completionText.WriteString(transformed)
+				chunk := buildOpenAIStreamChunk(req.Model, transformed, 0)
+				emit(chunk)
+				chunksSent++
+			}
+		}
+
+		if len(citations) > 0 {
+			if e.cfg != nil && e.cfg.Juma.InlineCitations && !truncated {
+				sources := formatJumaCitations(citations)
+				streamedRunes += len([]rune(sources))
+				emit(buildOpenAIStreamChunk(req.Model, sources, 0))
+				chunksSent++
+			}
+			emit(buildOpenAIStreamAnnotationsChunk(req.Model, buildJumaCitationAnnotations(citations, streamedRunes), 0))
+			chunksSent++
+		}
+
+		// Emit the terminating chunk so strict OpenAI clients receive a finish_reason
+		// Juma's own stop reason wins unless a client stop sequence ended the stream; a plain
+		// "stop" does not hide that an image tool ran.
+		finishReason := "stop"
+		switch {
+		case truncated:
+			finishReason = "length"
+		case stopMatcher.Stopped():
+		case upstreamFinishReason != "" && upstreamFinishReason != "stop":
+			finishReason = upstreamFinishReason
+		case toolInvoked:
+			finishReason = "tool_calls"
+		}
+		emit(buildOpenAIStreamFinishChunk(req.Model, finishReason, 0))
+		completionTokens, reasoningTokens := estimateJumaTextTokens(completionText.String()), estimateJumaTextTokens(reasoningText.String())
+		if includeUsage {
+			emit(buildOpenAIStreamUsageChunk(req.Model, reporter.estimatedInput, completionTokens, reasoningTokens))
+		}
+		if cancelled {
+			abandon()
+			return
+		}
+		reporter.publishEstimated(ctx, completionTokens, reasoningTokens)
+		reporter.ensurePublished(ctx)
+		rememberJumaThread(ctx, auth, req.Payload, reqBody, assistantMessageID)
+	}()
+
+	return stream, nil
+}
+
+func (e *JumaExecutor) CountTokens(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	// Juma doesn't provide a token counting API, so we estimate
+	return cliproxyexecutor.Response{}, fmt.Errorf("juma executor: token counting not supported")
+}
+
+// ValidateAuth performs a cheap authenticated probe against Juma's session endpoint and
+// returns nil when the session token is accepted and the configured IDs are well-formed.
+// It is used to reject broken credentials before they are selected for real traffic.
+func (e *JumaExecutor) ValidateAuth(ctx context.Context, auth *cliproxyauth.Auth) error {
+	sessionToken, workspaceID, vendorConnectionID := jumaCredentials(auth)
+	if sessionToken == "" {
+		return statusErr{code: http.StatusUnauthorized, msg: "missing Juma session token"}
+	}
+	if workspaceID != "" && !isJumaUUID(workspaceID) {
+		return statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid Juma workspace_id %q: expected a UUID", workspaceID)}
+	}
+	if vendorConnectionID != "" && !isJumaUUID(vendorConnectionID) {
+		return statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid Juma vendor_connection_id %q: expected a UUID", vendorConnectionID)}
+	}
+
+	baseURL := jumaBaseURLFor(e.cfg)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/auth/session", nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("Origin", baseURL)
+	httpReq.Header.Set("User-Agent", jumaUserAgent(e.cfg))
+	httpReq.Header.Set("Accept-Language", jumaLocale(e.cfg))
+	httpReq.AddCookie(&http.Cookie{
+		Name:  jumaSessionCookieName,
+		Value: sessionToken,
+	})
+
+	httpClient := e.httpClient(ctx, auth, 15*time.Second)
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("juma executor: session probe failed: %w", err)
+	}
+	defer func() {
+		if errClose := httpResp.Body.Close(); errClose != nil {
+			log.Errorf("juma executor: close response body error: %v", errClose)
+		}
+	}()
+
+	body, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return statusErr{code: httpResp.StatusCode, msg: fmt.Sprintf("juma session probe failed: %s", strings.TrimSpace(string(body)))}
+	}
+	// next-auth returns an empty object when the session cookie is not recognised
+	if !gjson.GetBytes(body, "user").Exists() {
+		return statusErr{code: http.StatusUnauthorized, msg: "Juma session token is invalid or expired"}
+	}
+	return nil
+}
+
+// Refresh probes the Juma session since cookie sessions cannot be renewed via an API.
+// When Juma rejects the session token the auth is marked unavailable with a status
+// message asking for re-authentication; transient probe failures leave it unchanged.
+func (e *JumaExecutor) Refresh(ctx context.Context, auth *cliproxyauth.Auth) (*cliproxyauth.Auth, error) {
+	log.Debugf("juma executor: refresh called")
+	if auth == nil {
+		return nil, fmt.Errorf("juma executor: auth is nil")
+	}
+
+	err := e.ValidateAuth(ctx, auth)
+	if err == nil {
+		if auth.StatusMessage == jumaSessionExpiredMessage {
+			auth.Unavailable = false
+			auth.StatusMessage = ""
+		}
+		return auth, nil
+	}
+
+	var se statusErr
+	if errors.As(err, &se) && (se.code == http.StatusUnauthorized || se.code == http.StatusForbidden) {
+		log.Warnf("juma executor: session token for auth %s is no longer valid: %v", auth.ID, err)
+		auth.Unavailable = true
+		auth.StatusMessage = jumaSessionExpiredMessage
+		return auth, nil
+	}
+
+	log.Warnf("juma executor: session probe for auth %s failed: %v", auth.ID, err)
+	return auth, nil
+}
+
+// redactJumaRequestLog prepares headers and body for request logging. The session cookie
+// is masked and uploaded image/file URLs are truncated unless Juma.UnredactedRequestLog is set.
+func redactJumaRequestLog(cfg *config.Config, headers http.Header, body []byte) (http.Header, []byte) {
+	logHeaders := headers.Clone()
+	if cfg != nil && cfg.Juma.UnredactedRequestLog {
+		return logHeaders, body
+	}
+
+	if cookies := (&http.Request{Header: headers}).Cookies(); len(cookies) > 0 {
+		masked := make([]string, 0, len(cookies))
+		for _, c := range cookies {
+			masked = append(masked, c.Name+"="+jumaclient.MaskFieldValue(c.Name, c.Value))
+		}
+		logHeaders.Set("Cookie", strings.Join(masked, "; "))
+	}
+
+	logBody := body
+	gjson.GetBytes(body, "messages").ForEach(func(msgIdx, msg gjson.Result) bool {
+		msg.Get("uploadedImages").ForEach(func(imgIdx, img gjson.Result) bool {
+			if v := img.Get("imageUrl").String(); v != "" {
+				path := fmt.Sprintf("messages.%d.uploadedImages.%d.imageUrl", msgIdx.Int(), imgIdx.Int())
+				logBody, _ = sjson.SetBytes(logBody, path, jumaclient.MaskFieldValue("imageUrl", v))
+			}
+			return true
+		})
+		msg.Get("uploadedFiles").ForEach(func(fileIdx, file gjson.Result) bool {
+			if v := file.Get("fileUrl").String(); v != "" {
+				path := fmt.Sprintf("messages.%d.uploadedFiles.%d.fileUrl", msgIdx.Int(), fileIdx.Int())
+				logBody, _ = sjson.SetBytes(logBody, path, jumaclient.MaskFieldValue("fileUrl", v))
+			}
+			return true
+		})
+		return true
+	})
+	return logHeaders, logBody
+}
+
+const (
+	// jumaDryRunHeader asks Execute to return the converted Juma request instead of sending it.
+	jumaDryRunHeader = "X-Juma-Dry-Run"
+	// jumaDryRunPlaceholderID stands in for the IDs of attachments a dry run does not upload.
+	jumaDryRunPlaceholderID = "00000000-0000-0000-0000-000000000000"
+	// jumaDryRunPlaceholderURL stands in for the storage URLs of attachments a dry run does not upload.
+	jumaDryRunPlaceholderURL = "<dry-run>"
+)
+
+// jumaDryRunRequested reports whether the converted request should be echoed back,
+// either because juma.dry-run is enabled or the client sent X-Juma-Dry-Run: true.
+func jumaDryRunRequested(ctx context.Context, cfg *config.Config) bool {
+	if cfg != nil && cfg.Juma.DryRun {
+		return true
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil || ginCtx.Request == nil {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(ginCtx.Request.Header.Get(jumaDryRunHeader)))
+	return err == nil && enabled
+}
+
+// redactJumaDryRunPayload masks account identifiers in a marshaled JumaRequest before it
+// is echoed to the client. The session token travels in a cookie and is never part of the body.
+func redactJumaDryRunPayload(body []byte) []byte {
+	out := body
+	for _, field := range []string{"workspaceId", "vendorConnectionId"} {
+		if gjson.GetBytes(out, field).String() != "" {
+			out, _ = sjson.SetBytes(out, field, "<redacted>")
+		}
+	}
+	return out
+}
+
+const (
+	// jumaConversationHeader identifies a client conversation so follow-up requests
+	// reuse the same Juma thread.
+	jumaConversationHeader = "X-Juma-Conversation-Id"
+	// jumaThreadHeader continues an explicit Juma thread ID.
+	jumaThreadHeader = "X-Juma-Thread-Id"
+	// jumaRegenerateHeader asks Juma to regenerate the last assistant response.
+	jumaRegenerateHeader = "X-Juma-Regenerate"
+	// jumaUploadedImagesHeader echoes metadata of the images uploaded for a request.
+	jumaUploadedImagesHeader = "X-Juma-Uploaded-Images"
+)
+
+// setJumaUploadedImagesHeader reports the ID, dimensions and size of each uploaded image
+// in the X-Juma-Uploaded-Images response header when juma.upload-metadata-header is set.
+func setJumaUploadedImagesHeader(ctx context.Context, cfg *config.Config, images []JumaUploadedImage) {
+	if cfg == nil || !cfg.Juma.UploadMetadataHeader || len(images) == 0 || isJumaChoiceRequest(ctx) {
+		return
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return
+	}
+	type imageMetadata struct {
+		ID     string `json:"id"`
+		Width  int    `json:"width,omitempty"`
+		Height int    `json:"height,omitempty"`
+		Size   int64  `json:"size"`
+	}
+	metadata := make([]imageMetadata, 0, len(images))
+	for _, img := range images {
+		metadata = append(metadata, imageMetadata{ID: img.ID, Width: img.Width, Height: img.Height, Size: img.Size})
+	}
+	if encoded, err := json.Marshal(metadata); err == nil {
+		ginCtx.Header(jumaUploadedImagesHeader, string(encoded))
+	}
+}
+
+// setJumaThreadResponseHeader echoes the Juma thread used for the request in the
+// X-Juma-Thread-Id response header, so clients can correlate logs and continue the thread.
+func setJumaThreadResponseHeader(ctx context.Context, threadID string) {
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil || threadID == "" || isJumaChoiceRequest(ctx) {
+		// Parallel choices each run their own thread; no single ID describes the response.
+		return
+	}
+	ginCtx.Header(jumaThreadHeader, threadID)
+}
+
+// jumaThreadOption returns a thread continuation option from the request header, falling
+// back to the payload field.
+func jumaThreadOption(ctx context.Context, payload []byte, header, field string) string {
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+		if v := strings.TrimSpace(ginCtx.Request.Header.Get(header)); v != "" {
+			return v
+		}
+	}
+	return strings.TrimSpace(gjson.GetBytes(payload, field).String())
+}
+
+// applyJumaThreadContinuation continues an existing Juma thread instead of starting a
+// new one when the caller supplies a thread ID (X-Juma-Thread-Id or "juma_thread_id") or
+// a conversation ID (X-Juma-Conversation-Id or "conversation_id") seen before. Prior
+// message IDs are reused so Juma can match history. With regenerate
+// (X-Juma-Regenerate or "regenerate": true) a trailing assistant message is dropped and
+// Juma is asked to regenerate it in-context. The thread is recorded for the conversation
+// only once Juma has answered; see rememberJumaThread.
+func applyJumaThreadContinuation(ctx context.Context, auth *cliproxyauth.Auth, jumaReq *JumaRequest, payload []byte) error {
+	if isJumaChoiceRequest(ctx) {
+		// Every choice of an n > 1 request starts its own thread.
+		return nil
+	}
+	conversationID := jumaThreadOption(ctx, payload, jumaConversationHeader, "conversation_id")
+	threadID := jumaThreadOption(ctx, payload, jumaThreadHeader, "juma_thread_id")
+	regenerate, _ := strconv.ParseBool(jumaThreadOption(ctx, payload, jumaRegenerateHeader, "regenerate"))
+
+	if threadID != "" && !isJumaUUID(threadID) {
+		return statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("invalid Juma thread ID %q", threadID)}
+	}
+
+	var priorIDs []string
+	if conversationID != "" {
+		if entry, ok := getJumaThread(jumaConversationKey(auth, conversationID)); ok && (threadID == "" || threadID == entry.ThreadID) {
+			threadID = entry.ThreadID
+			priorIDs = entry.MessageIDs
+		}
+	}
+
+	if threadID != "" {
+		jumaReq.ThreadID = threadID
+		jumaReq.IsNewThread = false
+		for i := range jumaReq.Messages {
+			if i < len(priorIDs) {
+				jumaReq.Messages[i].ID = priorIDs[i]
+			}
+		}
+	}
+
+	if regenerate {
+		if jumaReq.IsNewThread {
+			return statusErr{code: http.StatusBadRequest, msg: "regenerate requires an existing Juma thread"}
+		}
+		jumaReq.Trigger = "regenerate-message"
+		if n := len(jumaReq.Messages); n > 0 && jumaReq.Messages[n-1].Role == "assistant" {
+			jumaReq.MessageID = jumaReq.Messages[n-1].ID
+			jumaReq.Messages = jumaReq.Messages[:n-1]
+		}
+	}
+
+	return nil
+}
+
+// jumaConversationKey is the thread cache key of a client conversation.
+func jumaConversationKey(auth *cliproxyauth.Auth, conversationID string) string {
+	key := ""
+	if auth != nil {
+		key = auth.ID
+	}
+	return key + "|" + conversationID
+}
+
+// rememberJumaThread records the thread of a request Juma answered under its
+// conversation ID, with the IDs of the messages sent followed by the ID of the assistant
+// reply, so the next turn reuses all of them and Juma can match its history. reqBody is
+// the marshaled JumaRequest; a regenerated reply without a streamed ID keeps the ID it
+// replaced. Requests without a conversation ID are not recorded.
+func rememberJumaThread(ctx context.Context, auth *cliproxyauth.Auth, payload, reqBody []byte, assistantMessageID string) {
+	if isJumaChoiceRequest(ctx) {
+		return
+	}
+	conversationID := jumaThreadOption(ctx, payload, jumaConversationHeader, "conversation_id")
+	if conversationID == "" {
+		return
+	}
+	messageIDs := make([]string, 0)
+	gjson.GetBytes(reqBody, "messages.#.id").ForEach(func(_, id gjson.Result) bool {
+		messageIDs = append(messageIDs, id.String())
+		return true
+	})
+	if assistantMessageID == "" {
+		assistantMessageID = gjson.GetBytes(reqBody, "messageId").String()
+	}
+	if assistantMessageID != "" {
+		messageIDs = append(messageIDs, assistantMessageID)
+	}
+	putJumaThread(jumaConversationKey(auth, conversationID), gjson.GetBytes(reqBody, "threadId").String(), messageIDs)
+}
+
+// jumaUnsupportedGenerationParams lists OpenAI sampling parameters Juma's chat API ignores.
+var jumaUnsupportedGenerationParams = []string{"frequency_penalty", "presence_penalty"}
+
+// jumaSeedProviders and jumaLogitBiasProviders list the vendors whose models honour
+// seed and logit_bias when Juma forwards them.
+var (
+	jumaSeedProviders      = []string{"OpenAI", "Google"}
+	jumaLogitBiasProviders = []string{"OpenAI"}
+)
+
+// applyJumaGenerationParams copies temperature, top_p and max_tokens (or
+// max_completion_tokens) from the OpenAI payload onto the Juma request, plus seed and
+// logit_bias when model's provider supports them. Parameters Juma does not support are
+// dropped with a debug log.
+func applyJumaGenerationParams(jumaReq *JumaRequest, model *JumaModel, payload []byte) {
+	if v := gjson.GetBytes(payload, "temperature"); v.Exists() && v.Type == gjson.Number {
+		temperature := v.Float()
+		jumaReq.Temperature = &temperature
+	}
+	if v := gjson.GetBytes(payload, "top_p"); v.Exists() && v.Type == gjson.Number {
+		topP := v.Float()
+		jumaReq.TopP = &topP
+	}
+	maxTokens := gjson.GetBytes(payload, "max_completion_tokens")
+	if !maxTokens.Exists() {
+		maxTokens = gjson.GetBytes(payload, "max_tokens")
+	}
+	if maxTokens.Exists() && maxTokens.Type == gjson.Number && maxTokens.Int() > 0 {
+		limit := maxTokens.Int()
+		jumaReq.MaxTokens = &limit
+	}
+	provider := ""
+	if model != nil {
+		provider = model.Provider
+	}
+	if v := gjson.GetBytes(payload, "seed"); v.Exists() {
+		if v.Type == gjson.Number && slices.Contains(jumaSeedProviders, provider) {
+			seed := v.Int()
+			jumaReq.Seed = &seed
+		} else {
+			jumaLogEntry(log.Fields{"param": "seed", "provider": provider}).Debug("juma executor: dropping unsupported generation parameter")
+		}
+	}
+	if v := gjson.GetBytes(payload, "logit_bias"); v.Exists() {
+		if v.IsObject() && slices.Contains(jumaLogitBiasProviders, provider) {
+			bias := make(map[string]float64)
+			v.ForEach(func(token, weight gjson.Result) bool {
+				if weight.Type == gjson.Number {
+					bias[token.String()] = weight.Float()
+				}
+				return true
+			})
+			if len(bias) > 0 {
+				jumaReq.LogitBias = bias
+			}
+		} else {
+			jumaLogEntry(log.Fields{"param": "logit_bias", "provider": provider}).Debug("juma executor: dropping unsupported generation parameter")
+		}
+	}
+	for _, name := range jumaUnsupportedGenerationParams {
+		if gjson.GetBytes(payload, name).Exists() {
+			jumaLogEntry(log.Fields{"param": name}).Debug("juma executor: dropping unsupported generation parameter")
+		}
+	}
+}
+
+// parseJumaStopSequences reads the OpenAI "stop" parameter, which may be a string or an array.
+func parseJumaStopSequences(payload []byte) []string {
+	stop := gjson.GetBytes(payload, "stop")
+	if !stop.Exists() {
+		return nil
+	}
+	var stops []string
+	if stop.IsArray() {
+		for _, item := range stop.Array() {
+			if v := item.String(); v != "" {
+				stops = append(stops, v)
+			}
+		}
+		return stops
+	}
+	if v := stop.String(); v != "" {
+		stops = append(stops, v)
+	}
+	return stops
+}
+
+// jumaStopMatcher trims incrementally received text at the first stop sequence.
+// Text that could be the beginning of a stop sequence split across deltas is held
+// back until it can be resolved.
+type jumaStopMatcher struct {
+	stops   []string
+	pending string
+	stopped bool
+}
+
+// newJumaStopMatcher creates a matcher for the given stop sequences.
+func newJumaStopMatcher(stops []string) *jumaStopMatcher {
+	return &jumaStopMatcher{stops: stops}
+}
+
+// Push appends delta and returns the text that is safe to emit, plus whether a stop
+// sequence was reached. After a stop sequence is reached all further input is dropped.
+func (m *jumaStopMatcher) Push(delta string) (string, bool) {
+	if m.stopped {
+		return "", true
+	}
+	if len(m.stops) == 0 {
+		return delta, false
+	}
+
+	buf := m.pending + delta
+	earliest := -1
+	for _, stop := range m.stops {
+		if idx := strings.Index(buf, stop); idx >= 0 && (earliest < 0 || idx < earliest) {
+			earliest = idx
+		}
+	}
+	if earliest >= 0 {
+		m.pending = ""
+		m.stopped = true
+		return buf[:earliest], true
+	}
+
+	hold := 0
+	for _, stop := range m.stops {
+		for k := min(len(stop)-1, len(buf)); k > hold; k-- {
+			if strings.HasSuffix(buf, stop[:k]) {
+				hold = k
+				break
+			}
+		}
+	}
+	m.pending = buf[len(buf)-hold:]
+	return buf[:len(buf)-hold], false
+}
+
+// Flush returns any held-back text once the upstream stream has ended.
+func (m *jumaStopMatcher) Flush() string {
+	pending := m.pending
+	m.pending = ""
+	return pending
+}
+
+// Stopped reports whether a stop sequence has been reached.
+func (m *jumaStopMatcher) Stopped() bool { return m.stopped }
+
+// buildOpenAIChatResponse builds an OpenAI-compatible chat completion response.
+func buildOpenAIChatResponse(model, content string) []byte {
+	// Transform Juma's custom image tags to Markdown format
+	transformedContent := transformGeneratedImageTags(content)
+
+	resp := map[string]any{
+		"id":      "chatcmpl-" + uuid.New().String()[:8],
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{
+			{
+				"index": 0,
+				"message": map[string]any{
+					"role":    "assistant",
+					"content": transformedContent,
+				},
+				"finish_reason": "stop",
+			},
+		},
+		"usage": map[string]any{
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+			"total_tokens":      0,
+		},
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+// buildOpenAIStreamChunk builds an OpenAI-compatible SSE chunk.
+// It returns only the JSON payload; the caller is responsible for adding SSE framing.
+func buildOpenAIStreamChunk(model, delta string, index int) []byte {
+	chunk := map[string]any{
+		"id":      "chatcmpl-" + uuid.New().String()[:8],
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{
+			{
+				"index": index,
+				"delta": map[string]any{
+					"content": delta,
+				},
+				"finish_reason": nil,
+			},
+		},
+	}
+	b, _ := json.Marshal(chunk)
+	return b
+}
+
+// buildOpenAIStreamRoleChunk builds the leading OpenAI SSE chunk whose delta only
+// announces the assistant role, as OpenAI sends before any content.
+func buildOpenAIStreamRoleChunk(model string) []byte {
+	chunk := map[string]any{
 		"id":      "chatcmpl-" + uuid.New().String()[:8],
-		"object":  "chat.completion",
+		"object":  "chat.completion.chunk",
 		"created": time.Now().Unix(),
 		"model":   model,
 		"choices": []map[string]any{
 			{
 				"index": 0,
-				"message": map[string]any{
+				"delta": map[string]any{
 					"role":    "assistant",
-					"content": transformedContent,
+					"content": "",
 				},
-				"finish_reason": "stop",
+				"finish_reason": nil,
 			},
 		},
-		"usage": map[string]any{
-			"prompt_tokens":     0,
-			"completion_tokens": 0,
-			"total_tokens":      0,
+	}
+	b, _ := json.Marshal(chunk)
+	return b
+}
+
+// buildOpenAIStreamReasoningChunk builds an OpenAI-compatible SSE chunk whose delta
+// carries model reasoning in the reasoning_content field instead of content.
+func buildOpenAIStreamReasoningChunk(model, reasoning string, index int) []byte {
+	chunk := map[string]any{
+		"id":      "chatcmpl-" + uuid.New().String()[:8],
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{
+			{
+				"index": index,
+				"delta": map[string]any{
+					"reasoning_content": reasoning,
+				},
+				"finish_reason": nil,
+			},
 		},
 	}
-	b, _ := json.Marshal(resp)
+	b, _ := json.Marshal(chunk)
 	return b
 }
 
-// buildOpenAIStreamChunk builds an OpenAI-compatible SSE chunk.
-// It returns only the JSON payload; the caller is responsible for adding SSE framing.
-func buildOpenAIStreamChunk(model, delta string, index int) []byte {
+// isJumaReasoningEvent reports whether the SSE event type carries model reasoning.
+// Juma emits "reasoning-delta" for incremental reasoning and "reasoning" for whole blocks.
+func isJumaReasoningEvent(eventType string) bool {
+	return eventType == "reasoning-delta" || eventType == "reasoning"
+}
+
+// isJumaToolProgressEvent reports whether the SSE event signals intermediate tool progress,
+// such as the ImageEdit tool receiving its input or reporting status before its output.
+func isJumaToolProgressEvent(eventType string) bool {
+	switch eventType {
+	case "tool-input-start", "tool-input-delta", "tool-input-available", "tool-progress", "data-progress", "data-status":
+		return true
+	default:
+		return false
+	}
+}
+
+// jumaToolOutputImagePaths lists where image tools have been seen to report their result
+// inside a tool-output-available event's output, in order of preference.
+var jumaToolOutputImagePaths = []string{
+	"imageUrl",
+	"imageURL",
+	"image_url",
+	"url",
+	"image.imageUrl",
+	"image.url",
+	"result.imageUrl",
+	"result.imageURL",
+	"result.url",
+	"result.image.imageUrl",
+	"data.imageUrl",
+	"data.url",
+}
+
+// extractJumaToolOutputImageURLs returns the image URLs reported by a tool-output-available
+// event. Besides output.imageUrl it tries the nested candidates in jumaToolOutputImagePaths,
+// output values encoded as a JSON string, and arrays of URLs or image objects (either as
+// the output itself or under "images" / "result"). Duplicates are dropped.
+func extractJumaToolOutputImageURLs(data string) []string {
+	var urls []string
+	add := func(candidate string) {
+		candidate = strings.TrimSpace(candidate)
+		if (strings.HasPrefix(candidate, "http://") || strings.HasPrefix(candidate, "https://")) && !slices.Contains(urls, candidate) {
+			urls = append(urls, candidate)
+		}
+	}
+
+	var visit func(node gjson.Result, depth int)
+	visit = func(node gjson.Result, depth int) {
+		if depth > 3 {
+			return
+		}
+		switch {
+		case node.Type == gjson.String:
+			if raw := strings.TrimSpace(node.String()); gjson.Valid(raw) && (strings.HasPrefix(raw, "{") || strings.HasPrefix(raw, "[")) {
+				visit(gjson.Parse(raw), depth+1)
+				return
+			}
+			add(node.String())
+		case node.IsArray():
+			for _, item := range node.Array() {
+				visit(item, depth+1)
+			}
+		case node.IsObject():
+			for _, path := range jumaToolOutputImagePaths {
+				if v := node.Get(path); v.Type == gjson.String {
+					add(v.String())
+					return
+				}
+			}
+			for _, key := range []string{"images", "result", "data"} {
+				if v := node.Get(key); v.IsArray() || v.IsObject() {
+					visit(v, depth+1)
+				}
+			}
+		}
+	}
+	visit(gjson.Get(data, "output"), 0)
+	return urls
+}
+
+// jumaFinishReasons maps Juma's stop reasons, as reported by its finish events, onto the
+// OpenAI finish_reason values.
+var jumaFinishReasons = map[string]string{
+	"stop":           "stop",
+	"end_turn":       "stop",
+	"end-turn":       "stop",
+	"stop_sequence":  "stop",
+	"length":         "length",
+	"max_tokens":     "length",
+	"max-tokens":     "length",
+	"content-filter": "content_filter",
+	"content_filter": "content_filter",
+	"safety":         "content_filter",
+	"refusal":        "content_filter",
+	"tool-calls":     "tool_calls",
+	"tool_calls":     "tool_calls",
+	"tool_use":       "tool_calls",
+}
+
+// isJumaFinishEvent reports whether the SSE event reports why generation stopped.
+func isJumaFinishEvent(eventType string) bool {
+	return eventType == "finish" || eventType == "finish-step" || eventType == "finish-message"
+}
+
+// jumaFinishReason returns the OpenAI finish_reason for a Juma finish event, or "" when
+// the event carries no reason or one without an OpenAI equivalent.
+func jumaFinishReason(data string) string {
+	for _, path := range []string{"finishReason", "finish_reason", "stopReason", "messageMetadata.finishReason"} {
+		if reason := strings.ToLower(strings.TrimSpace(gjson.Get(data, path).String())); reason != "" {
+			return jumaFinishReasons[reason]
+		}
+	}
+	return ""
+}
+
+// jumaCitation is a source cited by a Juma model with web access.
+type jumaCitation struct {
+	URL   string
+	Title string
+}
+
+// parseJumaSourceEvent extracts the cited source from a "source-url" (or older "source")
+// event. Document sources without a URL carry no attribution link and are skipped.
+func parseJumaSourceEvent(eventType, data string) (jumaCitation, bool) {
+	switch eventType {
+	case "source-url", "source", "source-document":
+	default:
+		return jumaCitation{}, false
+	}
+	citation := jumaCitation{
+		URL:   strings.TrimSpace(gjson.Get(data, "url").String()),
+		Title: strings.TrimSpace(gjson.Get(data, "title").String()),
+	}
+	if citation.URL == "" {
+		citation.URL = strings.TrimSpace(gjson.Get(data, "source.url").String())
+	}
+	if citation.Title == "" {
+		citation.Title = strings.TrimSpace(gjson.Get(data, "source.title").String())
+	}
+	if !strings.HasPrefix(citation.URL, "http://") && !strings.HasPrefix(citation.URL, "https://") {
+		return jumaCitation{}, false
+	}
+	return citation, true
+}
+
+// addJumaCitation appends citation unless its URL was already collected.
+func addJumaCitation(citations []jumaCitation, citation jumaCitation) []jumaCitation {
+	for _, existing := range citations {
+		if existing.URL == citation.URL {
+			return citations
+		}
+	}
+	return append(citations, citation)
+}
+
+// formatJumaCitations renders citations as the trailing markdown "Sources" section used
+// when juma.inline-citations is enabled.
+func formatJumaCitations(citations []jumaCitation) string {
+	var b strings.Builder
+	b.WriteString("\n\nSources:")
+	for i, citation := range citations {
+		title := citation.Title
+		if title == "" {
+			title = citation.URL
+		}
+		fmt.Fprintf(&b, "\n%d. [%s](%s)", i+1, title, citation.URL)
+	}
+	return b.String()
+}
+
+// buildJumaCitationAnnotations converts citations to OpenAI url_citation annotations that
+// span content of the given length.
+func buildJumaCitationAnnotations(citations []jumaCitation, contentLen int) []map[string]any {
+	annotations := make([]map[string]any, 0, len(citations))
+	for _, citation := range citations {
+		annotations = append(annotations, map[string]any{
+			"type": "url_citation",
+			"url_citation": map[string]any{
+				"url":         citation.URL,
+				"title":       citation.Title,
+				"start_index": 0,
+				"end_index":   contentLen,
+			},
+		})
+	}
+	return annotations
+}
+
+// buildOpenAIStreamAnnotationsChunk builds an OpenAI SSE chunk whose delta carries
+// url_citation annotations.
+func buildOpenAIStreamAnnotationsChunk(model string, annotations []map[string]any, index int) []byte {
 	chunk := map[string]any{
 		"id":      "chatcmpl-" + uuid.New().String()[:8],
 		"object":  "chat.completion.chunk",
@@ -821,7 +3393,7 @@ func buildOpenAIStreamChunk(model, delta string, index int) []byte {
 			{
 				"index": index,
 				"delta": map[string]any{
-					"content": delta,
+					"annotations": annotations,
 				},
 				"finish_reason": nil,
 			},
@@ -831,9 +3403,301 @@ func buildOpenAIStreamChunk(model, delta string, index int) []byte {
 	return b
 }
 
-// isNanobananaModel checks if the given model alias is the Nanobanana Pro model.
-func isNanobananaModel(modelAlias string) bool {
-	return modelAlias == "juma-nanobanana-pro"
+// jumaEventAssembler rejoins SSE events whose JSON payload Juma split across several
+// data frames. Frames are concatenated until they form valid JSON; a pending payload that
+// grows past limit bytes is discarded so a malformed frame cannot stall the stream.
+type jumaEventAssembler struct {
+	pending strings.Builder
+	limit   int
+}
+
+// push adds one data frame and returns the complete event payload once available.
+func (a *jumaEventAssembler) push(data string) (string, bool) {
+	if a.pending.Len() == 0 {
+		if gjson.Valid(data) {
+			return data, true
+		}
+		if !strings.HasPrefix(strings.TrimSpace(data), "{") {
+			// Not the start of a JSON event; nothing to reassemble.
+			return data, true
+		}
+	}
+	a.pending.WriteString(data)
+	joined := a.pending.String()
+	if gjson.Valid(joined) {
+		a.pending.Reset()
+		return joined, true
+	}
+	if a.limit > 0 && a.pending.Len() > a.limit {
+		jumaLogEntry(log.Fields{"bytes": a.pending.Len()}).Warn("juma executor: dropping incomplete SSE event")
+		a.pending.Reset()
+	}
+	return "", false
+}
+
+// jumaReasoningDelta extracts the reasoning text from a Juma reasoning event.
+func jumaReasoningDelta(data string) string {
+	if delta := gjson.Get(data, "delta").String(); delta != "" {
+		return delta
+	}
+	return gjson.Get(data, "text").String()
+}
+
+// jumaMaxOutputChars returns juma.max-output-chars; zero means unlimited.
+func jumaMaxOutputChars(cfg *config.Config) int {
+	if cfg != nil && cfg.Juma.MaxOutputChars > 0 {
+		return cfg.Juma.MaxOutputChars
+	}
+	return 0
+}
+
+// capJumaOutput trims text so that no more than limit characters are returned in total,
+// given used characters already returned, and reports whether the cap has been reached.
+// A limit of zero disables the cap.
+func capJumaOutput(text string, used, limit int) (string, bool) {
+	if limit <= 0 {
+		return text, false
+	}
+	remaining := limit - used
+	if remaining <= 0 {
+		return "", true
+	}
+	if utf8.RuneCountInString(text) <= remaining {
+		return text, false
+	}
+	return string([]rune(text)[:remaining]), true
+}
+
+// buildOpenAIStreamUsageChunk builds the final chunk sent for stream_options.include_usage:
+// no choices and the (estimated) usage of the whole stream.
+func buildOpenAIStreamUsageChunk(model string, promptTokens, completionTokens, reasoningTokens int64) []byte {
+	chunk := map[string]any{
+		"id":      "chatcmpl-" + uuid.New().String()[:8],
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{},
+		"usage": map[string]any{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens + reasoningTokens,
+			"total_tokens":      promptTokens + completionTokens + reasoningTokens,
+			"completion_tokens_details": map[string]any{
+				"reasoning_tokens": reasoningTokens,
+			},
+		},
+	}
+	b, _ := json.Marshal(chunk)
+	return b
+}
+
+// buildOpenAIStreamFinishChunk builds the final OpenAI-compatible SSE chunk carrying
+// an empty delta and the given finish reason (e.g. "stop" or "tool_calls").
+func buildOpenAIStreamFinishChunk(model, finishReason string, index int) []byte {
+	chunk := map[string]any{
+		"id":      "chatcmpl-" + uuid.New().String()[:8],
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{
+			{
+				"index":         index,
+				"delta":         map[string]any{},
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	b, _ := json.Marshal(chunk)
+	return b
+}
+
+// jumaHTMLResponseErr returns a 503 when a successful Juma chat response is an HTML
+// page rather than an event stream, as during maintenance windows when Juma serves its
+// maintenance page with a 200 and the SSE scanner would find nothing.
+func jumaHTMLResponseErr(resp *http.Response) error {
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), "text/html") {
+		return nil
+	}
+	return statusErr{code: http.StatusServiceUnavailable, msg: "juma upstream unavailable"}
+}
+
+// newJumaStatusErr builds a statusErr for a non-2xx Juma response. The message is an
+// OpenAI-style error object rather than the raw body. Rate-limit responses are
+// normalized to 429 and carry the upstream retry delay when one is advertised.
+func newJumaStatusErr(statusCode int, header http.Header, body []byte, workspaceID string) statusErr {
+	code := gjson.GetBytes(body, "error.code").String()
+	if code == "" {
+		code = gjson.GetBytes(body, "code").String()
+	}
+	if isJumaWorkspaceForbidden(statusCode, body) {
+		msg := fmt.Sprintf("Juma session token lacks access to workspace %s; check the credential's workspace_id or clear it to use the session's default workspace", workspaceID)
+		return statusErr{code: http.StatusForbidden, msg: buildJumaErrorBody(http.StatusForbidden, msg, "workspace_forbidden")}
+	}
+	if statusCode != http.StatusTooManyRequests && code != "" && jumaErrorStatus(code, "") == http.StatusTooManyRequests {
+		statusCode = http.StatusTooManyRequests
+	}
+	err := statusErr{code: statusCode, msg: buildJumaErrorBody(statusCode, jumaErrorMessage(statusCode, body), code)}
+	if statusCode == http.StatusTooManyRequests {
+		err.retryAfter = parseJumaRetryAfter(header, body)
+	}
+	return err
+}
+
+// isJumaWorkspaceForbidden reports whether a Juma error is a 403 for a workspace the
+// session cannot access. An expired session answers 401 instead and is not matched.
+func isJumaWorkspaceForbidden(statusCode int, body []byte) bool {
+	if statusCode != http.StatusForbidden {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(body)), "workspace")
+}
+
+// jumaMaxErrorMessageLen bounds the upstream text echoed back to clients in errors.
+const jumaMaxErrorMessageLen = 512
+
+// jumaErrorMessage extracts a client-safe message from a Juma error body. Known JSON
+// shapes are preferred; HTML pages are replaced by a generic message and other text is
+// truncated. The raw body is left to the request logs.
+func jumaErrorMessage(statusCode int, body []byte) string {
+	fallback := fmt.Sprintf("juma upstream returned HTTP %d", statusCode)
+	if json.Valid(body) {
+		for _, path := range []string{"error.message", "message", "errorText", "error"} {
+			if v := gjson.GetBytes(body, path); v.Type == gjson.String {
+				if msg := strings.TrimSpace(v.String()); msg != "" {
+					return truncateJumaErrorMessage(msg)
+				}
+			}
+		}
+		return fallback
+	}
+	text := strings.TrimSpace(string(body))
+	if text == "" || strings.HasPrefix(text, "<") {
+		return fallback
+	}
+	return truncateJumaErrorMessage(text)
+}
+
+// truncateJumaErrorMessage shortens msg to jumaMaxErrorMessageLen bytes on a rune boundary.
+func truncateJumaErrorMessage(msg string) string {
+	if len(msg) <= jumaMaxErrorMessageLen {
+		return msg
+	}
+	cut := jumaMaxErrorMessageLen
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut] + "..."
+}
+
+// buildJumaErrorBody renders an OpenAI-style error object: {"error":{"message","type","code"}}.
+func buildJumaErrorBody(statusCode int, message, code string) string {
+	errType := "server_error"
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		errType = "authentication_error"
+	case statusCode == http.StatusForbidden:
+		errType = "permission_error"
+	case statusCode == http.StatusNotFound:
+		errType = "not_found_error"
+	case statusCode == http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case statusCode >= 400 && statusCode < 500:
+		errType = "invalid_request_error"
+	}
+	errObj := map[string]any{"message": message, "type": errType, "code": nil}
+	if code != "" {
+		errObj["code"] = code
+	}
+	b, _ := json.Marshal(map[string]any{"error": errObj})
+	return string(b)
+}
+
+// parseJumaRetryAfter extracts a retry delay from the Retry-After header (seconds or
+// HTTP date), the RateLimit-Reset/X-RateLimit-Reset headers, or a retryAfter body field.
+func parseJumaRetryAfter(header http.Header, body []byte) *time.Duration {
+	if v := strings.TrimSpace(header.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			d := time.Duration(secs) * time.Second
+			return &d
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			d := max(time.Until(at), 0)
+			return &d
+		}
+	}
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		secs, err := strconv.ParseInt(strings.TrimSpace(header.Get(name)), 10, 64)
+		if err != nil || secs < 0 {
+			continue
+		}
+		// Large values are absolute epoch seconds rather than a relative delay
+		if secs > 1_000_000_000 {
+			d := max(time.Until(time.Unix(secs, 0)), 0)
+			return &d
+		}
+		d := time.Duration(secs) * time.Second
+		return &d
+	}
+	for _, path := range []string{"retryAfter", "error.retryAfter"} {
+		if v := gjson.GetBytes(body, path); v.Exists() && v.Float() > 0 {
+			d := time.Duration(v.Float() * float64(time.Second))
+			return &d
+		}
+	}
+	return nil
+}
+
+// parseJumaErrorEvent converts a Juma SSE "error" event into a statusErr.
+// The message is taken from the first populated field among the shapes Juma has been
+// observed to emit, and known error codes are mapped to matching HTTP statuses.
+func parseJumaErrorEvent(data string) statusErr {
+	msg := ""
+	for _, path := range []string{"errorText", "error.message", "message", "error"} {
+		if v := gjson.Get(data, path); v.Exists() && v.Type == gjson.String {
+			if msg = strings.TrimSpace(v.String()); msg != "" {
+				break
+			}
+		}
+	}
+	if msg == "" {
+		msg = "juma upstream error"
+	}
+
+	code := ""
+	for _, path := range []string{"error.code", "code", "errorCode", "error.type"} {
+		if code = strings.TrimSpace(gjson.Get(data, path).String()); code != "" {
+			break
+		}
+	}
+
+	return statusErr{code: jumaErrorStatus(code, msg), msg: msg}
+}
+
+// jumaErrorStatus maps a Juma error code (or, failing that, its message) to an HTTP status.
+func jumaErrorStatus(code, msg string) int {
+	switch strings.ToLower(code) {
+	case "rate_limit", "rate_limited", "rate_limit_exceeded", "too_many_requests", "quota_exceeded":
+		return http.StatusTooManyRequests
+	case "content_policy", "content_policy_violation", "content_filter", "safety", "invalid_request", "context_length_exceeded":
+		return http.StatusBadRequest
+	case "unauthorized", "unauthenticated", "session_expired":
+		return http.StatusUnauthorized
+	case "forbidden":
+		return http.StatusForbidden
+	case "overloaded", "unavailable", "service_unavailable":
+		return http.StatusServiceUnavailable
+	}
+
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "rate limit") || strings.Contains(lower, "too many requests"):
+		return http.StatusTooManyRequests
+	case strings.Contains(lower, "content policy") || strings.Contains(lower, "safety"):
+		return http.StatusBadRequest
+	case strings.Contains(lower, "overloaded"):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
 }
 
 // transformGeneratedImageTags converts Juma's <generated-image> tags to standard Markdown image format.
@@ -846,15 +3710,180 @@ func transformGeneratedImageTags(content string) string {
 	return re.ReplaceAllString(content, "![Generated Image]($1)")
 }
 
-// buildOpenAIImageResponse builds an OpenAI-compatible image generation response.
-func buildOpenAIImageResponse(imageURL string) []byte {
+// buildOpenAIImageB64Response builds an OpenAI-compatible image generation response
+// carrying the image inline as b64_json.
+func buildOpenAIImageB64Response(b64s ...string) []byte {
+	data := make([]map[string]any, 0, len(b64s))
+	for _, b64 := range b64s {
+		data = append(data, map[string]any{"b64_json": b64})
+	}
 	resp := map[string]any{
 		"created": time.Now().Unix(),
-		"data": []map[string]any{
-			{
-				"url": imageURL,
-			},
-		},
+		"data":    data,
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+// isJumaImageGenerationRequest reports whether payload has the OpenAI
+// /v1/images/generations shape ({"prompt": "...", "n": 1, "size": "..."}) rather than
+// a chat completion.
+func isJumaImageGenerationRequest(payload []byte) bool {
+	return gjson.GetBytes(payload, "prompt").Type == gjson.String && !gjson.GetBytes(payload, "messages").Exists()
+}
+
+// executeImageGeneration serves an images-generation request by running one chat
+// request per requested image against an image-capable model, concurrently, and merging
+// the results in order.
+func (e *JumaExecutor) executeImageGeneration(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	chatReq, n, err := prepareJumaImageGeneration(req)
+	if err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+	internalusage.IncExecutorCounter(internalusage.MetricExecutorImageGenerations, "juma", req.Model)
+
+	images := make([]gjson.Result, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			images[i], errs[i] = e.generateJumaImage(ctx, auth, chatReq, opts)
+		}(i)
+	}
+	wg.Wait()
+
+	data := make([]json.RawMessage, 0, n)
+	for i, image := range images {
+		if errs[i] != nil {
+			return cliproxyexecutor.Response{}, errs[i]
+		}
+		data = append(data, json.RawMessage(image.Raw))
+	}
+
+	out, err := json.Marshal(map[string]any{"created": time.Now().Unix(), "data": data})
+	if err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+	return cliproxyexecutor.Response{Payload: out}, nil
+}
+
+// executeImageGenerationStream serves a streaming images-generation request. The images
+// are generated concurrently and each is relayed as its own "image_generation.completed"
+// event as soon as its chat request finishes, so events arrive in completion order. The
+// first error ends the stream and cancels the remaining generations. Juma does not expose intermediate renders, so no partial_image events are
+// sent; clients keep every completed event, so n > 1 yields n images.
+func (e *JumaExecutor) executeImageGenerationStream(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (<-chan cliproxyexecutor.StreamChunk, error) {
+	chatReq, n, err := prepareJumaImageGeneration(req)
+	if err != nil {
+		return nil, err
+	}
+	internalusage.IncExecutorCounter(internalusage.MetricExecutorImageGenerations, "juma", req.Model)
+
+	genCtx, cancel := context.WithCancel(ctx)
+	out := make(chan cliproxyexecutor.StreamChunk)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		// Buffered so generations finishing after an early return never block.
+		results := make(chan cliproxyexecutor.StreamChunk, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				image, errGen := e.generateJumaImage(genCtx, auth, chatReq, opts)
+				chunk := cliproxyexecutor.StreamChunk{Err: errGen}
+				if errGen == nil {
+					chunk.Payload = buildOpenAIImageCompletedEvent(image)
+				}
+				results <- chunk
+			}()
+		}
+		for i := 0; i < n; i++ {
+			chunk := <-results
+			// Stop as soon as the client is gone instead of blocking on out forever.
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+			if chunk.Err != nil {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// prepareJumaImageGeneration validates an images-generation payload and rewrites it as a
+// single-turn chat request, keeping the image hints. It returns the chat request and the
+// number of images to generate.
+func prepareJumaImageGeneration(req cliproxyexecutor.Request) (cliproxyexecutor.Request, int, error) {
+	model := getJumaModelByAlias(req.Model)
+	if model == nil || !model.ImageCapable {
+		return req, 0, statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("model %s does not support image generation", req.Model)}
+	}
+	prompt := strings.TrimSpace(gjson.GetBytes(req.Payload, "prompt").String())
+	if prompt == "" {
+		return req, 0, statusErr{code: http.StatusBadRequest, msg: "prompt is required"}
+	}
+	n := int(gjson.GetBytes(req.Payload, "n").Int())
+	if n < 1 {
+		n = 1
+	}
+	if n > jumaMaxImageGenerations {
+		return req, 0, statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("n must be between 1 and %d", jumaMaxImageGenerations)}
+	}
+
+	chatPayload, _ := sjson.SetBytes([]byte(`{}`), "messages", []map[string]string{{"role": "user", "content": prompt}})
+	for _, field := range []string{"size", "orientation", "aspect_ratio", "response_format"} {
+		if v := gjson.GetBytes(req.Payload, field); v.Exists() {
+			chatPayload, _ = sjson.SetRawBytes(chatPayload, field, []byte(v.Raw))
+		}
+	}
+	chatReq := req
+	chatReq.Payload = chatPayload
+	return chatReq, n, nil
+}
+
+// generateJumaImage runs one image chat request and returns its first image data entry.
+func (e *JumaExecutor) generateJumaImage(ctx context.Context, auth *cliproxyauth.Auth, chatReq cliproxyexecutor.Request, opts cliproxyexecutor.Options) (gjson.Result, error) {
+	chatResp, err := e.Execute(ctx, auth, chatReq, opts)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	image := gjson.GetBytes(chatResp.Payload, "data.0")
+	if !image.Exists() {
+		if refusal := strings.TrimSpace(gjson.GetBytes(chatResp.Payload, "choices.0.message.content").String()); refusal != "" {
+			return gjson.Result{}, statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("model declined to generate an image: %s", truncateJumaErrorMessage(refusal))}
+		}
+		return gjson.Result{}, statusErr{code: http.StatusBadGateway, msg: "juma did not return a generated image"}
+	}
+	return image, nil
+}
+
+// buildOpenAIImageCompletedEvent wraps an image data entry (url or b64_json) in an
+// OpenAI "image_generation.completed" streaming event.
+func buildOpenAIImageCompletedEvent(image gjson.Result) []byte {
+	event, _ := sjson.SetBytes([]byte(`{}`), "type", "image_generation.completed")
+	event, _ = sjson.SetBytes(event, "created_at", time.Now().Unix())
+	image.ForEach(func(key, value gjson.Result) bool {
+		event, _ = sjson.SetRawBytes(event, key.String(), []byte(value.Raw))
+		return true
+	})
+	return event
+}
+
+// buildOpenAIImageResponse builds an OpenAI-compatible image generation response
+// with one data entry per image URL.
+func buildOpenAIImageResponse(imageURLs ...string) []byte {
+	data := make([]map[string]any, 0, len(imageURLs))
+	for _, imageURL := range imageURLs {
+		data = append(data, map[string]any{"url": imageURL})
+	}
+	resp := map[string]any{
+		"created": time.Now().Unix(),
+		"data":    data,
 	}
 	b, _ := json.Marshal(resp)
 	return b
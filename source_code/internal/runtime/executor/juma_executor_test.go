@@ -0,0 +1,290 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+func TestJumaFinishReason(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "natural stop", data: `{"type":"finish","finishReason":"stop"}`, want: "stop"},
+		{name: "anthropic end turn", data: `{"type":"finish-step","finishReason":"end_turn"}`, want: "stop"},
+		{name: "length", data: `{"type":"finish","finishReason":"length"}`, want: "length"},
+		{name: "max tokens", data: `{"type":"finish","stopReason":"max_tokens"}`, want: "length"},
+		{name: "content filter", data: `{"type":"finish","finishReason":"content-filter"}`, want: "content_filter"},
+		{name: "safety", data: `{"type":"finish","finish_reason":"SAFETY"}`, want: "content_filter"},
+		{name: "tool calls", data: `{"type":"finish-step","finishReason":"tool-calls"}`, want: "tool_calls"},
+		{name: "message metadata", data: `{"type":"finish","messageMetadata":{"finishReason":"length"}}`, want: "length"},
+		{name: "unknown reason", data: `{"type":"finish","finishReason":"other"}`, want: ""},
+		{name: "no reason", data: `{"type":"finish"}`, want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := jumaFinishReason(tc.data); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveJumaModelAlias(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.AliasMap = map[string]string{"gpt-5.1": "juma-gpt-5.1"}
+	cases := map[string]string{
+		"gpt-5.1":         "juma-gpt-5.1",
+		"GPT-5.1":         "juma-gpt-5.1",
+		"juma-gpt-5.1":    "juma-gpt-5.1",
+		"claude-opus-4.5": "claude-opus-4.5",
+	}
+	for name, want := range cases {
+		if got := ResolveJumaModelAlias(cfg, name); got != want {
+			t.Errorf("ResolveJumaModelAlias(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestListJumaModels_ReturnsDefensiveCopy(t *testing.T) {
+	models := ListJumaModels()
+	if len(models) == 0 || len(models) != len(JumaModelAliases()) {
+		t.Fatalf("expected one alias per model, got %d models and %d aliases", len(models), len(JumaModelAliases()))
+	}
+	original := models[0].Alias
+	models[0].Alias = "mutated"
+	for i := range models {
+		if len(models[i].Tools) > 0 {
+			models[i].Tools[0].Function.Parameters["mutated"] = true
+		}
+	}
+	if JumaModelAliases()[0] != original {
+		t.Errorf("mutating the returned models changed the package table")
+	}
+	for _, model := range ListJumaModels() {
+		for _, tool := range model.Tools {
+			if _, ok := tool.Function.Parameters["mutated"]; ok {
+				t.Errorf("mutating returned tool parameters changed the package table")
+			}
+		}
+	}
+}
+
+func TestCapJumaOutput(t *testing.T) {
+	cases := []struct {
+		name          string
+		text          string
+		used, limit   int
+		want          string
+		wantTruncated bool
+	}{
+		{name: "unlimited", text: "hello", limit: 0, want: "hello"},
+		{name: "within cap", text: "hello", used: 2, limit: 10, want: "hello"},
+		{name: "exactly at cap", text: "hello", used: 5, limit: 10, want: "hello"},
+		{name: "cut at cap", text: "héllo wörld", used: 3, limit: 8, want: "héllo", wantTruncated: true},
+		{name: "cap already reached", text: "more", used: 8, limit: 8, want: "", wantTruncated: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, truncated := capJumaOutput(tc.text, tc.used, tc.limit)
+			if got != tc.want || truncated != tc.wantTruncated {
+				t.Errorf("capJumaOutput(%q, %d, %d) = %q, %v", tc.text, tc.used, tc.limit, got, truncated)
+			}
+		})
+	}
+}
+
+func TestConvertToJumaMessages_ReferencesImageByID(t *testing.T) {
+	const imageID = "0b6f3c1e-8a2d-4f5b-9c7e-1d2a3b4c5d6e"
+	payload := []byte(`{"messages":[{"role":"user","content":[{"type":"text","text":"make it blue"},{"type":"image","image_id":"` + imageID + `"}]}]}`)
+	result := convertToJumaMessages(&config.Config{}, payload, nil, "", "", nil, false)
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	if len(result.UploadedImages) != 1 || result.UploadedImages[0].ID != imageID {
+		t.Fatalf("expected the referenced image to be attached, got %+v", result.UploadedImages)
+	}
+	if len(result.KnowledgeItems) != 1 || result.KnowledgeItems[0]["id"] != imageID {
+		t.Errorf("expected the referenced image in knowledgeItems, got %+v", result.KnowledgeItems)
+	}
+
+	invalid := []byte(`{"messages":[{"role":"user","content":[{"type":"image","image_id":"not-a-uuid"}]}]}`)
+	if result := convertToJumaMessages(&config.Config{}, invalid, nil, "", "", nil, false); result.Err == nil {
+		t.Error("expected an invalid image_id to be rejected")
+	}
+}
+
+func TestNewJumaStatusErr_WorkspaceForbidden(t *testing.T) {
+	const workspaceID = "0b6f3c1e-8a2d-4f5b-9c7e-1d2a3b4c5d6e"
+	err := newJumaStatusErr(http.StatusForbidden, http.Header{}, []byte(`{"error":{"message":"You do not have access to this workspace","code":"FORBIDDEN"}}`), workspaceID)
+	if err.code != http.StatusForbidden || !strings.Contains(err.msg, "lacks access to workspace "+workspaceID) {
+		t.Errorf("expected a workspace access error, got %d %s", err.code, err.msg)
+	}
+
+	expired := newJumaStatusErr(http.StatusUnauthorized, http.Header{}, []byte(`{"error":{"message":"Session expired"}}`), workspaceID)
+	if expired.code != http.StatusUnauthorized || strings.Contains(expired.msg, "workspace") {
+		t.Errorf("expected an expired session to stay a plain 401, got %d %s", expired.code, expired.msg)
+	}
+}
+
+func TestNormalizeJumaSystemMessages_PrefixSuffix(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.SystemPromptPrefix = "Be brief."
+	cfg.Juma.SystemPromptSuffix = "Use markdown."
+	user := JumaMessage{Role: "user", Content: "hi"}
+
+	got := normalizeJumaSystemMessages(cfg, []JumaMessage{user}, "")
+	if len(got) != 2 || got[0].Role != "system" || got[0].Content != "Be brief.\n\nUse markdown." {
+		t.Fatalf("expected a created system message, got %+v", got)
+	}
+
+	forced := "Always call ImageEdit."
+	messages := []JumaMessage{{Role: "system", Content: forced}, {Role: "system", Content: "Client rules."}, user}
+	got = normalizeJumaSystemMessages(cfg, messages, forced)
+	want := "Always call ImageEdit.\n\nBe brief.\n\nClient rules.\n\nUse markdown."
+	if got[0].Content != want {
+		t.Errorf("expected %q, got %q", want, got[0].Content)
+	}
+}
+
+func TestJumaHTMLResponseErr(t *testing.T) {
+	cases := map[string]bool{
+		"text/html; charset=utf-8": true,
+		"TEXT/HTML":                true,
+		"text/event-stream":        false,
+		"":                         false,
+	}
+	for contentType, wantErr := range cases {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{contentType}}}
+		err := jumaHTMLResponseErr(resp)
+		if (err != nil) != wantErr {
+			t.Errorf("Content-Type %q: expected error %v, got %v", contentType, wantErr, err)
+			continue
+		}
+		if se, ok := err.(statusErr); ok && se.code != http.StatusServiceUnavailable {
+			t.Errorf("Content-Type %q: expected status 503, got %d", contentType, se.code)
+		}
+	}
+}
+
+func TestPickJumaSessionToken_RotatesAndSkipsThrottled(t *testing.T) {
+	auth := &cliproxyauth.Auth{Attributes: map[string]string{
+		"session_token":  "pick-test-a, pick-test-b",
+		"session_tokens": "pick-test-b,pick-test-c",
+	}}
+	tokens := jumaSessionTokens(auth)
+	if strings.Join(tokens, ",") != "pick-test-a,pick-test-b,pick-test-c" {
+		t.Fatalf("unexpected tokens %v", tokens)
+	}
+
+	seen := make(map[string]bool)
+	for range tokens {
+		seen[pickJumaSessionToken(tokens)] = true
+	}
+	if len(seen) != len(tokens) {
+		t.Errorf("expected every token to be used once, got %v", seen)
+	}
+
+	markJumaSessionThrottled("pick-test-a", nil)
+	markJumaSessionThrottled("pick-test-b", nil)
+	for i := 0; i < 3; i++ {
+		if got := pickJumaSessionToken(tokens); got != "pick-test-c" {
+			t.Fatalf("expected the only unthrottled token, got %q", got)
+		}
+	}
+}
+
+func TestJumaThreadContinuation(t *testing.T) {
+	ctx := context.Background()
+	auth := &cliproxyauth.Auth{ID: "thread-continuation-test"}
+	payload := []byte(`{"conversation_id":"conv-1"}`)
+	const firstThread = "5f0c7e4a-1b2c-4d3e-8f9a-0b1c2d3e4f5a"
+
+	first := &JumaRequest{ThreadID: firstThread, IsNewThread: true, Messages: []JumaMessage{{ID: "u1", Role: "user"}}}
+	if err := applyJumaThreadContinuation(ctx, auth, first, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !first.IsNewThread {
+		t.Fatal("expected an unknown conversation to start a new thread")
+	}
+	if _, ok := getJumaThread(jumaConversationKey(auth, "conv-1")); ok {
+		t.Fatal("expected nothing to be recorded before Juma answered")
+	}
+	body, _ := json.Marshal(first)
+	rememberJumaThread(ctx, auth, payload, body, "a1")
+
+	second := &JumaRequest{ThreadID: "new", IsNewThread: true, Messages: []JumaMessage{{ID: "x1", Role: "user"}, {ID: "x2", Role: "assistant"}, {ID: "x3", Role: "user"}}}
+	if err := applyJumaThreadContinuation(ctx, auth, second, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.IsNewThread || second.ThreadID != firstThread {
+		t.Fatalf("expected the recorded thread to continue, got %q (new=%v)", second.ThreadID, second.IsNewThread)
+	}
+	if got := []string{second.Messages[0].ID, second.Messages[1].ID, second.Messages[2].ID}; got[0] != "u1" || got[1] != "a1" || got[2] != "x3" {
+		t.Errorf("expected the prior message and reply IDs to be reused, got %v", got)
+	}
+	body, _ = json.Marshal(second)
+	rememberJumaThread(ctx, auth, payload, body, "a2")
+
+	regenerate := []byte(`{"conversation_id":"conv-1","regenerate":true}`)
+	third := &JumaRequest{ThreadID: "new", IsNewThread: true, Messages: []JumaMessage{{ID: "y1", Role: "user"}, {ID: "y2", Role: "assistant"}, {ID: "y3", Role: "user"}, {ID: "y4", Role: "assistant"}}}
+	if err := applyJumaThreadContinuation(ctx, auth, third, regenerate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third.Trigger != "regenerate-message" || third.MessageID != "a2" || len(third.Messages) != 3 {
+		t.Fatalf("expected the last reply a2 to be regenerated, got trigger %q, message %q, %d messages", third.Trigger, third.MessageID, len(third.Messages))
+	}
+	body, _ = json.Marshal(third)
+	rememberJumaThread(ctx, auth, regenerate, body, "")
+	entry, ok := getJumaThread(jumaConversationKey(auth, "conv-1"))
+	if !ok || strings.Join(entry.MessageIDs, ",") != "u1,a1,x3,a2" {
+		t.Errorf("expected the regenerated reply to keep its ID, got %+v", entry)
+	}
+
+	fresh := &JumaRequest{ThreadID: "new", IsNewThread: true, Messages: []JumaMessage{{ID: "z1", Role: "user"}}}
+	if err := applyJumaThreadContinuation(ctx, auth, fresh, []byte(`{"conversation_id":"conv-2","regenerate":true}`)); err == nil {
+		t.Error("expected regenerate without a known thread to fail")
+	}
+}
+
+func TestAcquireJumaSlot_LimitChangeAndForget(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.MaxConcurrentRequests = 2
+	cfg.Juma.QueueTimeout = -1
+	auth := &cliproxyauth.Auth{ID: "slots-test"}
+	e := NewJumaExecutor(cfg)
+	defer e.ForgetAuth(auth.ID)
+
+	first, err := acquireJumaSlot(context.Background(), cfg, auth)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if _, err = acquireJumaSlot(context.Background(), cfg, auth); err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+
+	// Lowering the limit must still count the requests already in flight.
+	cfg.Juma.MaxConcurrentRequests = 1
+	if _, err = acquireJumaSlot(context.Background(), cfg, auth); err == nil {
+		t.Fatal("expected busy error after lowering the limit")
+	}
+	first()
+	if _, err = acquireJumaSlot(context.Background(), cfg, auth); err == nil {
+		t.Fatal("expected busy error while one request is still in flight")
+	}
+
+	e.ForgetAuth(auth.ID)
+	jumaAuthSlots.Lock()
+	_, ok := jumaAuthSlots.byAuth[auth.ID]
+	jumaAuthSlots.Unlock()
+	if ok {
+		t.Fatal("expected slots to be dropped for the removed auth")
+	}
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/jumaclient"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+// HTTPDoer is the part of *http.Client the Juma executor and its upload helpers use.
+// Tests substitute a stub that serves recorded responses instead of hitting the network.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// JumaHTTPClientFactory builds the client for one Juma upstream call. A zero timeout
+// means no client-side timeout.
+type JumaHTTPClientFactory func(ctx context.Context, cfg *config.Config, auth *cliproxyauth.Auth, timeout time.Duration) HTTPDoer
+
+// SetHTTPClientFactory replaces the client factory used for Juma chat, session and
+// workspace requests. Passing nil restores the proxy-aware default.
+func (e *JumaExecutor) SetHTTPClientFactory(factory JumaHTTPClientFactory) {
+	e.clientFactory = factory
+}
+
+// httpClient returns the client for one upstream call, honouring an injected factory.
+func (e *JumaExecutor) httpClient(ctx context.Context, auth *cliproxyauth.Auth, timeout time.Duration) HTTPDoer {
+	if e.clientFactory != nil {
+		return e.clientFactory(ctx, e.cfg, auth, timeout)
+	}
+	return newProxyAwareHTTPClient(ctx, e.cfg, auth, timeout)
+}
+
+// newJumaClient returns a jumaclient.Client for one session, configured from the juma
+// section of cfg. The User-Agent is picked per client, so juma.user-agents rotates
+// across calls.
+func newJumaClient(cfg *config.Config, httpClient HTTPDoer, sessionToken, workspaceID string) *jumaclient.Client {
+	client := jumaclient.New(httpClient, sessionToken, workspaceID)
+	client.BaseURL = jumaBaseURLFor(cfg)
+	client.UserAgent = jumaUserAgent(cfg)
+	client.Locale = jumaLocale(cfg)
+	client.TRPCHeaders = jumaTRPCHeaders(cfg)
+	client.PresignedURLPath = jumaPresignedURLPath(cfg)
+	return client
+}
+
+// newJumaUploadClient builds the client used by UploadImageToJuma, UploadBase64Image and
+// the remote image fetches behind them. Tests replace it to stub the network.
+var newJumaUploadClient = func(timeout time.Duration) HTTPDoer {
+	return &http.Client{Timeout: timeout}
+}
+
+// newImageHostingClient returns the client used to post images to the image host.
+// Tests replace it to stub the network.
+var newImageHostingClient = func(cfg *config.Config) (HTTPDoer, error) {
+	return imageHostingHTTPClient(cfg)
+}
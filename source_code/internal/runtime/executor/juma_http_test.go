@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// jumaStubDoer replays recorded responses in order and keeps the request bodies it saw.
+type jumaStubDoer struct {
+	responses []*http.Response
+	bodies    []string
+}
+
+func (d *jumaStubDoer) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	d.bodies = append(d.bodies, string(body))
+	resp := d.responses[0]
+	d.responses = d.responses[1:]
+	resp.Request = req
+	return resp, nil
+}
+
+func jumaStubResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+func TestDoJumaRequest_RetriesTransientStatus(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.RetryBackoff = 1
+	doer := &jumaStubDoer{responses: []*http.Response{
+		jumaStubResponse(http.StatusServiceUnavailable, "busy"),
+		jumaStubResponse(http.StatusOK, "ok"),
+	}}
+	reqBody := []byte(`{"message":"hi"}`)
+	httpReq, err := http.NewRequest(http.MethodPost, "https://juma.invalid/api/chat", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := doJumaRequest(context.Background(), cfg, doer, httpReq, reqBody, "juma-test", log.NewEntry(log.StandardLogger()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+	if len(doer.bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(doer.bodies))
+	}
+	for i, body := range doer.bodies {
+		if body != string(reqBody) {
+			t.Errorf("attempt %d sent body %q", i+1, body)
+		}
+	}
+}
+
+func TestDoJumaRequest_RetryReplaysCompressedBody(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.RetryBackoff = 1
+	cfg.Juma.GzipRequestThreshold = 8
+	reqBody := []byte(`{"message":"a body long enough to compress"}`)
+	wireBody, compressed, err := compressJumaRequestBody(cfg, reqBody)
+	if err != nil || !compressed {
+		t.Fatalf("expected body to be compressed, got compressed=%v err=%v", compressed, err)
+	}
+	doer := &jumaStubDoer{responses: []*http.Response{
+		jumaStubResponse(http.StatusBadGateway, "busy"),
+		jumaStubResponse(http.StatusOK, "ok"),
+	}}
+	httpReq, err := http.NewRequest(http.MethodPost, "https://juma.invalid/api/chat", bytes.NewReader(wireBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := doJumaRequest(context.Background(), cfg, doer, httpReq, reqBody, "juma-test", log.NewEntry(log.StandardLogger()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	for i, body := range doer.bodies {
+		if body != string(wireBody) {
+			t.Errorf("attempt %d did not resend the compressed body", i+1)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// jumaAsyncHeader asks for an images-generation request to run as a background job.
+	jumaAsyncHeader = "X-Juma-Async"
+	// jumaJobHeader carries the job ID on image job callbacks.
+	jumaJobHeader = "X-Juma-Job-Id"
+
+	jumaDefaultMaxImageJobs     = 100
+	jumaImageJobCallbackTimeout = 30 * time.Second
+)
+
+// Image job states reported by LookupJumaImageJob.
+const (
+	JumaImageJobInProgress = "in_progress"
+	JumaImageJobCompleted  = "completed"
+	JumaImageJobFailed     = "failed"
+)
+
+// JumaImageJob is the state of an asynchronous image generation. Result holds the OpenAI
+// images response once the job has completed.
+type JumaImageJob struct {
+	ID          string          `json:"id"`
+	Object      string          `json:"object"`
+	Model       string          `json:"model"`
+	Status      string          `json:"status"`
+	Created     int64           `json:"created"`
+	CompletedAt int64           `json:"completed_at,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// jumaImageJobs is the bounded in-memory job table; order lists IDs oldest first.
+var jumaImageJobs = struct {
+	sync.Mutex
+	jobs  map[string]*JumaImageJob
+	order []string
+}{jobs: make(map[string]*JumaImageJob)}
+
+// LookupJumaImageJob returns a snapshot of the image job with the given ID.
+func LookupJumaImageJob(id string) (JumaImageJob, bool) {
+	jumaImageJobs.Lock()
+	defer jumaImageJobs.Unlock()
+	job, ok := jumaImageJobs.jobs[id]
+	if !ok {
+		return JumaImageJob{}, false
+	}
+	return *job, true
+}
+
+// isJumaAsyncImageRequest reports whether the client asked for a background image job,
+// through the X-Juma-Async header or an "async": true payload field.
+func isJumaAsyncImageRequest(ctx context.Context, payload []byte) bool {
+	if gjson.GetBytes(payload, "async").Bool() {
+		return true
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil || ginCtx.Request == nil {
+		return false
+	}
+	async, _ := strconv.ParseBool(strings.TrimSpace(ginCtx.Request.Header.Get(jumaAsyncHeader)))
+	return async
+}
+
+// registerJumaImageJob adds an in-progress job to the table. When the table is full the
+// oldest finished job is evicted; if every job is still running the request is refused.
+func registerJumaImageJob(cfg *config.Config, model string) (JumaImageJob, error) {
+	limit := jumaDefaultMaxImageJobs
+	if cfg != nil && cfg.Juma.ImageJobs.MaxJobs > 0 {
+		limit = cfg.Juma.ImageJobs.MaxJobs
+	}
+
+	jumaImageJobs.Lock()
+	defer jumaImageJobs.Unlock()
+	for len(jumaImageJobs.order) >= limit {
+		evicted := false
+		for i, id := range jumaImageJobs.order {
+			if jumaImageJobs.jobs[id].Status != JumaImageJobInProgress {
+				delete(jumaImageJobs.jobs, id)
+				jumaImageJobs.order = append(jumaImageJobs.order[:i], jumaImageJobs.order[i+1:]...)
+				evicted = true
+				break
+			}
+		}
+		if !evicted {
+			retryAfter := 30 * time.Second
+			return JumaImageJob{}, statusErr{code: http.StatusTooManyRequests, msg: fmt.Sprintf("too many image jobs in progress (limit %d)", limit), retryAfter: &retryAfter}
+		}
+	}
+
+	job := &JumaImageJob{
+		ID:      "imgjob_" + uuid.New().String(),
+		Object:  "image.generation.job",
+		Model:   model,
+		Status:  JumaImageJobInProgress,
+		Created: time.Now().Unix(),
+	}
+	jumaImageJobs.jobs[job.ID] = job
+	jumaImageJobs.order = append(jumaImageJobs.order, job.ID)
+	return *job, nil
+}
+
+// finishJumaImageJob records the outcome of a job and returns the updated snapshot.
+func finishJumaImageJob(id string, result []byte, err error) JumaImageJob {
+	jumaImageJobs.Lock()
+	defer jumaImageJobs.Unlock()
+	job, ok := jumaImageJobs.jobs[id]
+	if !ok {
+		return JumaImageJob{ID: id}
+	}
+	job.CompletedAt = time.Now().Unix()
+	if err != nil {
+		job.Status = JumaImageJobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JumaImageJobCompleted
+		job.Result = json.RawMessage(result)
+	}
+	return *job
+}
+
+// submitImageGenerationJob validates an images-generation request, starts it in the
+// background and returns the job record immediately. The job runs detached from the
+// client request, so request-scoped headers such as vendor overrides do not apply to it.
+// On completion the images response is POSTed to juma.image-jobs.callback-url, if set.
+func (e *JumaExecutor) submitImageGenerationJob(ctx context.Context, auth *cliproxyauth.Auth, req cliproxyexecutor.Request, opts cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	if _, _, err := prepareJumaImageGeneration(req); err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+	job, err := registerJumaImageJob(e.cfg, req.Model)
+	if err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+
+	jobReq := req
+	jobReq.Payload, _ = sjson.DeleteBytes(req.Payload, "async")
+	jobCtx := context.Background()
+	if rt, ok := ctx.Value("cliproxy.roundtripper").(http.RoundTripper); ok && rt != nil {
+		jobCtx = context.WithValue(jobCtx, "cliproxy.roundtripper", rt)
+	}
+
+	go func() {
+		resp, errGen := e.executeImageGeneration(jobCtx, auth, jobReq, opts)
+		finished := finishJumaImageJob(job.ID, resp.Payload, errGen)
+		jumaLogEntry(log.Fields{"job_id": job.ID, "status": finished.Status}).Info("juma executor: image job finished")
+		e.notifyImageJobCallback(jobCtx, finished)
+	}()
+
+	out, err := json.Marshal(job)
+	if err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+	return cliproxyexecutor.Response{Payload: out}, nil
+}
+
+// notifyImageJobCallback POSTs a finished job to the configured callback URL: the OpenAI
+// images response on success, or an OpenAI-style error object on failure. The job ID is
+// sent in the X-Juma-Job-Id header. Delivery is attempted once; failures are logged.
+func (e *JumaExecutor) notifyImageJobCallback(ctx context.Context, job JumaImageJob) {
+	if e.cfg == nil || e.cfg.Juma.ImageJobs.CallbackURL == "" {
+		return
+	}
+	body := []byte(job.Result)
+	if job.Status != JumaImageJobCompleted {
+		body, _ = json.Marshal(map[string]any{
+			"error": map[string]any{"message": job.Error, "type": "image_generation_error"},
+		})
+	}
+
+	entry := jumaLogEntry(log.Fields{"job_id": job.ID})
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Juma.ImageJobs.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		entry.WithError(err).Warn("juma executor: build image job callback failed")
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(jumaJobHeader, job.ID)
+
+	httpResp, err := e.httpClient(ctx, nil, jumaImageJobCallbackTimeout).Do(httpReq)
+	if err != nil {
+		entry.WithError(err).Warn("juma executor: image job callback failed")
+		return
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<16))
+		entry.WithField("status", httpResp.StatusCode).Warnf("juma executor: image job callback rejected: %s", strings.TrimSpace(string(b)))
+	}
+}
@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// jumaDefaultModerationEndpoint is the moderation API used when juma.moderation.endpoint is empty.
+	jumaDefaultModerationEndpoint = "https://api.openai.com/v1/moderations"
+	// jumaDefaultModerationModel is the moderation model used when juma.moderation.model is empty.
+	jumaDefaultModerationModel = "omni-moderation-latest"
+	// jumaDefaultModerationTimeout bounds a moderation call when juma.moderation.timeout is unset.
+	jumaDefaultModerationTimeout = 10 * time.Second
+)
+
+// JumaModerationInput is what a moderator sees of a request: the converted messages
+// about to be sent to Juma and the images uploaded for them.
+type JumaModerationInput struct {
+	Model          string
+	Messages       []JumaMessage
+	UploadedImages []JumaUploadedImage
+}
+
+// JumaModerationVerdict is a moderator's decision. Reason is returned to the client
+// when Flagged is set.
+type JumaModerationVerdict struct {
+	Flagged bool
+	Reason  string
+}
+
+// JumaModerator checks a request before it is forwarded to Juma. An error means the
+// check itself failed; juma.moderation.fail-closed decides whether the request proceeds.
+type JumaModerator interface {
+	Moderate(ctx context.Context, input JumaModerationInput) (JumaModerationVerdict, error)
+}
+
+// SetModerator installs the moderator run when juma.moderation.enable is set. Passing
+// nil restores the built-in OpenAI-compatible moderator. SDK users set it through
+// cliproxy.Builder.WithJumaModerator, which the service applies to every Juma executor
+// it creates.
+func (e *JumaExecutor) SetModerator(moderator JumaModerator) {
+	e.moderator = moderator
+}
+
+// moderateJumaRequest runs the configured moderator over the converted request and
+// returns a 403 when it is flagged.
+func (e *JumaExecutor) moderateJumaRequest(ctx context.Context, input JumaModerationInput) error {
+	if e.cfg == nil || !e.cfg.Juma.Moderation.Enable {
+		return nil
+	}
+	moderator := e.moderator
+	if moderator == nil {
+		moderator = &openAIJumaModerator{cfg: e.cfg}
+	}
+
+	verdict, err := moderator.Moderate(ctx, input)
+	if err != nil {
+		if e.cfg.Juma.Moderation.FailClosed {
+			jumaLogEntry(log.Fields{"model": input.Model}).WithError(err).Warn("juma executor: moderation check failed, rejecting request")
+			return statusErr{code: http.StatusServiceUnavailable, msg: "content moderation is unavailable"}
+		}
+		jumaLogEntry(log.Fields{"model": input.Model}).WithError(err).Warn("juma executor: moderation check failed, forwarding request unchecked")
+		return nil
+	}
+	if !verdict.Flagged {
+		return nil
+	}
+
+	jumaLogEntry(log.Fields{"model": input.Model, "reason": verdict.Reason}).Info("juma executor: request rejected by moderation")
+	msg := "request rejected by content moderation"
+	if verdict.Reason != "" {
+		msg += ": " + verdict.Reason
+	}
+	return statusErr{code: http.StatusForbidden, msg: msg}
+}
+
+// openAIJumaModerator checks requests against an OpenAI-compatible /v1/moderations endpoint.
+type openAIJumaModerator struct {
+	cfg *config.Config
+}
+
+// Moderate submits the text of every user message, and the uploaded image URLs when
+// juma.moderation.include-images is set, in a single moderation call.
+func (m *openAIJumaModerator) Moderate(ctx context.Context, input JumaModerationInput) (JumaModerationVerdict, error) {
+	settings := m.cfg.Juma.Moderation
+	var parts []map[string]any
+	for _, msg := range input.Messages {
+		if msg.Role != "user" {
+			continue
+		}
+		text := msg.Content
+		if text == "" {
+			var texts []string
+			for _, part := range msg.Parts {
+				if part.Type == "text" && part.Text != "" {
+					texts = append(texts, part.Text)
+				}
+			}
+			text = strings.Join(texts, "\n")
+		}
+		if strings.TrimSpace(text) != "" {
+			parts = append(parts, map[string]any{"type": "text", "text": text})
+		}
+	}
+	if settings.IncludeImages {
+		for _, image := range input.UploadedImages {
+			if image.ImageURL != "" {
+				parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": image.ImageURL}})
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return JumaModerationVerdict{}, nil
+	}
+
+	model := settings.Model
+	if model == "" {
+		model = jumaDefaultModerationModel
+	}
+	body, err := json.Marshal(map[string]any{"model": model, "input": parts})
+	if err != nil {
+		return JumaModerationVerdict{}, err
+	}
+
+	endpoint := settings.Endpoint
+	if endpoint == "" {
+		endpoint = jumaDefaultModerationEndpoint
+	}
+	timeout := jumaDefaultModerationTimeout
+	if settings.Timeout > 0 {
+		timeout = time.Duration(settings.Timeout) * time.Second
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return JumaModerationVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if settings.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.APIKey)
+	}
+
+	resp, err := newJumaUploadClient(0).Do(req)
+	if err != nil {
+		return JumaModerationVerdict{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return JumaModerationVerdict{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return JumaModerationVerdict{}, fmt.Errorf("moderation request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	results := gjson.GetBytes(respBody, "results")
+	if !results.IsArray() {
+		return JumaModerationVerdict{}, fmt.Errorf("moderation response has no results")
+	}
+	var verdict JumaModerationVerdict
+	categories := make(map[string]struct{})
+	for _, result := range results.Array() {
+		if !result.Get("flagged").Bool() {
+			continue
+		}
+		verdict.Flagged = true
+		result.Get("categories").ForEach(func(key, value gjson.Result) bool {
+			if value.Bool() {
+				categories[key.String()] = struct{}{}
+			}
+			return true
+		})
+	}
+	if len(categories) > 0 {
+		names := make([]string, 0, len(categories))
+		for name := range categories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		verdict.Reason = strings.Join(names, ", ")
+	}
+	return verdict, nil
+}
@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestModerateJumaRequest_RejectsFlaggedPrompt(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.Moderation.Enable = true
+	doer := &jumaStubDoer{responses: []*http.Response{
+		jumaStubResponse(http.StatusOK, `{"results":[{"flagged":true,"categories":{"violence":true,"harassment":false}}]}`),
+	}}
+	original := newJumaUploadClient
+	newJumaUploadClient = func(time.Duration) HTTPDoer { return doer }
+	defer func() { newJumaUploadClient = original }()
+
+	e := NewJumaExecutor(cfg)
+	err := e.moderateJumaRequest(context.Background(), JumaModerationInput{
+		Model:    "juma-test",
+		Messages: []JumaMessage{{Role: "user", Content: "something violent"}},
+	})
+	var se statusErr
+	if !errors.As(err, &se) || se.code != http.StatusForbidden {
+		t.Fatalf("expected 403 statusErr, got %v", err)
+	}
+	if !strings.Contains(se.msg, "violence") {
+		t.Errorf("expected flagged category in message, got %q", se.msg)
+	}
+	if len(doer.bodies) != 1 || !strings.Contains(doer.bodies[0], "something violent") {
+		t.Errorf("expected the prompt to be submitted, got %v", doer.bodies)
+	}
+}
+
+type jumaFailingModerator struct{}
+
+func (jumaFailingModerator) Moderate(context.Context, JumaModerationInput) (JumaModerationVerdict, error) {
+	return JumaModerationVerdict{}, errors.New("classifier offline")
+}
+
+func TestModerateJumaRequest_FailOpenByDefault(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Juma.Moderation.Enable = true
+	e := NewJumaExecutor(cfg)
+	e.SetModerator(jumaFailingModerator{})
+	input := JumaModerationInput{Messages: []JumaMessage{{Role: "user", Content: "hi"}}}
+
+	if err := e.moderateJumaRequest(context.Background(), input); err != nil {
+		t.Fatalf("expected request to proceed, got %v", err)
+	}
+	cfg.Juma.Moderation.FailClosed = true
+	var se statusErr
+	if err := e.moderateJumaRequest(context.Background(), input); !errors.As(err, &se) || se.code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 statusErr when failing closed, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	log "github.com/sirupsen/logrus"
+)
+
+// jumaDefaultSessionThrottle is how long a rate-limited session token is skipped when
+// Juma does not say when to retry.
+const jumaDefaultSessionThrottle = 60 * time.Second
+
+// jumaSessionState is the rotation state of one session token.
+type jumaSessionState struct {
+	lastUsed       time.Time
+	throttledUntil time.Time
+}
+
+// jumaSessionPool tracks every session token seen, keyed by token, so rotation and
+// rate-limit state survive auth reloads.
+var jumaSessionPool = struct {
+	sync.Mutex
+	states map[string]*jumaSessionState
+}{states: make(map[string]*jumaSessionState)}
+
+// jumaSessionTokens returns the session tokens of auth: the session_token attribute,
+// which may hold a comma-separated list, followed by the comma-separated session_tokens
+// attribute. Blank and repeated tokens are dropped.
+func jumaSessionTokens(auth *cliproxyauth.Auth) []string {
+	if auth == nil || auth.Attributes == nil {
+		return nil
+	}
+	var tokens []string
+	seen := make(map[string]struct{})
+	for _, list := range []string{auth.Attributes["session_token"], auth.Attributes["session_tokens"]} {
+		for _, token := range strings.Split(list, ",") {
+			token = strings.TrimSpace(token)
+			if token == "" {
+				continue
+			}
+			if _, dup := seen[token]; dup {
+				continue
+			}
+			seen[token] = struct{}{}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// pickJumaSessionToken returns the least recently used token that is not rate limited
+// and marks it used. When every token is throttled, the one free soonest is returned.
+func pickJumaSessionToken(tokens []string) string {
+	switch len(tokens) {
+	case 0:
+		return ""
+	case 1:
+		return tokens[0]
+	}
+	now := time.Now()
+	jumaSessionPool.Lock()
+	defer jumaSessionPool.Unlock()
+
+	var picked, soonest string
+	var pickedState, soonestState *jumaSessionState
+	for _, token := range tokens {
+		state := jumaSessionPool.states[token]
+		if state == nil {
+			state = &jumaSessionState{}
+			jumaSessionPool.states[token] = state
+		}
+		if now.Before(state.throttledUntil) {
+			if soonestState == nil || state.throttledUntil.Before(soonestState.throttledUntil) {
+				soonest, soonestState = token, state
+			}
+			continue
+		}
+		if pickedState == nil || state.lastUsed.Before(pickedState.lastUsed) {
+			picked, pickedState = token, state
+		}
+	}
+	if pickedState == nil {
+		picked, pickedState = soonest, soonestState
+	}
+	pickedState.lastUsed = now
+	return picked
+}
+
+// markJumaSessionThrottled skips token until Juma's retry delay, or
+// jumaDefaultSessionThrottle when none was given, has passed.
+func markJumaSessionThrottled(token string, retryAfter *time.Duration) {
+	if token == "" {
+		return
+	}
+	delay := jumaDefaultSessionThrottle
+	if retryAfter != nil && *retryAfter > 0 {
+		delay = *retryAfter
+	}
+	jumaSessionPool.Lock()
+	state := jumaSessionPool.states[token]
+	if state == nil {
+		state = &jumaSessionState{}
+		jumaSessionPool.states[token] = state
+	}
+	state.throttledUntil = time.Now().Add(delay)
+	jumaSessionPool.Unlock()
+	jumaLogEntry(log.Fields{"retry_after": delay.String()}).Info("juma executor: session token rate limited, rotating to other tokens")
+}
+
+// jumaRequestSessionToken returns the session token a built Juma request carries.
+func jumaRequestSessionToken(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	cookie, err := req.Cookie(jumaSessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
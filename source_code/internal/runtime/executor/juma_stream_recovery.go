@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/jumaclient"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// jumaDefaultStreamRecoveryAttempts is how often the thread is fetched when
+	// juma.stream-recovery.attempts is unset.
+	jumaDefaultStreamRecoveryAttempts = 3
+	// jumaDefaultStreamRecoveryDelay is the wait before each thread fetch when
+	// juma.stream-recovery.delay is unset.
+	jumaDefaultStreamRecoveryDelay = 2 * time.Second
+	// jumaThreadIDPlaceholder is replaced by the thread ID in juma.stream-recovery.thread-path.
+	jumaThreadIDPlaceholder = "{thread_id}"
+)
+
+// jumaStreamRecoverySettings returns the configured attempts and delay between them.
+func jumaStreamRecoverySettings(cfg *config.Config) (int, time.Duration) {
+	attempts, delay := jumaDefaultStreamRecoveryAttempts, jumaDefaultStreamRecoveryDelay
+	if cfg.Juma.StreamRecovery.Attempts > 0 {
+		attempts = cfg.Juma.StreamRecovery.Attempts
+	}
+	if cfg.Juma.StreamRecovery.Delay > 0 {
+		delay = time.Duration(cfg.Juma.StreamRecovery.Delay) * time.Second
+	}
+	return attempts, delay
+}
+
+// recoverJumaStream fetches the stored assistant message of the thread in reqBody after
+// its stream broke off, using the session token the request was sent with, and returns
+// the part of it following received, the raw text the stream already delivered. It
+// reports false when recovery is disabled, nothing was received yet (the thread's last
+// message could then belong to an earlier turn), or no stored message continues the
+// received text.
+func (e *JumaExecutor) recoverJumaStream(ctx context.Context, auth *cliproxyauth.Auth, sessionToken string, reqBody []byte, received string) (string, bool) {
+	if e.cfg == nil || !e.cfg.Juma.StreamRecovery.Enable || received == "" {
+		return "", false
+	}
+	threadID := gjson.GetBytes(reqBody, "threadId").String()
+	if threadID == "" || sessionToken == "" {
+		return "", false
+	}
+	threadPath := strings.ReplaceAll(e.cfg.Juma.StreamRecovery.ThreadPath, jumaThreadIDPlaceholder, threadID)
+	client := newJumaClient(e.cfg, e.httpClient(ctx, auth, 15*time.Second), sessionToken, gjson.GetBytes(reqBody, "workspaceId").String())
+	entry := jumaLogEntry(log.Fields{"thread_id": threadID})
+
+	attempts, delay := jumaStreamRecoverySettings(e.cfg)
+	remaining, recovered := "", false
+	for attempt := 1; attempt <= attempts; attempt++ {
+		// Give Juma time to finish and store the message before each fetch
+		select {
+		case <-ctx.Done():
+			return remaining, recovered
+		case <-time.After(delay):
+		}
+		body, err := client.FetchThread(ctx, threadPath)
+		if err != nil {
+			entry.WithError(err).WithField("attempt", attempt).Debug("juma executor stream: thread fetch failed")
+			continue
+		}
+		text, ok := jumaclient.LastAssistantText(body)
+		if !ok || !strings.HasPrefix(text, received) {
+			entry.WithField("attempt", attempt).Debug("juma executor stream: thread has no message continuing the stream")
+			continue
+		}
+		remaining, recovered = text[len(received):], true
+		if remaining != "" {
+			break
+		}
+	}
+	if !recovered {
+		entry.Warn("juma executor stream: could not recover the interrupted stream from its thread")
+	}
+	return remaining, recovered
+}
@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// Recorded tool-output-available frames, one per output shape seen from Juma's image tools.
+var jumaToolOutputFixtures = []struct {
+	name   string
+	frames []string
+	want   []string
+}{
+	{
+		name:   "flat imageUrl",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_1","output":{"imageUrl":"https://cdn.juma.ai/img/a.png"}}`},
+		want:   []string{"https://cdn.juma.ai/img/a.png"},
+	},
+	{
+		name:   "nested result",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_2","output":{"result":{"imageUrl":"https://cdn.juma.ai/img/b.png","width":1024}}}`},
+		want:   []string{"https://cdn.juma.ai/img/b.png"},
+	},
+	{
+		name:   "images array of objects",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_3","output":{"images":[{"imageUrl":"https://cdn.juma.ai/img/c1.png"},{"url":"https://cdn.juma.ai/img/c2.png"}]}}`},
+		want:   []string{"https://cdn.juma.ai/img/c1.png", "https://cdn.juma.ai/img/c2.png"},
+	},
+	{
+		name:   "output array of strings",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_4","output":["https://cdn.juma.ai/img/d1.png","https://cdn.juma.ai/img/d2.png","https://cdn.juma.ai/img/d1.png"]}`},
+		want:   []string{"https://cdn.juma.ai/img/d1.png", "https://cdn.juma.ai/img/d2.png"},
+	},
+	{
+		name:   "stringified output",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_5","output":"{\"result\":{\"url\":\"https://cdn.juma.ai/img/e.png\"}}"}`},
+		want:   []string{"https://cdn.juma.ai/img/e.png"},
+	},
+	{
+		name: "split across frames",
+		frames: []string{
+			`{"type":"tool-output-available","toolCallId":"call_6","output":{"imageUrl":"https://cdn.ju`,
+			`ma.ai/img/f.png"}}`,
+		},
+		want: []string{"https://cdn.juma.ai/img/f.png"},
+	},
+	{
+		name:   "no image",
+		frames: []string{`{"type":"tool-output-available","toolCallId":"call_7","output":{"status":"failed"}}`},
+		want:   nil,
+	},
+}
+
+func TestExtractJumaToolOutputImageURLs(t *testing.T) {
+	for _, tc := range jumaToolOutputFixtures {
+		t.Run(tc.name, func(t *testing.T) {
+			assembler := &jumaEventAssembler{limit: 1 << 20}
+			var events []string
+			for _, frame := range tc.frames {
+				if data, complete := assembler.push(frame); complete {
+					events = append(events, data)
+				}
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected 1 assembled event, got %d", len(events))
+			}
+			if eventType := gjson.Get(events[0], "type").String(); eventType != "tool-output-available" {
+				t.Fatalf("unexpected event type %q", eventType)
+			}
+			if got := extractJumaToolOutputImageURLs(events[0]); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestJumaEventAssembler_DropsOversizedEvent(t *testing.T) {
+	assembler := &jumaEventAssembler{limit: 16}
+	if _, complete := assembler.push(`{"type":"text-delta","delta":"` + strings.Repeat("x", 32)); complete {
+		t.Fatal("expected incomplete event to be held back")
+	}
+	data, complete := assembler.push(`{"type":"text-delta","delta":"ok"}`)
+	if !complete || gjson.Get(data, "delta").String() != "ok" {
+		t.Errorf("expected the next event to be delivered intact, got %q", data)
+	}
+}
@@ -2,21 +2,29 @@
 package executor
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"image"
+	_ "image/gif" // registers GIF for image.DecodeConfig
+	"image/jpeg"
+	"image/png"
 	"io"
-	"mime/multipart"
+	"math/rand"
+	"mime"
 	"net/http"
-	"net/textproto"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/jumaclient"
+	internalusage "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	log "github.com/sirupsen/logrus"
-	"github.com/tidwall/gjson"
 )
 
 // JumaImageUploadResult contains the result of uploading an image to Juma
@@ -25,6 +33,12 @@ type JumaImageUploadResult struct {
 	KnowledgeItemID string `json:"knowledgeItemId"` // This is the ID needed for knowledgeItems
 	ImageURL        string `json:"imageUrl"`
 	Name            string `json:"name"`
+	// Width and Height are decoded from the image header; zero when the format is not
+	// recognized (e.g. WebP or a non-image upload).
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Size is the number of bytes uploaded, after any transcoding.
+	Size int64 `json:"size"`
 }
 
 // UploadImageToJuma uploads a base64-encoded image to Juma's file storage
@@ -35,46 +49,209 @@ type JumaImageUploadResult struct {
 // 2. Call Juma's fileStorage.createPresignedUrl to get S3 upload credentials
 // 3. Upload the image to S3 using the presigned URL
 // 4. Return the Juma-hosted image URL for use in chat
-func UploadImageToJuma(sessionToken, workspaceID, imageDataURL string) (*JumaImageUploadResult, error) {
-	// Only process data URLs
-	if !strings.HasPrefix(imageDataURL, "data:") {
-		return nil, fmt.Errorf("not a data URL")
+func UploadImageToJuma(cfg *config.Config, sessionToken, workspaceID, imageDataURL string) (*JumaImageUploadResult, error) {
+	return UploadImageToJumaWithProgress(cfg, sessionToken, workspaceID, imageDataURL, nil)
+}
+
+// UploadImageToJumaWithProgress is UploadImageToJuma reporting each phase, and the bytes
+// written to object storage, to progress. A nil progress reports nothing.
+func UploadImageToJumaWithProgress(cfg *config.Config, sessionToken, workspaceID, imageDataURL string, progress JumaUploadProgressFunc) (*JumaImageUploadResult, error) {
+	var metrics *jumaUploadMetrics
+	if progress != nil {
+		metrics = &jumaUploadMetrics{progress: progress}
+	}
+	result, err := uploadDataURLToJuma(cfg, sessionToken, workspaceID, imageDataURL, "", metrics)
+	if err != nil {
+		internalusage.IncExecutorCounter(internalusage.MetricExecutorUploadFailures, "juma", "")
+		return nil, err
+	}
+	if result.KnowledgeItemID == "" {
+		// The association behind the knowledge item can fail outright; waiting longer does
+		// not help, so redo the whole upload. Without success the caller gets the result
+		// with an empty KnowledgeItemID, as before.
+		if retried, _ := retryJumaKnowledgeItemUpload(cfg, sessionToken, workspaceID, imageDataURL, result, metrics); retried != nil {
+			result = retried
+		}
 	}
+	return result, nil
+}
 
-	// Parse the data URL
-	mimeType, base64Data, err := parseJumaDataURL(imageDataURL)
+// JumaFileUploadResult contains the result of uploading a document to Juma.
+type JumaFileUploadResult struct {
+	ID              string `json:"id"`
+	KnowledgeItemID string `json:"knowledgeItemId"`
+	FileURL         string `json:"fileUrl"`
+	Name            string `json:"name"`
+	MimeType        string `json:"mimeType"`
+}
+
+// UploadFileToJuma uploads a base64-encoded document (e.g. a PDF) to Juma's file storage
+// as a knowledge item. It follows the same presigned-URL flow as UploadImageToJuma.
+// The optional filename is preserved when provided by the client.
+func UploadFileToJuma(cfg *config.Config, sessionToken, workspaceID, fileDataURL, filename string) (*JumaFileUploadResult, error) {
+	return uploadFileToJuma(cfg, sessionToken, workspaceID, fileDataURL, filename, nil)
+}
+
+// uploadFileToJuma implements UploadFileToJuma, recording timings into metrics when set.
+func uploadFileToJuma(cfg *config.Config, sessionToken, workspaceID, fileDataURL, filename string, metrics *jumaUploadMetrics) (*JumaFileUploadResult, error) {
+	declaredType, fileData, err := parseJumaDataURL(fileDataURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse data URL: %w", err)
 	}
+	mimeType := resolveJumaMimeType(declaredType, fileData)
+	uploaded, err := uploadDataURLToJuma(cfg, sessionToken, workspaceID, fileDataURL, filename, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return &JumaFileUploadResult{
+		ID:              uploaded.ID,
+		KnowledgeItemID: uploaded.KnowledgeItemID,
+		FileURL:         uploaded.ImageURL,
+		Name:            uploaded.Name,
+		MimeType:        mimeType,
+	}, nil
+}
+
+// jumaMaxRemoteFileBytes limits the size of documents fetched from file URLs.
+const jumaMaxRemoteFileBytes = 32 << 20 // 32 MiB
+
+// fetchJumaFileDataURL downloads a document referenced by URL and returns it as a data
+// URL, so it can go through the same upload flow as inline file data.
+func fetchJumaFileDataURL(fileURL string, maxBytes int64) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	resp, err := newJumaUploadClient(60 * time.Second).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch file: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
 
-	// Decode base64 data
-	imageData, err := base64.StdEncoding.DecodeString(base64Data)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64: %w", err)
+		return "", fmt.Errorf("read file: %w", err)
 	}
+	if int64(len(data)) > maxBytes {
+		return "", fmt.Errorf("file exceeds %d bytes", maxBytes)
+	}
+	declared, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	mimeType := resolveJumaMimeType(declared, data)
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+}
 
-	// Generate filename
-	ext := getJumaExtensionFromMimeType(mimeType)
-	filename := fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), ext)
+// jumaRawFileDataURL wraps bare base64 file data, as sent in Responses API input_file
+// parts, in a data URL. The type comes from the filename extension, falling back to
+// content sniffing.
+func jumaRawFileDataURL(data, filename string) (string, error) {
+	decoded, err := decodeLenientBase64(data)
+	if err != nil {
+		return "", fmt.Errorf("decode file data: %w", err)
+	}
+	declared, _, _ := strings.Cut(mime.TypeByExtension(strings.ToLower(path.Ext(filename))), ";")
+	mimeType := resolveJumaMimeType(declared, decoded)
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(decoded)), nil
+}
+
+// uploadDataURLToJuma runs the presigned-URL upload flow for any data URL.
+// When filename is empty a timestamped name is generated from the mime type.
+// Step timings and the outcome are recorded into metrics when it is non-nil.
+func uploadDataURLToJuma(cfg *config.Config, sessionToken, workspaceID, dataURL, filename string, metrics *jumaUploadMetrics) (result *JumaImageUploadResult, err error) {
+	var timing jumaUploadTiming
+	startedAt := time.Now()
+	defer func() {
+		timing.total = time.Since(startedAt)
+		metrics.record(timing, err)
+	}()
+
+	pending, err := prepareJumaUpload(cfg, dataURL, filename)
+	if err != nil {
+		return nil, err
+	}
 
 	// Step 1: Get presigned URL from Juma
-	presignedData, err := getJumaPresignedURL(sessionToken, workspaceID, filename, mimeType, len(imageData))
+	metrics.reportProgress(JumaUploadProgress{Filename: pending.filename, Phase: JumaUploadPhasePresign})
+	stepStart := time.Now()
+	presignedData, err := getJumaPresignedURL(cfg, sessionToken, workspaceID, pending.filename, pending.mimeType, len(pending.data))
+	timing.presign = time.Since(stepStart)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get presigned URL: %w", err)
 	}
 
+	return completeJumaUpload(cfg, pending, presignedData, &timing, metrics)
+}
+
+// jumaPendingUpload is a decoded upload ready to be presigned.
+type jumaPendingUpload struct {
+	data     []byte
+	mimeType string
+	filename string
+}
+
+// prepareJumaUpload decodes dataURL, transcodes it when juma.transcode-images is set and
+// settles the filename declared for the upload.
+func prepareJumaUpload(cfg *config.Config, dataURL, filename string) (*jumaPendingUpload, error) {
+	// Only process data URLs
+	if !strings.HasPrefix(dataURL, "data:") {
+		return nil, fmt.Errorf("not a data URL")
+	}
+
+	// Parse the data URL
+	declaredType, fileData, err := parseJumaDataURL(dataURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data URL: %w", err)
+	}
+	mimeType := resolveJumaMimeType(declaredType, fileData)
+	if cfg != nil && cfg.Juma.TranscodeImages {
+		fileData, mimeType = transcodeJumaImage(fileData, mimeType)
+	}
+	if cfg != nil && cfg.Juma.StripImageMetadata {
+		fileData = stripImageMetadata(fileData, mimeType)
+	}
+
+	// Generate filename, or align the client's extension with the (possibly transcoded) type
+	return &jumaPendingUpload{data: fileData, mimeType: mimeType, filename: jumaUploadFilename(filename, mimeType)}, nil
+}
+
+// completeJumaUpload stores a presigned upload in object storage and waits for Juma to
+// process it, recording the storage and wait steps into timing and reporting progress
+// through metrics.
+func completeJumaUpload(cfg *config.Config, pending *jumaPendingUpload, presignedData *jumaPresignedData, timing *jumaUploadTiming, metrics *jumaUploadMetrics) (*JumaImageUploadResult, error) {
+	fileData, mimeType, filename := pending.data, pending.mimeType, pending.filename
+
 	// Step 2: Upload to S3
-	if err := uploadToJumaS3(presignedData, imageData, mimeType); err != nil {
+	stepStart := time.Now()
+	err := uploadToJumaS3(cfg, presignedData, fileData, mimeType, filename, func(written, total int64) {
+		metrics.reportProgress(JumaUploadProgress{Filename: filename, Phase: JumaUploadPhaseUpload, BytesWritten: written, TotalBytes: total})
+	})
+	timing.storage = time.Since(stepStart)
+	if err != nil {
 		return nil, fmt.Errorf("failed to upload to S3: %w", err)
 	}
 
 	// Step 3: Wait for Juma to process the upload and create the knowledge item association
 	// This delay is necessary because Juma's backend needs time to process the S3 upload
 	// and create the threadKnowledgeItem record before we can reference it in chat.
-	log.Infof("juma upload: S3 upload complete, waiting for Juma to process...")
-	time.Sleep(2 * time.Second)
-
-	log.Infof("juma upload: uploaded image successfully, URL: %s, KnowledgeItemID: %s", presignedData.ImageURL, presignedData.KnowledgeItemID)
+	jumaLogEntry(log.Fields{"mime_type": mimeType}).Debug("juma upload: S3 upload complete, waiting for Juma to process")
+	stepStart = time.Now()
+	time.Sleep(jumaUploadProcessingDelay(cfg))
+	timing.wait = time.Since(stepStart)
+
+	jumaLogEntry(log.Fields{
+		"mime_type":         mimeType,
+		"size_bytes":        len(fileData),
+		"knowledge_item_id": presignedData.KnowledgeItemID,
+	}).Info("juma upload: uploaded successfully")
+	metrics.reportProgress(JumaUploadProgress{Filename: filename, Phase: JumaUploadPhaseReady})
+
+	var width, height int
+	if imgCfg, _, errCfg := image.DecodeConfig(bytes.NewReader(fileData)); errCfg == nil {
+		width, height = imgCfg.Width, imgCfg.Height
+		jumaLogEntry(log.Fields{"image_id": presignedData.ImageID, "width": width, "height": height, "size_bytes": len(fileData)}).Debug("juma upload: image dimensions")
+	}
 
 	// IMPORTANT: Do NOT fall back to image ID when knowledge item ID is missing.
 	// Using image.id as knowledgeItemId causes Prisma foreign key constraint errors
@@ -85,281 +262,360 @@ func UploadImageToJuma(sessionToken, workspaceID, imageDataURL string) (*JumaIma
 		KnowledgeItemID: presignedData.KnowledgeItemID, // May be empty - caller should check
 		ImageURL:        presignedData.ImageURL,
 		Name:            filename,
+		Width:           width,
+		Height:          height,
+		Size:            int64(len(fileData)),
 	}, nil
 }
 
-type jumaPresignedData struct {
-	ImageID         string
-	KnowledgeItemID string // This is the ID needed for knowledgeItems in chat request
-	ImageURL        string
-	PresignedURL    string
-	Fields          map[string]string
+const (
+	// jumaDefaultUploadInitialDelay is the wait after an S3 upload before the file is referenced.
+	jumaDefaultUploadInitialDelay = 2 * time.Second
+	// jumaDefaultUploadDelayJitter is the maximum random extra wait added per upload.
+	jumaDefaultUploadDelayJitter = 250 * time.Millisecond
+	// jumaDefaultKnowledgeItemRetries is how often an upload without a knowledge item is redone.
+	jumaDefaultKnowledgeItemRetries = 1
+)
+
+// jumaUploadProcessingDelay returns how long to wait for Juma to process an upload:
+// juma.upload-initial-delay plus a random jitter of up to juma.upload-delay-jitter, so
+// concurrent uploads do not all hit Juma at the same instant.
+func jumaUploadProcessingDelay(cfg *config.Config) time.Duration {
+	delay, jitter := jumaDefaultUploadInitialDelay, jumaDefaultUploadDelayJitter
+	if cfg != nil {
+		if cfg.Juma.UploadInitialDelay > 0 {
+			delay = time.Duration(cfg.Juma.UploadInitialDelay) * time.Millisecond
+		}
+		if cfg.Juma.UploadDelayJitter > 0 {
+			jitter = time.Duration(cfg.Juma.UploadDelayJitter) * time.Millisecond
+		} else if cfg.Juma.UploadDelayJitter < 0 {
+			jitter = 0
+		}
+	}
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter) + 1))
+	}
+	return delay
 }
 
-var jumaUUIDRegex = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+// jumaSupportedImageTypes are image formats Juma accepts without conversion.
+var jumaSupportedImageTypes = map[string]struct{}{
+	"image/jpeg": {}, "image/jpg": {}, "image/png": {}, "image/gif": {}, "image/webp": {},
+}
 
-func getJumaPresignedURL(sessionToken, workspaceID, filename, mimeType string, imageSize int) (*jumaPresignedData, error) {
-	url := "https://app.juma.ai/api/trpc/fileStorage.createPresignedUrl?batch=1"
-
-	payload := map[string]any{
-		"0": map[string]any{
-			"json": map[string]any{
-				"type":      "Knowledge",
-				"threadId":  nil,
-				"name":      filename,
-				"mimeType":  mimeType,
-				"imageSize": imageSize,
-			},
-			"meta": map[string]any{
-				"values": map[string]any{
-					"threadId": []string{"undefined"},
-				},
-				"v": 1,
-			},
-		},
-	}
-
-	payloadBytes, err := json.Marshal(payload)
+// transcodeJumaImage re-encodes images Juma does not accept (e.g. HEIC, BMP, TIFF) as
+// PNG, or as JPEG when the image is fully opaque, keeping the original dimensions.
+// Decoding relies on the decoders registered with the image package, so formats without
+// one are uploaded unchanged with a warning.
+func transcodeJumaImage(data []byte, mimeType string) ([]byte, string) {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return data, mimeType
+	}
+	if _, ok := jumaSupportedImageTypes[mimeType]; ok {
+		return data, mimeType
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		jumaLogEntry(log.Fields{"mime_type": mimeType}).WithError(err).Warn("juma upload: cannot transcode unsupported image format, uploading as-is")
+		return data, mimeType
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payloadBytes))
+	var buf bytes.Buffer
+	outType := "image/png"
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		outType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
 	if err != nil {
-		return nil, err
+		jumaLogEntry(log.Fields{"mime_type": mimeType}).WithError(err).Warn("juma upload: image transcode failed, uploading as-is")
+		return data, mimeType
 	}
+	jumaLogEntry(log.Fields{
+		"from":       mimeType,
+		"decoded_as": format,
+		"to":         outType,
+		"size_bytes": buf.Len(),
+	}).Debug("juma upload: transcoded image")
+	return buf.Bytes(), outType
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Origin", "https://app.juma.ai")
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Header.Set("x-workspace-id", workspaceID)
-	req.Header.Set("trpc-accept", "application/jsonl")
-	req.Header.Set("x-trpc-source", "web")
-	req.AddCookie(&http.Cookie{
-		Name:  "__Secure-next-auth.session-token",
-		Value: sessionToken,
-	})
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+// jumaLowDetailMaxSide is the longest side, in pixels, of images sent with detail "low",
+// matching the resolution OpenAI uses for low-detail vision inputs.
+const jumaLowDetailMaxSide = 512
+
+// downscaleJumaImageDataURL shrinks a PNG or JPEG data URL so its longest side is at most
+// maxSide pixels. Other formats, images already small enough and undecodable data are
+// returned unchanged.
+func downscaleJumaImageDataURL(dataURL string, maxSide int) string {
+	mimeType, data, err := parseJumaDataURL(dataURL)
+	if err != nil || (mimeType != "image/png" && mimeType != "image/jpeg" && mimeType != "image/jpg") {
+		return dataURL
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		jumaLogEntry(log.Fields{"mime_type": mimeType}).WithError(err).Debug("juma upload: cannot decode image for downscaling")
+		return dataURL
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("presigned URL request failed with status %d: %s", resp.StatusCode, string(body))
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return dataURL
+	}
+	if width >= height {
+		height = max(1, height*maxSide/width)
+		width = maxSide
+	} else {
+		width = max(1, width*maxSide/height)
+		height = maxSide
 	}
 
-	// Parse the JSONL response - find the line with presignedUrl
-	scanner := bufio.NewScanner(resp.Body)
-	var presignedData *jumaPresignedData
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "presignedUrl") {
-			// Log full response for debugging
-			log.Debugf("juma upload: presigned URL response line: %s", line)
-
-			// Extract data from the JSONL response
-			jsonResult := gjson.Parse(line)
-
-			// Navigate to the data: json[2][0][0] has the image and presignedUrl
-			imageData := jsonResult.Get("json.2.0.0")
-			if !imageData.Exists() {
-				continue
-			}
-
-			imageID := imageData.Get("image.id").String()
-			imageURL := imageData.Get("image.imageUrl").String()
-			presignedURL := imageData.Get("presignedUrl").String()
-
-			// Extract knowledge item id - this is the ID we need for the chat API.
-			knowledgeItemID := extractJumaKnowledgeItemID(imageData, imageID)
-			log.Infof("juma upload: extracted IDs - imageID=%s, knowledgeItemID=%s", imageID, knowledgeItemID)
-
-			if imageURL == "" || presignedURL == "" {
-				continue
-			}
+	scaled := resizeJumaImage(img, width, height)
+	var buf bytes.Buffer
+	outType := "image/png"
+	if mimeType != "image/png" {
+		outType = "image/jpeg"
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		jumaLogEntry(log.Fields{"mime_type": mimeType}).WithError(err).Warn("juma upload: image downscale failed, uploading original")
+		return dataURL
+	}
+	jumaLogEntry(log.Fields{
+		"from":       fmt.Sprintf("%dx%d", bounds.Dx(), bounds.Dy()),
+		"to":         fmt.Sprintf("%dx%d", width, height),
+		"size_bytes": buf.Len(),
+	}).Debug("juma upload: downscaled low-detail image")
+	return "data:" + outType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
 
-			// Extract fields
-			fields := make(map[string]string)
-			imageData.Get("fields").ForEach(func(key, value gjson.Result) bool {
-				fields[key.String()] = value.String()
-				return true
-			})
-
-			presignedData = &jumaPresignedData{
-				ImageID:         imageID,
-				KnowledgeItemID: knowledgeItemID,
-				ImageURL:        imageURL,
-				PresignedURL:    presignedURL,
-				Fields:          fields,
+// resizeJumaImage scales src to width x height by averaging the source pixels that map
+// onto each destination pixel (a box filter), which avoids aliasing when shrinking.
+func resizeJumaImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
 			}
-			break
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
 		}
 	}
+	return dst
+}
 
-	if presignedData == nil {
-		return nil, fmt.Errorf("failed to parse presigned URL response")
-	}
+// jumaUploadTiming holds the step durations of a single upload.
+type jumaUploadTiming struct {
+	presign time.Duration
+	storage time.Duration
+	wait    time.Duration
+	total   time.Duration
+}
 
-	return presignedData, nil
+// jumaUploadMetrics aggregates upload timings for one request. It is safe for
+// concurrent use, and a nil receiver discards everything.
+type jumaUploadMetrics struct {
+	mu     sync.Mutex
+	detail usage.UploadDetail
+	// progress, when set, receives per-upload progress updates; it is fixed at creation.
+	progress JumaUploadProgressFunc
+	// dryRun replaces every upload with a placeholder attachment; it is fixed at creation.
+	dryRun bool
 }
 
-func extractJumaKnowledgeItemID(imageData gjson.Result, imageID string) string {
-	// When type="Knowledge", the image.id IS the knowledge item ID that can be used
-	// directly in knowledgeItems for the chat API. This was confirmed by analyzing
-	// Juma's web client behavior - the same ID returned in image.id is used in
-	// knowledgeItems[].id when sending chat messages with images.
-	imageType := imageData.Get("image.type").String()
-	if imageType == "Knowledge" && imageID != "" {
-		log.Debugf("juma upload: type=Knowledge, using image.id as knowledgeItemId: %s", imageID)
-		return imageID
+// reportProgress forwards p to the registered progress callback, if any.
+func (m *jumaUploadMetrics) reportProgress(p JumaUploadProgress) {
+	if m == nil || m.progress == nil {
+		return
 	}
+	m.progress(p)
+}
 
-	// Fallback: try to find explicit knowledgeItemId fields (for future API changes)
-	candidates := []string{
-		imageData.Get("knowledgeItem.id").String(),
-		imageData.Get("knowledgeItemId").String(),
-		imageData.Get("knowledgeItemID").String(),
-		imageData.Get("knowledge.id").String(),
-		imageData.Get("image.knowledgeItemId").String(),
-		imageData.Get("image.knowledgeItem.id").String(),
-		imageData.Get("knowledgeItem.knowledgeItemId").String(),
+// record adds one upload attempt; err marks it as failed.
+func (m *jumaUploadMetrics) record(timing jumaUploadTiming, err error) {
+	if m == nil {
+		return
 	}
-	for _, candidate := range candidates {
-		trimmed := strings.TrimSpace(candidate)
-		if trimmed != "" {
-			return trimmed
-		}
-	}
-
-	// If type is not "Knowledge" but we have an imageID, still try to use it
-	// as some Juma configurations may work this way
-	if imageID != "" {
-		log.Debugf("juma upload: no explicit knowledgeItemId found, using image.id as fallback: %s", imageID)
-		return imageID
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.detail.Failed++
+	} else {
+		m.detail.Count++
 	}
-
-	return ""
+	m.detail.PresignLatency += timing.presign
+	m.detail.StorageLatency += timing.storage
+	m.detail.ProcessingWait += timing.wait
+	m.detail.TotalLatency += timing.total
 }
 
-func uploadToJumaS3(presignedData *jumaPresignedData, imageData []byte, mimeType string) error {
-	// Create multipart form data for S3 upload
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-
-	// Log all fields for debugging
-	log.Debugf("juma S3 upload: presignedURL=%s", presignedData.PresignedURL)
-	for k, v := range presignedData.Fields {
-		log.Debugf("juma S3 upload: field %s = %s", k, maskJumaS3FieldValue(k, v))
+// snapshot returns the aggregated upload statistics.
+func (m *jumaUploadMetrics) snapshot() usage.UploadDetail {
+	if m == nil {
+		return usage.UploadDetail{}
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.detail
+}
 
-	// S3 presigned POST requires specific field order:
-	// 1. key (the file path in S3)
-	// 2. Content-Type
-	// 3. Other policy fields (bucket, X-Amz-Algorithm, etc.)
-	// 4. Policy
-	// 5. X-Amz-Signature
-	// 6. file (must be last)
+// jumaPresignedData is a presigned upload issued by Juma.
+type jumaPresignedData = jumaclient.PresignedURL
 
-	// Order matters! Write fields in the order they appear in the policy
-	fieldOrder := []string{"key", "Content-Type", "bucket", "X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "Policy", "X-Amz-Signature"}
+var jumaUUIDRegex = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
 
-	for _, fieldName := range fieldOrder {
-		if value, exists := presignedData.Fields[fieldName]; exists {
-			if err := writer.WriteField(fieldName, value); err != nil {
-				return fmt.Errorf("failed to write field %s: %w", fieldName, err)
-			}
-		}
-	}
+func getJumaPresignedURL(cfg *config.Config, sessionToken, workspaceID, filename, mimeType string, imageSize int) (*jumaPresignedData, error) {
+	client := newJumaClient(cfg, newJumaUploadClient(30*time.Second), sessionToken, workspaceID)
+	return client.CreatePresignedURL(context.Background(), jumaclient.PresignRequest{Name: filename, MimeType: mimeType, Size: imageSize})
+}
 
-	// Add any remaining fields not in our predefined order
-	for key, value := range presignedData.Fields {
-		found := false
-		for _, ordered := range fieldOrder {
-			if key == ordered {
-				found = true
-				break
-			}
-		}
-		if !found {
-			if err := writer.WriteField(key, value); err != nil {
-				return fmt.Errorf("failed to write field %s: %w", key, err)
-			}
-		}
+// getJumaPresignedURLs requests presigned URLs for several uploads in one tRPC batch
+// call. An upload left without a URL fails the whole batch so callers can fall back to
+// per-upload requests.
+func getJumaPresignedURLs(cfg *config.Config, sessionToken, workspaceID string, uploads []*jumaPendingUpload) ([]*jumaPresignedData, error) {
+	files := make([]jumaclient.PresignRequest, len(uploads))
+	for i, upload := range uploads {
+		files[i] = jumaclient.PresignRequest{Name: upload.filename, MimeType: upload.mimeType, Size: len(upload.data)}
 	}
+	client := newJumaClient(cfg, newJumaUploadClient(30*time.Second), sessionToken, workspaceID)
+	return client.CreatePresignedURLs(context.Background(), files)
+}
 
-	// Add the file part last - this is REQUIRED by S3 presigned POST
-	// CRITICAL: Use CreatePart with explicit MIMEHeader to set the correct Content-Type
-	// CreateFormFile uses "application/octet-stream" which doesn't match the S3 policy
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition", `form-data; name="file"; filename="image.png"`)
-	h.Set("Content-Type", mimeType) // Must match the Content-Type field in the S3 policy
-	part, err := writer.CreatePart(h)
-	if err != nil {
-		return fmt.Errorf("failed to create file part: %w", err)
-	}
-	if _, err := part.Write(imageData); err != nil {
-		return err
+// jumaKnowledgeItemFallback returns the configured strategy for uploads that come back
+// without a knowledge item ID.
+func jumaKnowledgeItemFallback(cfg *config.Config) string {
+	if cfg != nil && cfg.Juma.KnowledgeItemFallback != "" {
+		return cfg.Juma.KnowledgeItemFallback
 	}
+	return "uploaded-images-only"
+}
 
-	if err := writer.Close(); err != nil {
-		return err
+// jumaKnowledgeItemRetries returns how many times an upload that came back without a
+// knowledge item is redone, following juma.knowledge-item-retries.
+func jumaKnowledgeItemRetries(cfg *config.Config) int {
+	if cfg != nil {
+		if retries := cfg.Juma.KnowledgeItemRetries; retries > 0 {
+			return retries
+		} else if retries < 0 {
+			return 0
+		}
 	}
+	return jumaDefaultKnowledgeItemRetries
+}
 
-	req, err := http.NewRequest(http.MethodPost, presignedData.PresignedURL, &body)
-	if err != nil {
-		return err
+// retryJumaKnowledgeItemUpload reruns the whole presign and upload flow for an upload
+// that came back without a knowledge item, up to juma.knowledge-item-retries times, and
+// returns the first result carrying one. Each full rerun is logged on its own, apart
+// from the processing wait inside every upload. It returns nil and the last upload
+// error, if any, when no rerun produced a knowledge item.
+func retryJumaKnowledgeItemUpload(cfg *config.Config, sessionToken, workspaceID, dataURL string, uploaded *JumaImageUploadResult, metrics *jumaUploadMetrics) (*JumaImageUploadResult, error) {
+	retries := jumaKnowledgeItemRetries(cfg)
+	var lastErr error
+	for attempt := 1; attempt <= retries; attempt++ {
+		entry := jumaLogEntry(log.Fields{"image_id": uploaded.ID, "retry": attempt, "max_retries": retries})
+		entry.Warn("juma upload: knowledge item missing, rerunning presign and upload")
+		retried, err := uploadDataURLToJuma(cfg, sessionToken, workspaceID, dataURL, uploaded.Name, metrics)
+		if err != nil {
+			lastErr = err
+			entry.WithError(err).Warn("juma upload: knowledge item rerun failed")
+			continue
+		}
+		if retried.KnowledgeItemID != "" && retried.ImageURL != "" {
+			entry.WithField("knowledge_item_id", retried.KnowledgeItemID).Info("juma upload: knowledge item rerun returned a knowledge item")
+			return retried, nil
+		}
 	}
+	return nil, lastErr
+}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// applyJumaKnowledgeItemFallback handles an image upload that still has no knowledge
+// item ID after the reruns of juma.knowledge-item-retries, following
+// juma.knowledge-item-fallback:
+//   - "uploaded-images-only" (default) attaches the image through uploadedImages only;
+//   - "image-id" is an explicit opt-in that uses image.id as the knowledge item ID. Only
+//     some Juma workspaces accept it; elsewhere it fails with a Prisma foreign key error.
+//
+// A warning names the path taken, since images outside knowledgeItems may be ignored.
+func applyJumaKnowledgeItemFallback(cfg *config.Config, uploaded *JumaImageUploadResult) *JumaImageUploadResult {
+	strategy := jumaKnowledgeItemFallback(cfg)
+	entry := jumaLogEntry(log.Fields{"image_id": uploaded.ID, "strategy": strategy})
+	if strategy == "image-id" {
+		entry.Warn("juma upload: knowledge item missing, using image.id as knowledge item ID (may cause foreign key errors)")
+		withFallback := *uploaded
+		withFallback.KnowledgeItemID = uploaded.ID
+		return &withFallback
 	}
-	defer func() { _ = resp.Body.Close() }()
+	entry.Warn("juma upload: knowledge item missing, attaching image via uploadedImages only")
+	return uploaded
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("S3 upload failed with status %d: %s", resp.StatusCode, string(respBody))
+func uploadToJumaS3(cfg *config.Config, presignedData *jumaPresignedData, imageData []byte, mimeType, filename string, report func(written, total int64)) error {
+	if filename == "" {
+		filename = "upload" + getJumaExtensionFromMimeType(mimeType)
 	}
-
-	return nil
+	client := newJumaClient(cfg, newJumaUploadClient(60*time.Second), "", "")
+	return client.UploadToStorage(context.Background(), presignedData, imageData, mimeType, filename, report)
 }
 
-func maskJumaS3FieldValue(key, value string) string {
-	lower := strings.ToLower(strings.TrimSpace(key))
-	switch lower {
-	case "x-amz-credential", "x-amz-signature", "policy":
-		return "<redacted>"
-	default:
-		trimmed := strings.TrimSpace(value)
-		if len(trimmed) <= 80 {
-			return trimmed
+// resolveJumaMimeType returns the MIME type to upload with. The declared type is kept
+// unless it is generic (e.g. application/octet-stream) or names a different image
+// format than the bytes, in which case the type sniffed from the data is used.
+func resolveJumaMimeType(declared string, data []byte) string {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	sniffed, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	sniffed = strings.TrimSpace(sniffed)
+	sniffedGeneric := sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain")
+
+	switch {
+	case declared == "" || declared == "application/octet-stream" || declared == "binary/octet-stream":
+		if !sniffedGeneric {
+			jumaLogEntry(log.Fields{"declared": declared, "sniffed": sniffed}).Debug("juma upload: using sniffed content type")
+			return sniffed
+		}
+	case strings.HasPrefix(declared, "image/") && strings.HasPrefix(sniffed, "image/") && declared != sniffed:
+		// image/jpg is a common alias of image/jpeg and not a real mismatch.
+		if !(declared == "image/jpg" && sniffed == "image/jpeg") {
+			jumaLogEntry(log.Fields{"declared": declared, "sniffed": sniffed}).Debug("juma upload: declared image type does not match content")
+			return sniffed
 		}
-		return trimmed[:40] + "..." + trimmed[len(trimmed)-12:]
 	}
+	if declared == "" {
+		return "application/octet-stream"
+	}
+	return declared
 }
 
-func parseJumaDataURL(dataURL string) (mimeType, data string, err error) {
+func parseJumaDataURL(dataURL string) (mimeType string, data []byte, err error) {
 	if !strings.HasPrefix(dataURL, "data:") {
-		return "", "", fmt.Errorf("not a data URL")
+		return "", nil, fmt.Errorf("not a data URL")
 	}
 
 	rest := dataURL[5:]
 	commaIdx := strings.Index(rest, ",")
 	if commaIdx == -1 {
-		return "", "", fmt.Errorf("invalid data URL format")
+		return "", nil, fmt.Errorf("invalid data URL format")
 	}
 
 	metadata := rest[:commaIdx]
-	data = rest[commaIdx+1:]
+	payload := rest[commaIdx+1:]
 
 	parts := strings.Split(metadata, ";")
 	if len(parts) >= 1 {
@@ -369,9 +625,32 @@ func parseJumaDataURL(dataURL string) (mimeType, data string, err error) {
 		mimeType = "application/octet-stream"
 	}
 
+	data, err = decodeDataURLPayload(parts[1:], payload)
+	if err != nil {
+		return "", nil, err
+	}
 	return mimeType, data, nil
 }
 
+// jumaUploadFilename returns the filename declared for an upload. Without a client
+// filename a timestamped name is generated from the mime type; an image filename whose
+// extension names another format (e.g. after transcoding) gets the matching extension.
+func jumaUploadFilename(filename, mimeType string) string {
+	filename = strings.TrimSpace(filename)
+	ext := getJumaExtensionFromMimeType(mimeType)
+	if filename == "" {
+		return fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), ext)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return filename
+	}
+	current := strings.ToLower(path.Ext(filename))
+	if current == ext || (current == ".jpeg" && ext == ".jpg") {
+		return filename
+	}
+	return strings.TrimSuffix(filename, path.Ext(filename)) + ext
+}
+
 func getJumaExtensionFromMimeType(mimeType string) string {
 	switch mimeType {
 	case "image/jpeg", "image/jpg":
@@ -382,6 +661,16 @@ func getJumaExtensionFromMimeType(mimeType string) string {
 		return ".gif"
 	case "image/webp":
 		return ".webp"
+	case "application/pdf":
+		return ".pdf"
+	case "text/plain":
+		return ".txt"
+	case "text/markdown":
+		return ".md"
+	case "text/csv":
+		return ".csv"
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return ".docx"
 	default:
 		return ".png"
 	}
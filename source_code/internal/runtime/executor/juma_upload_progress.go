@@ -0,0 +1,46 @@
+package executor
+
+import "context"
+
+// JumaUploadPhase names a step of a Juma upload reported to a JumaUploadProgressFunc.
+type JumaUploadPhase string
+
+const (
+	// JumaUploadPhasePresign is reported before the presigned URL is requested.
+	JumaUploadPhasePresign JumaUploadPhase = "presign"
+	// JumaUploadPhaseUpload is reported as the file is written to object storage.
+	JumaUploadPhaseUpload JumaUploadPhase = "upload"
+	// JumaUploadPhaseReady is reported once Juma has processed the upload.
+	JumaUploadPhaseReady JumaUploadPhase = "ready"
+)
+
+// JumaUploadProgress is one progress update for a single upload. BytesWritten and
+// TotalBytes count the multipart body sent to object storage and are only set during
+// JumaUploadPhaseUpload.
+type JumaUploadProgress struct {
+	Filename     string
+	Phase        JumaUploadPhase
+	BytesWritten int64
+	TotalBytes   int64
+}
+
+// JumaUploadProgressFunc receives upload progress updates. Images of one message are
+// uploaded in parallel, so it may be called concurrently.
+type JumaUploadProgressFunc func(JumaUploadProgress)
+
+type jumaUploadProgressKey struct{}
+
+// WithJumaUploadProgress returns a context whose Juma requests report the progress of
+// their image and file uploads to fn.
+func WithJumaUploadProgress(ctx context.Context, fn JumaUploadProgressFunc) context.Context {
+	return context.WithValue(ctx, jumaUploadProgressKey{}, fn)
+}
+
+// jumaUploadProgressFrom returns the progress callback registered on ctx, or nil.
+func jumaUploadProgressFrom(ctx context.Context) JumaUploadProgressFunc {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(jumaUploadProgressKey{}).(JumaUploadProgressFunc)
+	return fn
+}
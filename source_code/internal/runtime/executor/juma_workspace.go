@@ -0,0 +1,184 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// jumaWorkspaceID returns the workspace ID configured on the auth.
+func jumaWorkspaceID(auth *cliproxyauth.Auth) string {
+	if auth == nil || auth.Attributes == nil {
+		return ""
+	}
+	return strings.TrimSpace(auth.Attributes["workspace_id"])
+}
+
+// jumaWorkspaceKey identifies one session of one auth in the discovered-workspace cache.
+// The session token is part of the key because the tokens of one auth may belong to
+// different Juma accounts.
+type jumaWorkspaceKey struct {
+	authID       string
+	sessionToken string
+}
+
+// jumaWorkspaceCache remembers auto-discovered workspaces so later requests skip the
+// lookup. The auth itself is owned by the core manager and is never written to.
+type jumaWorkspaceCache struct {
+	mu    sync.RWMutex
+	byKey map[jumaWorkspaceKey]string
+}
+
+func (c *jumaWorkspaceCache) get(key jumaWorkspaceKey) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byKey[key]
+}
+
+func (c *jumaWorkspaceCache) put(key jumaWorkspaceKey, workspaceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byKey == nil {
+		c.byKey = make(map[jumaWorkspaceKey]string)
+	}
+	c.byKey[key] = workspaceID
+}
+
+// forget drops every workspace cached for authID, whatever its session token.
+func (c *jumaWorkspaceCache) forget(authID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.byKey {
+		if key.authID == authID {
+			delete(c.byKey, key)
+		}
+	}
+}
+
+// resolveWorkspaceID returns workspaceID unchanged when set. Otherwise it lists the
+// workspaces visible to the session, picks the default one (or the first), and caches
+// the result per auth and session token so later requests skip the lookup.
+func (e *JumaExecutor) resolveWorkspaceID(ctx context.Context, auth *cliproxyauth.Auth, sessionToken, workspaceID string) (string, error) {
+	if workspaceID != "" {
+		return workspaceID, nil
+	}
+	key := jumaWorkspaceKey{sessionToken: sessionToken}
+	if auth != nil {
+		key.authID = auth.ID
+	}
+	if cached := e.workspaces.get(key); cached != "" {
+		return cached, nil
+	}
+	discovered, err := discoverJumaWorkspace(ctx, e.cfg, e.httpClient(ctx, auth, 15*time.Second), sessionToken)
+	if err != nil {
+		return "", statusErr{code: http.StatusBadRequest, msg: fmt.Sprintf("Juma workspace_id is not configured and auto-discovery failed: %v", err)}
+	}
+	e.workspaces.put(key, discovered)
+	jumaLogEntry(log.Fields{"workspace_id": discovered}).Info("juma executor: discovered workspace")
+	return discovered, nil
+}
+
+// discoverJumaWorkspace queries Juma's workspace list for the session and returns the ID
+// of the default workspace, falling back to the first one listed.
+func discoverJumaWorkspace(ctx context.Context, cfg *config.Config, client HTTPDoer, sessionToken string) (string, error) {
+	baseURL := jumaBaseURLFor(cfg)
+	input := url.QueryEscape(`{"0":{"json":null,"meta":{"values":["undefined"],"v":1}}}`)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/trpc/workspace.list?batch=1&input="+input, nil)
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Accept", "*/*")
+	httpReq.Header.Set("Origin", baseURL)
+	httpReq.Header.Set("User-Agent", jumaUserAgent(cfg))
+	httpReq.Header.Set("Accept-Language", jumaLocale(cfg))
+	setJumaTRPCHeaders(cfg, httpReq)
+	httpReq.AddCookie(&http.Cookie{
+		Name:  jumaSessionCookieName,
+		Value: sessionToken,
+	})
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("workspace list request failed: %w", err)
+	}
+	defer func() {
+		if errClose := httpResp.Body.Close(); errClose != nil {
+			log.Errorf("juma executor: close response body error: %v", errClose)
+		}
+	}()
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1<<16))
+		return "", fmt.Errorf("workspace list request failed with status %d: %s", httpResp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// The tRPC response is either a single JSON document or JSONL chunks; scan every line
+	// for the first array of workspace-like objects.
+	scanner := bufio.NewScanner(io.LimitReader(httpResp.Body, 4<<20))
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || !gjson.Valid(line) {
+			continue
+		}
+		if id := pickJumaWorkspace(gjson.Parse(line)); id != "" {
+			return id, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", fmt.Errorf("read workspace list: %w", err)
+	}
+	return "", fmt.Errorf("no workspace found for this session")
+}
+
+// pickJumaWorkspace walks node looking for an array of objects carrying UUID ids and returns
+// the one flagged as default, or the first entry when none is flagged.
+func pickJumaWorkspace(node gjson.Result) string {
+	switch {
+	case node.IsArray():
+		items := node.Array()
+		first := ""
+		for _, item := range items {
+			if !item.IsObject() {
+				continue
+			}
+			id := item.Get("id").String()
+			if !isJumaUUID(id) {
+				continue
+			}
+			if item.Get("isDefault").Bool() || item.Get("default").Bool() || item.Get("isPersonal").Bool() {
+				return id
+			}
+			if first == "" {
+				first = id
+			}
+		}
+		if first != "" {
+			return first
+		}
+		for _, item := range items {
+			if id := pickJumaWorkspace(item); id != "" {
+				return id
+			}
+		}
+	case node.IsObject():
+		found := ""
+		node.ForEach(func(_, value gjson.Result) bool {
+			found = pickJumaWorkspace(value)
+			return found == ""
+		})
+		return found
+	}
+	return ""
+}
@@ -23,7 +23,10 @@ type usageReporter struct {
 	apiKey      string
 	source      string
 	requestedAt time.Time
-	once        sync.Once
+	uploads     usage.UploadDetail
+	// estimatedInput is a heuristic prompt size for providers without token usage.
+	estimatedInput int64
+	once           sync.Once
 }
 
 func newUsageReporter(ctx context.Context, provider, model string, auth *cliproxyauth.Auth) *usageReporter {
@@ -42,6 +45,37 @@ func newUsageReporter(ctx context.Context, provider, model string, auth *cliprox
 	return reporter
 }
 
+// setUploads attaches media upload statistics to the record published for this request.
+func (r *usageReporter) setUploads(detail usage.UploadDetail) {
+	if r == nil {
+		return
+	}
+	r.uploads = detail
+}
+
+// setEstimatedInput records an estimated prompt token count for publishEstimated.
+func (r *usageReporter) setEstimatedInput(tokens int64) {
+	if r == nil {
+		return
+	}
+	r.estimatedInput = tokens
+}
+
+// publishEstimated publishes the estimated prompt size together with estimated completion
+// and reasoning token counts, for providers that report no token usage. The record is
+// flagged as Estimated.
+func (r *usageReporter) publishEstimated(ctx context.Context, outputTokens, reasoningTokens int64) {
+	if r == nil {
+		return
+	}
+	r.publish(ctx, usage.Detail{
+		InputTokens:     r.estimatedInput,
+		OutputTokens:    outputTokens,
+		ReasoningTokens: reasoningTokens,
+		Estimated:       true,
+	})
+}
+
 func (r *usageReporter) publish(ctx context.Context, detail usage.Detail) {
 	r.publishWithOutcome(ctx, detail, false)
 }
@@ -83,6 +117,7 @@ func (r *usageReporter) publishWithOutcome(ctx context.Context, detail usage.Det
 			RequestedAt: r.requestedAt,
 			Failed:      failed,
 			Detail:      detail,
+			Uploads:     r.uploads,
 		})
 	})
 }
@@ -106,6 +141,7 @@ func (r *usageReporter) ensurePublished(ctx context.Context) {
 			RequestedAt: r.requestedAt,
 			Failed:      false,
 			Detail:      usage.Detail{},
+			Uploads:     r.uploads,
 		})
 	})
 }
@@ -0,0 +1,113 @@
+package usage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Executor counter names exposed by ExecutorMetrics.
+const (
+	// MetricExecutorRequests counts requests handed to an executor, labelled by mode.
+	MetricExecutorRequests = "cliproxy_executor_requests_total"
+	// MetricExecutorImageGenerations counts images-generation requests.
+	MetricExecutorImageGenerations = "cliproxy_executor_image_generation_requests_total"
+	// MetricExecutorUploadFailures counts attachment uploads that failed.
+	MetricExecutorUploadFailures = "cliproxy_executor_upload_failures_total"
+	// MetricExecutorUpstreamStatus counts upstream HTTP responses, labelled by status code.
+	MetricExecutorUpstreamStatus = "cliproxy_executor_upstream_status_total"
+)
+
+var executorMetricHelp = map[string]string{
+	MetricExecutorRequests:         "Requests handled by provider executors.",
+	MetricExecutorImageGenerations: "Image generation requests handled by provider executors.",
+	MetricExecutorUploadFailures:   "Attachment uploads that failed in provider executors.",
+	MetricExecutorUpstreamStatus:   "Upstream HTTP responses received by provider executors, by status code.",
+}
+
+// ExecutorMetrics keeps provider-specific counters that the usage records do not capture,
+// such as streaming mode and upstream status codes, and renders them in the Prometheus
+// text exposition format.
+type ExecutorMetrics struct {
+	mu       sync.Mutex
+	counters map[string]map[string]int64 // metric name -> rendered label set -> value
+}
+
+var defaultExecutorMetrics = NewExecutorMetrics()
+
+// GetExecutorMetrics returns the shared executor metrics collector.
+func GetExecutorMetrics() *ExecutorMetrics { return defaultExecutorMetrics }
+
+// NewExecutorMetrics constructs an empty collector.
+func NewExecutorMetrics() *ExecutorMetrics {
+	return &ExecutorMetrics{counters: make(map[string]map[string]int64)}
+}
+
+// IncExecutorCounter adds one to metric on the shared collector.
+// Labels are given as alternating name/value pairs after provider and model.
+func IncExecutorCounter(metric, provider, model string, labels ...string) {
+	defaultExecutorMetrics.Add(metric, 1, provider, model, labels...)
+}
+
+// Add increases metric by delta for the given provider, model and extra label pairs.
+func (m *ExecutorMetrics) Add(metric string, delta int64, provider, model string, labels ...string) {
+	if m == nil || delta <= 0 {
+		return
+	}
+	pairs := append([]string{"provider", provider, "model", model}, labels...)
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", pairs[i], pairs[i+1])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.counters[metric]
+	if !ok {
+		series = make(map[string]int64)
+		m.counters[metric] = series
+	}
+	series[b.String()] += delta
+}
+
+// WritePrometheus writes every counter in the Prometheus text exposition format.
+func (m *ExecutorMetrics) WritePrometheus(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := make([]string, 0, len(m.counters))
+	for metric := range m.counters {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		if help := executorMetricHelp[metric]; help != "" {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", metric, help); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s counter\n", metric); err != nil {
+			return err
+		}
+		series := m.counters[metric]
+		labelSets := make([]string, 0, len(series))
+		for labels := range series {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		for _, labels := range labelSets {
+			if _, err := fmt.Fprintf(w, "%s{%s} %d\n", metric, labels, series[labels]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
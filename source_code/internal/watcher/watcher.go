@@ -1193,6 +1193,9 @@ func (w *Watcher) SnapshotCoreAuths() []*coreauth.Auth {
 				"source":               fmt.Sprintf("config:juma[%s]", token),
 				"session_token":        sessionToken,
 			}
+			if len(jk.SessionTokens) > 0 {
+				attrs["session_tokens"] = strings.Join(jk.SessionTokens, ",")
+			}
 			if jk.WorkspaceID != "" {
 				attrs["workspace_id"] = jk.WorkspaceID
 			}
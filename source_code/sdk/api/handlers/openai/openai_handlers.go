@@ -18,6 +18,7 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers"
+	coreexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
@@ -147,6 +148,110 @@ func (h *OpenAIAPIHandler) Completions(c *gin.Context) {
 
 }
 
+// ImageGenerations handles the /v1/images/generations endpoint.
+// The body keeps the OpenAI images shape ({"model", "prompt", "n", "size", ...}) and is
+// passed to the executor as-is; image-capable executors recognise it by its prompt.
+// Streaming requests are answered with one SSE event per generated image.
+//
+// Parameters:
+//   - c: The Gin context containing the HTTP request and response
+func (h *OpenAIAPIHandler) ImageGenerations(c *gin.Context) {
+	rawJSON, err := c.GetRawData()
+	// If data retrieval fails, return a 400 Bad Request error.
+	if err != nil {
+		c.JSON(http.StatusBadRequest, handlers.ErrorResponse{
+			Error: handlers.ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if gjson.GetBytes(rawJSON, "prompt").Type != gjson.String || gjson.GetBytes(rawJSON, "model").String() == "" {
+		c.JSON(http.StatusBadRequest, handlers.ErrorResponse{
+			Error: handlers.ErrorDetail{
+				Message: "Invalid request: model and prompt are required",
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	// A messages field would turn the body back into a chat completion downstream.
+	rawJSON, _ = sjson.DeleteBytes(rawJSON, "messages")
+
+	if gjson.GetBytes(rawJSON, "stream").Type == gjson.True {
+		h.handleImageGenerationsStreamingResponse(c, rawJSON)
+	} else {
+		h.handleNonStreamingResponse(c, rawJSON)
+	}
+}
+
+// handleImageGenerationsStreamingResponse streams image generation events. Each chunk is
+// written with an SSE event line naming its type (e.g. "image_generation.completed"),
+// as the OpenAI images streaming API does, and no [DONE] marker is sent.
+//
+// Parameters:
+//   - c: The Gin context containing the HTTP request and response
+//   - rawJSON: The raw JSON bytes of the OpenAI images request
+func (h *OpenAIAPIHandler) handleImageGenerationsStreamingResponse(c *gin.Context, rawJSON []byte) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	// Get the http.Flusher interface to manually flush the response.
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, handlers.ErrorResponse{
+			Error: handlers.ErrorDetail{
+				Message: "Streaming not supported",
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+
+	modelName := gjson.GetBytes(rawJSON, "model").String()
+	cliCtx, cliCancel := h.GetContextWithCancel(h, c, context.Background())
+	dataChan, errChan := h.ExecuteStreamWithAuthManager(cliCtx, h.HandlerType(), modelName, rawJSON, h.GetAlt(c))
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			cliCancel(c.Request.Context().Err())
+			return
+		case chunk, isOk := <-dataChan:
+			if !isOk {
+				cliCancel()
+				return
+			}
+			if coreexecutor.IsStreamComment(chunk) {
+				_, _ = fmt.Fprintf(c.Writer, "%s\n\n", string(chunk))
+			} else if eventType := gjson.GetBytes(chunk, "type").String(); eventType != "" {
+				_, _ = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, string(chunk))
+			} else {
+				_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", string(chunk))
+			}
+			flusher.Flush()
+		case errMsg, isOk := <-errChan:
+			if !isOk {
+				continue
+			}
+			if errMsg != nil {
+				h.WriteErrorResponse(c, errMsg)
+				flusher.Flush()
+			}
+			var execErr error
+			if errMsg != nil {
+				execErr = errMsg.Error
+			}
+			cliCancel(execErr)
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
 // convertCompletionsRequestToChatCompletions converts OpenAI completions API request to chat completions format.
 // This allows the completions endpoint to use the existing chat completions infrastructure.
 //
@@ -510,6 +615,11 @@ func (h *OpenAIAPIHandler) handleCompletionsStreamingResponse(c *gin.Context, ra
 				cliCancel()
 				return
 			}
+			if coreexecutor.IsStreamComment(chunk) {
+				_, _ = fmt.Fprintf(c.Writer, "%s\n\n", string(chunk))
+				flusher.Flush()
+				continue
+			}
 			converted := convertChatCompletionsStreamChunkToCompletions(chunk)
 			if converted != nil {
 				_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", string(converted))
@@ -546,7 +656,11 @@ func (h *OpenAIAPIHandler) handleStreamResult(c *gin.Context, flusher http.Flush
 				cancel(nil)
 				return
 			}
-			_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", string(chunk))
+			if coreexecutor.IsStreamComment(chunk) {
+				_, _ = fmt.Fprintf(c.Writer, "%s\n\n", string(chunk))
+			} else {
+				_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", string(chunk))
+			}
 			flusher.Flush()
 		case errMsg, ok := <-errs:
 			if !ok {
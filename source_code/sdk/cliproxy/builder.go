@@ -46,6 +46,9 @@ type Builder struct {
 
 	// serverOptions contains additional server configuration options.
 	serverOptions []api.ServerOption
+
+	// jumaModerator replaces the built-in moderator used when juma.moderation.enable is set.
+	jumaModerator JumaModerator
 }
 
 // Hooks allows callers to plug into service lifecycle stages.
@@ -151,6 +154,13 @@ func (b *Builder) WithLocalManagementPassword(password string) *Builder {
 	return b
 }
 
+// WithJumaModerator installs a custom moderator for Juma requests, run instead of the
+// built-in OpenAI-compatible one when juma.moderation.enable is set.
+func (b *Builder) WithJumaModerator(moderator JumaModerator) *Builder {
+	b.jumaModerator = moderator
+	return b
+}
+
 // Build validates inputs, applies defaults, and returns a ready-to-run service.
 func (b *Builder) Build() (*Service, error) {
 	if b.cfg == nil {
@@ -213,6 +223,7 @@ func (b *Builder) Build() (*Service, error) {
 		accessManager:  accessManager,
 		coreManager:    coreManager,
 		serverOptions:  append([]api.ServerOption(nil), b.serverOptions...),
+		jumaModerator:  b.jumaModerator,
 	}
 	return service, nil
 }
@@ -53,6 +53,17 @@ type StreamChunk struct {
 	Err error
 }
 
+// StreamKeepAlive is a chunk payload executors may emit while a slow upstream has sent
+// nothing yet. It is an SSE comment line: stream handlers write payloads starting with
+// ':' verbatim instead of wrapping them in a data event.
+var StreamKeepAlive = []byte(": keepalive")
+
+// IsStreamComment reports whether a chunk payload is an SSE comment such as
+// StreamKeepAlive rather than an event body.
+func IsStreamComment(payload []byte) bool {
+	return len(payload) > 0 && payload[0] == ':'
+}
+
 // StatusError represents an error that carries an HTTP-like status code.
 // Provider executors should implement this when possible to enable
 // better auth state updates on failures (e.g., 401/402/429).
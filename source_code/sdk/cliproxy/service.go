@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/api"
@@ -86,6 +88,13 @@ type Service struct {
 
 	// wsGateway manages websocket Gemini providers.
 	wsGateway *wsrelay.Manager
+
+	// jumaExecutor is the most recently registered Juma executor, also used to probe new
+	// Juma auths.
+	jumaExecutor atomic.Pointer[executor.JumaExecutor]
+
+	// jumaModerator is installed on every Juma executor the service creates.
+	jumaModerator JumaModerator
 }
 
 // RegisterUsagePlugin registers a usage plugin on the global usage manager.
@@ -289,7 +298,42 @@ func (s *Service) applyCoreAuthAddOrUpdate(ctx context.Context, auth *coreauth.A
 	}
 	if _, err := s.coreManager.Register(ctx, auth); err != nil {
 		log.Errorf("failed to register auth %s: %v", auth.ID, err)
+		return
 	}
+	s.validateAuthOnRegister(ctx, auth)
+}
+
+// validateAuthOnRegister probes a newly registered Juma credential in the background
+// through the registered executor's Refresh, which marks the auth unavailable only when
+// Juma rejects the session token. Network failures leave the auth usable.
+func (s *Service) validateAuthOnRegister(ctx context.Context, auth *coreauth.Auth) {
+	if auth == nil || auth.Disabled || !strings.EqualFold(auth.Provider, "juma") {
+		return
+	}
+	jumaExecutor := s.jumaExecutor.Load()
+	if jumaExecutor == nil {
+		return
+	}
+	probe := auth.Clone()
+	go func() {
+		validateCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+		defer cancel()
+		checked, err := jumaExecutor.Refresh(validateCtx, probe)
+		if err != nil || checked == nil || !checked.Unavailable {
+			return
+		}
+		current, ok := s.coreManager.GetByID(probe.ID)
+		if !ok || current == nil {
+			return
+		}
+		log.Warnf("auth %s failed validation: %s", probe.ID, checked.StatusMessage)
+		current = current.Clone()
+		current.Unavailable = true
+		current.StatusMessage = checked.StatusMessage
+		if _, errUpdate := s.coreManager.Update(validateCtx, current); errUpdate != nil {
+			log.Errorf("failed to update auth %s: %v", probe.ID, errUpdate)
+		}
+	}()
 }
 
 func (s *Service) applyCoreAuthRemoval(ctx context.Context, id string) {
@@ -300,6 +344,9 @@ func (s *Service) applyCoreAuthRemoval(ctx context.Context, id string) {
 		return
 	}
 	GlobalModelRegistry().UnregisterClient(id)
+	if jumaExecutor := s.jumaExecutor.Load(); jumaExecutor != nil {
+		jumaExecutor.ForgetAuth(id)
+	}
 	if existing, ok := s.coreManager.GetByID(id); ok && existing != nil {
 		existing.Disabled = true
 		existing.Status = coreauth.StatusDisabled
@@ -380,7 +427,10 @@ func (s *Service) ensureExecutorsForAuth(a *coreauth.Auth) {
 	case "iflow":
 		s.coreManager.RegisterExecutor(executor.NewIFlowExecutor(s.cfg))
 	case "juma":
-		s.coreManager.RegisterExecutor(executor.NewJumaExecutor(s.cfg))
+		jumaExecutor := executor.NewJumaExecutor(s.cfg)
+		jumaExecutor.SetModerator(s.jumaModerator)
+		s.jumaExecutor.Store(jumaExecutor)
+		s.coreManager.RegisterExecutor(jumaExecutor)
 	default:
 		providerKey := strings.ToLower(strings.TrimSpace(a.Provider))
 		if providerKey == "" {
@@ -726,6 +776,8 @@ func (s *Service) registerModelsForAuth(a *coreauth.Auth) {
 	case "juma":
 		models = registry.GetJumaModels()
 		models = applyExcludedModels(models, excluded)
+		models = applyJumaModelFilter(s.cfg, models)
+		models = appendJumaAliasModels(s.cfg, models)
 	default:
 		// Handle OpenAI-compatibility providers by name using config
 		if s.cfg != nil {
@@ -992,6 +1044,50 @@ func applyExcludedModels(models []*ModelInfo, excluded []string) []*ModelInfo {
 	return filtered
 }
 
+// applyJumaModelFilter drops Juma models that juma.models does not advertise.
+func applyJumaModelFilter(cfg *config.Config, models []*ModelInfo) []*ModelInfo {
+	filtered := make([]*ModelInfo, 0, len(models))
+	for _, model := range models {
+		if model != nil && executor.JumaModelAdvertised(cfg, model.ID) {
+			filtered = append(filtered, model)
+		}
+	}
+	return filtered
+}
+
+// appendJumaAliasModels advertises each juma.alias-map name whose target is among models,
+// so requests using that name are routed to Juma.
+func appendJumaAliasModels(cfg *config.Config, models []*ModelInfo) []*ModelInfo {
+	if cfg == nil || len(cfg.Juma.AliasMap) == 0 {
+		return models
+	}
+	byID := make(map[string]*ModelInfo, len(models))
+	for _, model := range models {
+		if model != nil {
+			byID[strings.ToLower(model.ID)] = model
+		}
+	}
+	names := make([]string, 0, len(cfg.Juma.AliasMap))
+	for name := range cfg.Juma.AliasMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target, ok := byID[strings.ToLower(cfg.Juma.AliasMap[name])]
+		if !ok {
+			continue
+		}
+		if _, exists := byID[strings.ToLower(name)]; exists {
+			continue
+		}
+		alias := *target
+		alias.ID = name
+		models = append(models, &alias)
+		byID[strings.ToLower(name)] = &alias
+	}
+	return models
+}
+
 // matchWildcard performs case-insensitive wildcard matching where '*' matches any substring.
 func matchWildcard(pattern, value string) bool {
 	if pattern == "" {
@@ -7,6 +7,7 @@ import (
 	"context"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/runtime/executor"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/watcher"
 	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 )
@@ -67,6 +68,19 @@ type APIKeyClientResult struct {
 	OpenAICompatCount int
 }
 
+// JumaModerator checks Juma requests before they are forwarded; see
+// Builder.WithJumaModerator. An error means the check itself failed, and
+// juma.moderation.fail-closed decides whether the request proceeds.
+type JumaModerator = executor.JumaModerator
+
+// JumaModerationInput is what a JumaModerator sees of a request: the converted messages
+// and the images uploaded for them.
+type JumaModerationInput = executor.JumaModerationInput
+
+// JumaModerationVerdict is a JumaModerator's decision. Reason is returned to the client
+// when Flagged is set.
+type JumaModerationVerdict = executor.JumaModerationVerdict
+
 // WatcherFactory creates a watcher for configuration and token changes.
 // The reload callback receives the updated configuration when changes are detected.
 //
@@ -19,6 +19,7 @@ type Record struct {
 	RequestedAt time.Time
 	Failed      bool
 	Detail      Detail
+	Uploads     UploadDetail
 }
 
 // Detail holds the token usage breakdown.
@@ -28,6 +29,20 @@ type Detail struct {
 	ReasoningTokens int64
 	CachedTokens    int64
 	TotalTokens     int64
+	// Estimated marks counts derived from a text-length heuristic because the provider
+	// reports no token usage. They are approximate and only suited to rough cost allocation.
+	Estimated bool
+}
+
+// UploadDetail summarises media uploads performed while preparing a request.
+// It is zero for providers that do not upload attachments.
+type UploadDetail struct {
+	Count          int64         // Successful uploads
+	Failed         int64         // Uploads that failed at any step
+	PresignLatency time.Duration // Total time spent obtaining presigned URLs
+	StorageLatency time.Duration // Total time spent uploading to object storage
+	ProcessingWait time.Duration // Total time spent waiting for the provider to process uploads
+	TotalLatency   time.Duration // Total time spent across all uploads
 }
 
 // Plugin consumes usage records emitted by the proxy runtime.